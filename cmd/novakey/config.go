@@ -2,22 +2,38 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
-
-	"gopkg.in/yaml.v3"
+	"sync/atomic"
 )
 
 type ServerConfig struct {
 	ListenAddr        string `json:"listen_addr" yaml:"listen_addr"`
 	MaxPayloadLen     int    `json:"max_payload_len" yaml:"max_payload_len"`
 	MaxRequestsPerMin int    `json:"max_requests_per_min" yaml:"max_requests_per_min"`
+
+	// /msg freshness windows (seconds). replay_cache_ttl_sec must cover
+	// max_msg_age_sec + max_clock_skew_sec, or an accepted nonce could be
+	// forgotten while its timestamp is still acceptable.
+	MaxClockSkewSec   int `json:"max_clock_skew_sec" yaml:"max_clock_skew_sec"`
+	MaxMsgAgeSec      int `json:"max_msg_age_sec" yaml:"max_msg_age_sec"`
+	ReplayCacheTTLSec int `json:"replay_cache_ttl_sec" yaml:"replay_cache_ttl_sec"`
+
 	DevicesFile       string `json:"devices_file" yaml:"devices_file"`
 	ServerKeysFile    string `json:"server_keys_file" yaml:"server_keys_file"`
 
+	// Append-only journal of accepted /msg nonces, sealed like the device
+	// store, so replays are still rejected after a restart. Empty = off.
+	ReplayJournalFile string `json:"replay_journal_file" yaml:"replay_journal_file"`
+
 	// require encrypted-at-rest device store on non-Windows
 	RequireSealedDeviceStore bool `json:"require_sealed_device_store" yaml:"require_sealed_device_store"`
 
@@ -25,6 +41,11 @@ type ServerConfig struct {
 	RotateKyberKeys         bool `json:"rotate_kyber_keys" yaml:"rotate_kyber_keys"`
 	RotateDevicePSKOnRepair bool `json:"rotate_device_psk_on_repair" yaml:"rotate_device_psk_on_repair"`
 	PairHelloMaxPerMin      int  `json:"pair_hello_max_per_min" yaml:"pair_hello_max_per_min"` // per-IP, /pair only (in-memory)
+	KyberKeyOverlapDays     int  `json:"kyber_key_overlap_days" yaml:"kyber_key_overlap_days"` // how long a rotated-out server key still decrypts
+
+	// Device key lifetime (see device_key_expiry.go). 0 = keys don't age out.
+	DeviceKeyMaxAgeDays int `json:"device_key_max_age_days" yaml:"device_key_max_age_days"`
+	DeviceKeyWarnDays   int `json:"device_key_warn_days" yaml:"device_key_warn_days"`
 
 	// --------------------
 	// Logging (optional)
@@ -54,14 +75,35 @@ type ServerConfig struct {
 	// - macos_prefer_clipboard: if true, macOS injection will try clipboard paste first, then optional AppleScript typing fallback.
 	MacOSPreferClipboard *bool `json:"macos_prefer_clipboard" yaml:"macos_prefer_clipboard"`
 
+	// Linux uinput backend (see uinput_linux.go): opt-in virtual keyboard
+	// for Wayland. uinput_keymap must match the session's keyboard layout.
+	LinuxUinputEnabled bool   `json:"linux_uinput_enabled" yaml:"linux_uinput_enabled"`
+	UinputKeymap       string `json:"uinput_keymap" yaml:"uinput_keymap"`
+	UinputKeyDelayMs   int    `json:"uinput_key_delay_ms" yaml:"uinput_key_delay_ms"`
+
+	// Injection backends to try, in order (see injector.go). Empty means the
+	// platform default.
+	InjectBackends []string `json:"inject_backends" yaml:"inject_backends"`
+
 	// Injection safety
 	AllowNewlines bool `json:"allow_newlines" yaml:"allow_newlines"`
 	MaxInjectLen  int  `json:"max_inject_len" yaml:"max_inject_len"`
 
 	// Two-man items
-	TwoManEnabled          *bool `json:"two_man_enabled" yaml:"two_man_enabled"`
-	ApproveWindowMs        int   `json:"approve_window_ms" yaml:"approve_window_ms"`
-	ApproveConsumeOnInject *bool `json:"approve_consume_on_inject" yaml:"approve_consume_on_inject"`
+	TwoManEnabled          *bool  `json:"two_man_enabled" yaml:"two_man_enabled"`
+	TwoManMode             string `json:"two_man_mode" yaml:"two_man_mode"` // self | dual
+	ApproveWindowMs        int    `json:"approve_window_ms" yaml:"approve_window_ms"`
+	ApproveConsumeOnInject *bool  `json:"approve_consume_on_inject" yaml:"approve_consume_on_inject"`
+
+	// M-of-N quorum (see quorum.go): when approval_quorum > 0, an inject needs
+	// that many distinct approvals from approver_group (or, if empty, from any
+	// other paired device that may approve).
+	ApprovalQuorum int      `json:"approval_quorum" yaml:"approval_quorum"`
+	ApproverGroup  []string `json:"approver_group" yaml:"approver_group"`
+
+	// request_scoped_approve: a gated inject gets a pending request ID back, and
+	// only an Approve naming that ID releases that exact inject.
+	RequestScopedApprove bool `json:"request_scoped_approve" yaml:"request_scoped_approve"`
 
 	// Target policy
 	TargetPolicyEnabled bool     `json:"target_policy_enabled" yaml:"target_policy_enabled"`
@@ -70,58 +112,181 @@ type ServerConfig struct {
 	AllowedWindowTitles []string `json:"allowed_window_titles" yaml:"allowed_window_titles"`
 	DeniedProcessNames  []string `json:"denied_process_names" yaml:"denied_process_names"`
 	DeniedWindowTitles  []string `json:"denied_window_titles" yaml:"denied_window_titles"`
-}
 
-var cfg ServerConfig
+	// Local control channel (see control.go): Unix socket path or Windows
+	// named pipe, or "off". network_arm_enabled=false leaves arming to it.
+	ControlSocket     string `json:"control_socket" yaml:"control_socket"`
+	NetworkArmEnabled *bool  `json:"network_arm_enabled" yaml:"network_arm_enabled"`
+
+	// Per-device policy profiles (see policy_profile.go). A device's profile
+	// name is stored with its key; devices without one use default_profile.
+	Profiles       map[string]PolicyProfile `json:"profiles" yaml:"profiles"`
+	DefaultProfile string                   `json:"default_profile" yaml:"default_profile"`
+
+	// LockedKeys may only be set in the base file. Drop-in layers cannot
+	// override any key listed here.
+	LockedKeys []string `json:"locked_keys" yaml:"locked_keys"`
 
-const (
-	defaultJSON = "server_config.json"
-	defaultYAML = "server_config.yaml"
-	defaultYML  = "server_config.yml"
+	// origins records which layer file (and line) set each top-level key, so
+	// validation and startup logging can tell file values from defaults.
+	origins map[string]configOrigin
+}
+
+// cfgCurrent holds the active configuration snapshot. It is swapped as a whole on
+// reload; callers take one snapshot per request and never mutate it.
+var (
+	cfgCurrent    atomic.Pointer[ServerConfig]
+	cfgPath       string
+	cfgPathSource string
 )
 
+// currentConfig returns the active configuration snapshot.
+func currentConfig() *ServerConfig {
+	if c := cfgCurrent.Load(); c != nil {
+		return c
+	}
+	return &ServerConfig{}
+}
+
 func loadConfig() error {
-	path := pickConfigPath()
+	path, source, err := resolveConfigPath()
+	if err != nil {
+		return err
+	}
+
+	c, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	cfgPath = path
+	cfgPathSource = source
+	cfgCurrent.Store(c)
+	return nil
+}
 
-	data, err := os.ReadFile(path)
+// readConfigFile parses and validates path without touching the active config.
+// Warnings are logged; any error rejects the whole file.
+func readConfigFile(path string) (*ServerConfig, error) {
+	c, rep, err := checkConfigFile(path)
 	if err != nil {
-		return fmt.Errorf("reading %s: %w", path, err)
+		return nil, err
+	}
+	for _, w := range rep.Warnings {
+		log.Printf("[config] warning: %s", w.format(path))
+	}
+	if len(rep.Errors) > 0 {
+		msgs := make([]string, 0, len(rep.Errors))
+		for _, e := range rep.Errors {
+			msgs = append(msgs, e.format(path))
+		}
+		return nil, fmt.Errorf("invalid config: %s", strings.Join(msgs, "; "))
 	}
 
+	resolveRelativePaths(c, filepath.Dir(path))
+	return c, nil
+}
+
+// checkConfigFile decodes the base config at path plus its drop-in layers,
+// applies defaults and validates the merged result. The returned error is only
+// set when a file can't be read at all; everything else is reported in the
+// configReport.
+func checkConfigFile(path string) (*ServerConfig, *configReport, error) {
 	ext := strings.ToLower(filepath.Ext(path))
-	switch ext {
-	case ".yaml", ".yml":
-		if err := yaml.Unmarshal(data, &cfg); err != nil {
-			return fmt.Errorf("parsing %s: %w", path, err)
+	if !isConfigExt(ext) {
+		return nil, nil, fmt.Errorf("unsupported config extension %q (use .json/.yaml/.yml)", ext)
+	}
+
+	layers, err := configLayerPaths(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rep := &configReport{}
+	merged := newConfigMerge()
+	for i, p := range layers {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %w", p, err)
 		}
-	case ".json":
-		if err := json.Unmarshal(data, &cfg); err != nil {
-			return fmt.Errorf("parsing %s: %w", path, err)
+
+		root, issues := parseConfigLayer(p, data)
+		if len(issues) > 0 {
+			rep.Errors = append(rep.Errors, issues...)
+			continue
 		}
-	default:
-		return fmt.Errorf("unsupported config extension %q (use .json/.yaml/.yml)", ext)
+		merged.apply(p, root, i == 0, rep)
+	}
+	if len(rep.Errors) > 0 {
+		return nil, rep, nil
 	}
 
-	applyDefaults()
-	return nil
+	var c ServerConfig
+	if err := merged.decode(&c); err != nil {
+		rep.Errors = append(rep.Errors, configIssue{Path: path, Msg: err.Error()})
+		return nil, rep, nil
+	}
+	c.origins = merged.origins
+
+	errs := validateExplicitZeros(&c)
+	applyDefaults(&c)
+	more, warns := validateConfig(&c)
+	errs = append(errs, more...)
+	for i := range errs {
+		errs[i].attachOrigin(c.origins)
+	}
+	for i := range warns {
+		warns[i].attachOrigin(c.origins)
+	}
+	rep.Errors = append(rep.Errors, errs...)
+	rep.Warnings = append(rep.Warnings, warns...)
+	return &c, rep, nil
+}
+
+func isConfigExt(ext string) bool {
+	switch ext {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+var (
+	yamlLineRe = regexp.MustCompile(`^line (\d+): (.*)$`)
+)
+
+func yamlIssue(path, msg string) configIssue {
+	is := configIssue{Path: path, Msg: msg}
+	if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+		is.Line, _ = strconv.Atoi(m[1])
+		is.Msg = m[2]
+	}
+	return is
 }
 
-func pickConfigPath() string {
-	if fileExists(defaultYAML) {
-		return defaultYAML
+// jsonSyntaxIssue reports JSON syntax errors with a line number. YAML parsing
+// accepts JSON, but is more lenient than a JSON parser.
+func jsonSyntaxIssue(path string, data []byte) *configIssue {
+	var v any
+	err := json.Unmarshal(data, &v)
+	if err == nil {
+		return nil
 	}
-	if fileExists(defaultYML) {
-		return defaultYML
+	var se *json.SyntaxError
+	if errors.As(err, &se) {
+		return &configIssue{Path: path, Line: lineAtOffset(data, se.Offset), Msg: se.Error()}
 	}
-	return defaultJSON
+	return &configIssue{Path: path, Msg: err.Error()}
 }
 
-func fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
+func lineAtOffset(data []byte, off int64) int {
+	if off > int64(len(data)) {
+		off = int64(len(data))
+	}
+	return bytes.Count(data[:off], []byte("\n")) + 1
 }
 
-func applyDefaults() {
+func applyDefaults(cfg *ServerConfig) {
 	if cfg.ListenAddr == "" {
 		cfg.ListenAddr = "0.0.0.0:60768"
 	}
@@ -131,6 +296,15 @@ func applyDefaults() {
 	if cfg.MaxRequestsPerMin == 0 {
 		cfg.MaxRequestsPerMin = 60
 	}
+	if cfg.MaxClockSkewSec == 0 {
+		cfg.MaxClockSkewSec = defaultMaxClockSkewSec
+	}
+	if cfg.MaxMsgAgeSec == 0 {
+		cfg.MaxMsgAgeSec = defaultMaxMsgAgeSec
+	}
+	if cfg.ReplayCacheTTLSec == 0 {
+		cfg.ReplayCacheTTLSec = defaultReplayCacheTTL
+	}
 	if cfg.DevicesFile == "" {
 		cfg.DevicesFile = "devices.json"
 	}
@@ -142,6 +316,10 @@ func applyDefaults() {
 	if cfg.PairHelloMaxPerMin == 0 {
 		cfg.PairHelloMaxPerMin = 30
 	}
+	// An explicit 0 means no overlap: a retired key stops decrypting at once.
+	if _, set := cfg.origins["kyber_key_overlap_days"]; !set && cfg.KyberKeyOverlapDays == 0 {
+		cfg.KyberKeyOverlapDays = 30
+	}
 
 	// Logging defaults
 	if cfg.LogRotateMB == 0 {
@@ -168,6 +346,13 @@ func applyDefaults() {
 	if cfg.ArmDurationMs == 0 {
 		cfg.ArmDurationMs = 20000
 	}
+	if cfg.ControlSocket == "" {
+		cfg.ControlSocket = defaultControlSocket()
+	}
+	if cfg.NetworkArmEnabled == nil {
+		v := true
+		cfg.NetworkArmEnabled = &v
+	}
 	if cfg.ArmConsumeOnInject == nil {
 		v := true
 		cfg.ArmConsumeOnInject = &v
@@ -197,11 +382,25 @@ func applyDefaults() {
 	}
 
 	// Safety defaults
+	if cfg.UinputKeymap == "" {
+		cfg.UinputKeymap = defaultUinputKeymap
+	}
+	if cfg.UinputKeyDelayMs == 0 {
+		cfg.UinputKeyDelayMs = 8
+	}
 	if cfg.MaxInjectLen == 0 {
 		cfg.MaxInjectLen = 256
 	}
 
+	// An explicit 0 turns the warning window off.
+	if _, set := cfg.origins["device_key_warn_days"]; !set && cfg.DeviceKeyWarnDays == 0 {
+		cfg.DeviceKeyWarnDays = 14
+	}
+
 	// Two-man defaults
+	if cfg.TwoManMode == "" {
+		cfg.TwoManMode = TwoManModeSelf
+	}
 	if cfg.ApproveWindowMs == 0 {
 		cfg.ApproveWindowMs = 15000
 	}
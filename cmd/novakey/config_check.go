@@ -0,0 +1,61 @@
+// cmd/novakey/config_check.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func init() {
+	// One-shot command: `novakey config check [path]`
+	if len(os.Args) >= 3 && os.Args[1] == "config" && os.Args[2] == "check" {
+		os.Exit(runConfigCheck(os.Args[3:]))
+	}
+}
+
+// runConfigCheck prints line-numbered findings for a config file and returns
+// the process exit code: 0 when the file is usable, 1 otherwise. Without a
+// path it checks the file the daemon would load (-config, NOVAKEY_CONFIG,
+// then the search path).
+func runConfigCheck(args []string) int {
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	fs.StringVar(&configFlagPath, "config", "", "path to server config file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "usage: novakey config check [-config path] [path]")
+		return 2
+	}
+
+	path := fs.Arg(0)
+	if path == "" {
+		p, _, err := resolveConfigPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config check: %v\n", err)
+			return 1
+		}
+		path = p
+	}
+
+	_, rep, err := checkConfigFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config check: %v\n", err)
+		return 1
+	}
+
+	for _, e := range rep.Errors {
+		fmt.Fprintf(os.Stdout, "%s: error: %s\n", e.location(path), e.message())
+	}
+	for _, w := range rep.Warnings {
+		fmt.Fprintf(os.Stdout, "%s: warning: %s\n", w.location(path), w.message())
+	}
+
+	if len(rep.Errors) > 0 {
+		fmt.Fprintf(os.Stdout, "config check: %s: %d error(s), %d warning(s)\n", path, len(rep.Errors), len(rep.Warnings))
+		return 1
+	}
+	fmt.Fprintf(os.Stdout, "config check: %s: OK (%d warning(s))\n", path, len(rep.Warnings))
+	return 0
+}
@@ -0,0 +1,352 @@
+// cmd/novakey/config_layers.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Layered config:
+//
+//   server_config.yaml        base (system) layer
+//   server_config.d/*.yaml    drop-ins, applied in file-name order
+//
+// A key in a later layer replaces the earlier value. For list keys, "key+"
+// appends to the earlier value instead. Keys named in the base file's
+// locked_keys cannot be set by drop-ins, directly or as a field of a policy
+// profile; such entries are ignored with a warning so a user layer can't
+// loosen system policy. profiles is merged per profile and per field instead
+// of replaced, so a drop-in can't drop a locked field from a base profile.
+
+// configAppendSuffix marks a list key whose value is appended, not replaced.
+const configAppendSuffix = "+"
+
+// configOrigin is where a top-level key's effective value was set.
+type configOrigin struct {
+	Path string
+	Line int
+}
+
+func (o configOrigin) String() string {
+	return fmt.Sprintf("%s:%d", o.Path, o.Line)
+}
+
+// configDropInDir returns the drop-in directory for a base config path
+// (server_config.yaml -> server_config.d).
+func configDropInDir(base string) string {
+	return strings.TrimSuffix(base, filepath.Ext(base)) + ".d"
+}
+
+// configLayerPaths returns the base config followed by its drop-ins sorted by
+// file name. A missing drop-in directory is not an error.
+func configLayerPaths(base string) ([]string, error) {
+	paths := []string{base}
+
+	dir := configDropInDir(base)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return paths, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", dir, err)
+	}
+
+	// os.ReadDir already sorts by file name.
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !isConfigExt(strings.ToLower(filepath.Ext(name))) {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	return paths, nil
+}
+
+// configFields maps every top-level yaml key of ServerConfig to its field.
+func configFields() map[string]reflect.StructField {
+	return structFields(reflect.TypeOf(ServerConfig{}))
+}
+
+func structFields(t reflect.Type) map[string]reflect.StructField {
+	out := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		out[key] = f
+	}
+	return out
+}
+
+// parseConfigLayer parses one layer file into its top-level mapping node and
+// reports unknown keys and type errors with line numbers. A nil node with no
+// issues means the file is empty.
+func parseConfigLayer(path string, data []byte) (*yaml.Node, []configIssue) {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if is := jsonSyntaxIssue(path, data); is != nil {
+			return nil, []configIssue{*is}
+		}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, []configIssue{yamlIssue(path, strings.TrimPrefix(err.Error(), "yaml: "))}
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, []configIssue{{Path: path, Line: root.Line, Msg: "top level must be a mapping of config keys"}}
+	}
+
+	var issues []configIssue
+	fields := configFields()
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		k, v := root.Content[i], root.Content[i+1]
+		name, appendMode := strings.CutSuffix(k.Value, configAppendSuffix)
+
+		f, ok := fields[name]
+		if !ok {
+			issues = append(issues, configIssue{Path: path, Line: k.Line, Key: name, Msg: fmt.Sprintf("unknown field %q", name)})
+			continue
+		}
+		if appendMode && f.Type.Kind() != reflect.Slice {
+			issues = append(issues, configIssue{Path: path, Line: k.Line, Key: name, Msg: fmt.Sprintf("%q only applies to list keys", k.Value)})
+			continue
+		}
+		issues = append(issues, checkNodeFields(path, name, v, f.Type)...)
+
+		// Decode into a throwaway value to surface type errors with lines.
+		if err := v.Decode(reflect.New(f.Type).Interface()); err != nil {
+			var te *yaml.TypeError
+			if errors.As(err, &te) {
+				for _, m := range te.Errors {
+					is := yamlIssue(path, m)
+					is.Key = name
+					issues = append(issues, is)
+				}
+			} else {
+				issues = append(issues, configIssue{Path: path, Line: v.Line, Key: name, Msg: err.Error()})
+			}
+		}
+	}
+	return root, issues
+}
+
+// checkNodeFields reports unknown keys inside nested struct values (yaml.v3
+// only enforces known fields when decoding from a Decoder, not from a Node).
+func checkNodeFields(path, key string, n *yaml.Node, t reflect.Type) []configIssue {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var issues []configIssue
+	switch {
+	case t.Kind() == reflect.Struct && n.Kind == yaml.MappingNode:
+		fields := structFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			f, ok := fields[k.Value]
+			if !ok {
+				issues = append(issues, configIssue{Path: path, Line: k.Line, Key: key, Msg: fmt.Sprintf("%s: unknown field %q", key, k.Value)})
+				continue
+			}
+			issues = append(issues, checkNodeFields(path, key, v, f.Type)...)
+		}
+	case t.Kind() == reflect.Map && n.Kind == yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			issues = append(issues, checkNodeFields(path, key, n.Content[i], t.Elem())...)
+		}
+	case t.Kind() == reflect.Slice && n.Kind == yaml.SequenceNode:
+		for _, c := range n.Content {
+			issues = append(issues, checkNodeFields(path, key, c, t.Elem())...)
+		}
+	}
+	return issues
+}
+
+// configMerge accumulates layers into one top-level mapping.
+type configMerge struct {
+	order   []string
+	values  map[string]*yaml.Node
+	origins map[string]configOrigin
+	locked  map[string]bool
+}
+
+func newConfigMerge() *configMerge {
+	return &configMerge{
+		values:  map[string]*yaml.Node{},
+		origins: map[string]configOrigin{},
+		locked:  map[string]bool{},
+	}
+}
+
+// apply merges one parsed layer. The base layer may set locked_keys; every
+// later layer is checked against it.
+func (m *configMerge) apply(path string, root *yaml.Node, isBase bool, rep *configReport) {
+	if root == nil {
+		return
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		k, v := root.Content[i], root.Content[i+1]
+		name, appendMode := strings.CutSuffix(k.Value, configAppendSuffix)
+
+		if !isBase && (name == "locked_keys" || m.locked[name]) {
+			rep.Warnings = append(rep.Warnings, configIssue{
+				Path: path, Line: k.Line, Key: name,
+				Msg: fmt.Sprintf("%s is locked by the base config; drop-in value ignored", name),
+			})
+			continue
+		}
+
+		prev, seen := m.values[name]
+		if !isBase && name == "profiles" {
+			v = m.dropLockedProfileFields(path, v, rep)
+			if seen {
+				v = mergeProfiles(prev, v)
+			}
+		}
+		if !seen {
+			m.order = append(m.order, name)
+		}
+		if appendMode && seen && prev.Kind == yaml.SequenceNode && v.Kind == yaml.SequenceNode {
+			joined := *prev
+			joined.Content = append(append([]*yaml.Node{}, prev.Content...), v.Content...)
+			v = &joined
+		}
+		m.values[name] = v
+		m.origins[name] = configOrigin{Path: path, Line: k.Line}
+	}
+
+	if !isBase {
+		return
+	}
+	n, ok := m.values["locked_keys"]
+	if !ok {
+		return
+	}
+	var locked []string
+	if err := n.Decode(&locked); err != nil {
+		return // already reported by parseConfigLayer
+	}
+	fields := configFields()
+	for _, key := range locked {
+		if _, ok := fields[key]; !ok {
+			rep.Errors = append(rep.Errors, configIssue{
+				Path: path, Line: n.Line, Key: "locked_keys",
+				Msg: fmt.Sprintf("locked_keys: unknown field %q", key),
+			})
+			continue
+		}
+		m.locked[key] = true
+	}
+}
+
+// dropLockedProfileFields removes fields that override a locked key from the
+// profiles a drop-in defines. Profile fields use the same names as the
+// top-level keys they override, so without this a drop-in could loosen a
+// locked key for every device through profiles plus default_profile.
+func (m *configMerge) dropLockedProfileFields(path string, v *yaml.Node, rep *configReport) *yaml.Node {
+	if v.Kind != yaml.MappingNode || len(m.locked) == 0 {
+		return v
+	}
+	out := *v
+	out.Content = make([]*yaml.Node, 0, len(v.Content))
+	for i := 0; i+1 < len(v.Content); i += 2 {
+		name, p := v.Content[i], v.Content[i+1]
+		if p.Kind == yaml.MappingNode {
+			kept := *p
+			kept.Content = nil
+			for j := 0; j+1 < len(p.Content); j += 2 {
+				field := p.Content[j]
+				if m.locked[field.Value] {
+					rep.Warnings = append(rep.Warnings, configIssue{
+						Path: path, Line: field.Line, Key: "profiles",
+						Msg: fmt.Sprintf("profiles.%s.%s overrides locked key %s; drop-in value ignored", name.Value, field.Value, field.Value),
+					})
+					continue
+				}
+				kept.Content = append(kept.Content, field, p.Content[j+1])
+			}
+			p = &kept
+		}
+		out.Content = append(out.Content, name, p)
+	}
+	return &out
+}
+
+// mergeProfiles merges a drop-in's profiles into the earlier value by profile
+// name and then by field, so fields the drop-in doesn't set (including locked
+// ones it was not allowed to set) keep their earlier value.
+func mergeProfiles(prev, v *yaml.Node) *yaml.Node {
+	if prev.Kind != yaml.MappingNode || v.Kind != yaml.MappingNode {
+		return v
+	}
+	out := *prev
+	out.Content = append([]*yaml.Node{}, prev.Content...)
+	for i := 0; i+1 < len(v.Content); i += 2 {
+		name, p := v.Content[i], v.Content[i+1]
+		j := mappingIndex(&out, name.Value)
+		if j < 0 {
+			out.Content = append(out.Content, name, p)
+			continue
+		}
+		if old := out.Content[j+1]; old.Kind == yaml.MappingNode && p.Kind == yaml.MappingNode {
+			p = mergeMapping(old, p)
+		}
+		out.Content[j+1] = p
+	}
+	return &out
+}
+
+// mergeMapping returns prev with v's keys set over it.
+func mergeMapping(prev, v *yaml.Node) *yaml.Node {
+	out := *prev
+	out.Content = append([]*yaml.Node{}, prev.Content...)
+	for i := 0; i+1 < len(v.Content); i += 2 {
+		if j := mappingIndex(&out, v.Content[i].Value); j >= 0 {
+			out.Content[j+1] = v.Content[i+1]
+			continue
+		}
+		out.Content = append(out.Content, v.Content[i], v.Content[i+1])
+	}
+	return &out
+}
+
+// mappingIndex returns the index of key in a mapping node's Content, or -1.
+func mappingIndex(n *yaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// decode decodes the merged mapping into c.
+func (m *configMerge) decode(c *ServerConfig) error {
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, name := range m.order {
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name},
+			m.values[name])
+	}
+	return root.Decode(c)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeDropIn(t *testing.T, base, name, body string) {
+	t.Helper()
+	dir := configDropInDir(base)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckConfigFile_DropInsReplaceAndAppend(t *testing.T) {
+	base := writeTempConfig(t, "server_config.yaml", `listen_addr: "127.0.0.1:60768"
+arm_duration_ms: 20000
+allowed_process_names: ["firefox"]
+denied_window_titles: ["a"]
+`)
+	writeDropIn(t, base, "10-user.yaml", `arm_duration_ms: 5000
+allowed_process_names+: ["chrome"]
+`)
+	writeDropIn(t, base, "20-user.json", `{"denied_window_titles": ["b"]}`)
+
+	c, rep, err := checkConfigFile(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Errors) != 0 {
+		t.Fatalf("unexpected errors: %+v", rep.Errors)
+	}
+	if c.ArmDurationMs != 5000 {
+		t.Fatalf("arm_duration_ms=%d, want 5000", c.ArmDurationMs)
+	}
+	if want := []string{"firefox", "chrome"}; !reflect.DeepEqual(c.AllowedProcessNames, want) {
+		t.Fatalf("allowed_process_names=%v, want %v", c.AllowedProcessNames, want)
+	}
+	if want := []string{"b"}; !reflect.DeepEqual(c.DeniedWindowTitles, want) {
+		t.Fatalf("denied_window_titles=%v, want %v", c.DeniedWindowTitles, want)
+	}
+	if o := c.origins["arm_duration_ms"]; filepath.Base(o.Path) != "10-user.yaml" || o.Line != 1 {
+		t.Fatalf("arm_duration_ms origin=%v", o)
+	}
+}
+
+func TestCheckConfigFile_DropInCannotOverrideLockedKey(t *testing.T) {
+	base := writeTempConfig(t, "server_config.yaml", `listen_addr: "127.0.0.1:60768"
+require_sealed_device_store: true
+two_man_enabled: true
+locked_keys: [require_sealed_device_store, two_man_enabled]
+`)
+	writeDropIn(t, base, "50-user.yaml", `two_man_enabled: false
+locked_keys: []
+`)
+
+	c, rep, err := checkConfigFile(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Errors) != 0 {
+		t.Fatalf("unexpected errors: %+v", rep.Errors)
+	}
+	if !boolDeref(c.TwoManEnabled, false) {
+		t.Fatal("drop-in overrode locked two_man_enabled")
+	}
+
+	ignored := map[string]bool{}
+	for _, w := range rep.Warnings {
+		if filepath.Base(w.Path) == "50-user.yaml" {
+			ignored[w.Key] = true
+		}
+	}
+	if !ignored["two_man_enabled"] || !ignored["locked_keys"] {
+		t.Fatalf("expected warnings for ignored drop-in keys, got %+v", rep.Warnings)
+	}
+}
+
+func TestCheckConfigFile_DropInProfileCannotLoosenLockedKey(t *testing.T) {
+	base := writeTempConfig(t, "server_config.yaml", `listen_addr: "127.0.0.1:60768"
+two_man_enabled: true
+two_man_mode: dual
+locked_keys: [two_man_enabled, two_man_mode]
+`)
+	writeDropIn(t, base, "50-user.yaml", `default_profile: loose
+profiles:
+  loose:
+    two_man_enabled: false
+    two_man_mode: self
+    max_inject_len: 64
+`)
+
+	c, rep, err := checkConfigFile(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Errors) != 0 {
+		t.Fatalf("unexpected errors: %+v", rep.Errors)
+	}
+	withTestDevices(t, map[string]deviceState{"phone": {id: "phone"}})
+	eff, name, err := policyForDevice(c, "phone")
+	if err != nil || name != "loose" {
+		t.Fatalf("policyForDevice = %q, %v", name, err)
+	}
+	if !boolDeref(eff.TwoManEnabled, false) || eff.TwoManMode != TwoManModeDual {
+		t.Fatalf("profile loosened locked keys: two_man_enabled=%v two_man_mode=%q", boolDeref(eff.TwoManEnabled, false), eff.TwoManMode)
+	}
+	if eff.MaxInjectLen != 64 {
+		t.Fatalf("unlocked profile field dropped: max_inject_len=%d", eff.MaxInjectLen)
+	}
+
+	n := 0
+	for _, w := range rep.Warnings {
+		if w.Key == "profiles" && filepath.Base(w.Path) == "50-user.yaml" {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 warnings for ignored profile fields, got %+v", rep.Warnings)
+	}
+}
+
+func TestCheckConfigFile_DropInProfileKeepsLockedBaseField(t *testing.T) {
+	base := writeTempConfig(t, "server_config.yaml", `listen_addr: "127.0.0.1:60768"
+allowed_process_names: [firefox, chrome, code]
+locked_keys: [allowed_process_names]
+profiles:
+  team:
+    allowed_process_names: [firefox]
+`)
+	writeDropIn(t, base, "50-user.yaml", `profiles:
+  team:
+    allow_newlines: true
+`)
+
+	c, rep, err := checkConfigFile(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Errors) != 0 {
+		t.Fatalf("unexpected errors: %+v", rep.Errors)
+	}
+	team := c.Profiles["team"]
+	if !reflect.DeepEqual(team.AllowedProcessNames, []string{"firefox"}) {
+		t.Fatalf("locked profile field lost: allowed_process_names=%v", team.AllowedProcessNames)
+	}
+	if !boolDeref(team.AllowNewlines, false) {
+		t.Fatalf("drop-in profile field not applied: allow_newlines=%v", team.AllowNewlines)
+	}
+}
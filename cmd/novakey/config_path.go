@@ -0,0 +1,152 @@
+// cmd/novakey/config_path.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultJSON = "server_config.json"
+	defaultYAML = "server_config.yaml"
+	defaultYML  = "server_config.yml"
+
+	envConfigPath = "NOVAKEY_CONFIG"
+)
+
+// configFlagPath is set by -config (see parseDaemonFlags).
+var configFlagPath string
+
+// parseDaemonFlags parses the daemon's command-line flags.
+func parseDaemonFlags() {
+	flag.StringVar(&configFlagPath, "config", "", "path to server_config.yaml/.yml/.json (overrides "+envConfigPath+" and the search path)")
+	flag.Parse()
+}
+
+// resolveConfigPath picks the config file and reports where the choice came from.
+//
+// Order:
+//  1. -config flag
+//  2. NOVAKEY_CONFIG environment variable
+//  3. first server_config.yaml/.yml/.json found in: the working directory,
+//     $XDG_CONFIG_HOME/novakey (os.UserConfigDir), /etc/novakey (non-Windows)
+func resolveConfigPath() (path string, source string, err error) {
+	if p := strings.TrimSpace(configFlagPath); p != "" {
+		return absOrSelf(p), "-config flag", nil
+	}
+	if p := strings.TrimSpace(os.Getenv(envConfigPath)); p != "" {
+		return absOrSelf(p), envConfigPath, nil
+	}
+
+	dirs := configSearchDirs()
+	for _, dir := range dirs {
+		for _, name := range []string{defaultYAML, defaultYML, defaultJSON} {
+			p := filepath.Join(dir, name)
+			if fileExists(p) {
+				return absOrSelf(p), "search path (" + dir + ")", nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("no %s/%s/%s found (searched %s; use -config or %s)",
+		defaultYAML, defaultYML, defaultJSON, strings.Join(dirs, ", "), envConfigPath)
+}
+
+func configSearchDirs() []string {
+	dirs := []string{"."}
+	if ucd, err := os.UserConfigDir(); err == nil && ucd != "" {
+		dirs = append(dirs, filepath.Join(ucd, "novakey"))
+	}
+	if runtime.GOOS != "windows" {
+		dirs = append(dirs, "/etc/novakey")
+	}
+	return dirs
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func absOrSelf(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// resolveRelativePaths anchors relative file paths in c to the config file's
+// directory instead of the process working directory.
+func resolveRelativePaths(c *ServerConfig, baseDir string) {
+	for _, p := range []*string{&c.DevicesFile, &c.ServerKeysFile, &c.ReplayJournalFile, &c.LogFile, &c.LogDir, &c.ControlSocket} {
+		v := strings.TrimSpace(*p)
+		if v == "" || filepath.IsAbs(v) || strings.EqualFold(v, controlOff) {
+			continue
+		}
+		*p = filepath.Join(baseDir, v)
+	}
+}
+
+// logEffectiveConfig logs the chosen config file and its drop-ins and, for
+// every setting, its effective value and which layer (or default) it came from.
+func logEffectiveConfig() {
+	c := currentConfig()
+	log.Printf("[config] using %s (from %s)", cfgPath, cfgPathSource)
+	if layers, err := configLayerPaths(cfgPath); err == nil {
+		for _, p := range layers[1:] {
+			log.Printf("[config] drop-in %s", p)
+		}
+	}
+	if len(c.LockedKeys) > 0 {
+		log.Printf("[config] locked keys: %s", strings.Join(c.LockedKeys, ", "))
+	}
+
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+
+		source := "default"
+		if o, ok := c.origins[key]; ok {
+			source = o.String()
+		}
+		log.Printf("[config] %s=%s (%s)", key, formatConfigValue(v.Field(i)), source)
+	}
+}
+
+func formatConfigValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return "<unset>"
+		}
+		return formatConfigValue(v.Elem())
+	case reflect.Slice:
+		parts := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			parts = append(parts, fmt.Sprint(v.Index(i).Interface()))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, fmt.Sprint(k.Interface()))
+		}
+		sort.Strings(keys)
+		return "{" + strings.Join(keys, ", ") + "}"
+	case reflect.String:
+		return fmt.Sprintf("%q", v.String())
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
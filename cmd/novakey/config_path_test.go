@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveConfigPath_FlagBeatsEnv(t *testing.T) {
+	envPath := writeTempConfig(t, "env.yaml", "")
+	flagPath := writeTempConfig(t, "flag.yaml", "")
+	t.Setenv(envConfigPath, envPath)
+
+	configFlagPath = ""
+	got, src, err := resolveConfigPath()
+	if err != nil || got != envPath || src != envConfigPath {
+		t.Fatalf("env: got %q (%s, %v)", got, src, err)
+	}
+
+	configFlagPath = flagPath
+	defer func() { configFlagPath = "" }()
+	got, _, err = resolveConfigPath()
+	if err != nil || got != flagPath {
+		t.Fatalf("flag: got %q (%v)", got, err)
+	}
+}
+
+func TestReadConfigFile_ResolvesRelativePathsAgainstConfigDir(t *testing.T) {
+	path := writeTempConfig(t, "server_config.yaml", "devices_file: \"state/devices.json\"\nlog_dir: \"/var/log/novakey\"\n")
+	dir := filepath.Dir(path)
+
+	c, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "state", "devices.json"); c.DevicesFile != want {
+		t.Fatalf("devices_file=%q want %q", c.DevicesFile, want)
+	}
+	if want := filepath.Join(dir, "server_keys.json"); c.ServerKeysFile != want {
+		t.Fatalf("server_keys_file=%q want %q", c.ServerKeysFile, want)
+	}
+	if c.LogDir != "/var/log/novakey" {
+		t.Fatalf("absolute log_dir rewritten: %q", c.LogDir)
+	}
+}
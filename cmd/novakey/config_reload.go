@@ -0,0 +1,136 @@
+// cmd/novakey/config_reload.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const configPollInterval = 2 * time.Second
+
+var reloadMu sync.Mutex
+
+// startConfigWatcher reloads the config file when it changes on disk or when
+// the platform reload signal (SIGHUP on Unix) arrives.
+func startConfigWatcher() {
+	if cfgPath == "" {
+		return
+	}
+
+	watchReloadSignal(func(reason string) {
+		_ = reloadConfig(reason)
+	})
+
+	go func() {
+		last := configFileStamp(cfgPath)
+		for {
+			time.Sleep(configPollInterval)
+			cur := configFileStamp(cfgPath)
+			if cur == last {
+				continue
+			}
+			last = cur
+			_ = reloadConfig("file changed")
+		}
+	}()
+	log.Printf("[config] watching %s for changes", cfgPath)
+}
+
+// configFileStamp is a cheap change detector (mtime + size of the base file and
+// every drop-in). An empty string means the base file is currently missing,
+// which we treat as "no usable change".
+func configFileStamp(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	layers, err := configLayerPaths(path)
+	if err != nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	for _, p := range layers {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s=%d:%d;", p, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return sb.String()
+}
+
+// reloadConfig parses and validates the config file and, if it is valid, swaps
+// it in as the new snapshot. An invalid file is rejected and the running config
+// stays in place.
+func reloadConfig(reason string) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next, err := readConfigFile(cfgPath)
+	if err != nil {
+		log.Printf("[config] reload (%s) rejected: %v; keeping running config", reason, err)
+		return err
+	}
+
+	prev := currentConfig()
+	for _, f := range pinRestartOnlyFields(prev, next) {
+		log.Printf("[config] %s: restart required; keeping running value", f)
+	}
+
+	cfgCurrent.Store(next)
+	log.Printf("[config] reloaded %s (%s)", cfgPath, reason)
+	return nil
+}
+
+// pinRestartOnlyFields copies settings that are consumed once at startup from
+// prev into next, so a reload never half-applies them. It returns a description
+// of every field whose on-disk value differs from the running one.
+func pinRestartOnlyFields(prev, next *ServerConfig) []string {
+	var changed []string
+
+	pinString := func(name string, p, n *string) {
+		if *p != *n {
+			changed = append(changed, fmt.Sprintf("%s changed (running=%q file=%q)", name, *p, *n))
+			*n = *p
+		}
+	}
+	pinInt := func(name string, p, n *int) {
+		if *p != *n {
+			changed = append(changed, fmt.Sprintf("%s changed (running=%d file=%d)", name, *p, *n))
+			*n = *p
+		}
+	}
+	pinBool := func(name string, p, n *bool) {
+		if *p != *n {
+			changed = append(changed, fmt.Sprintf("%s changed (running=%t file=%t)", name, *p, *n))
+			*n = *p
+		}
+	}
+	pinBoolPtr := func(name string, p, n **bool) {
+		pv, nv := boolDeref(*p, true), boolDeref(*n, true)
+		if pv != nv {
+			changed = append(changed, fmt.Sprintf("%s changed (running=%t file=%t)", name, pv, nv))
+		}
+		*n = *p
+	}
+
+	pinString("listen_addr", &prev.ListenAddr, &next.ListenAddr)
+	pinString("devices_file", &prev.DevicesFile, &next.DevicesFile)
+	pinString("server_keys_file", &prev.ServerKeysFile, &next.ServerKeysFile)
+	pinString("replay_journal_file", &prev.ReplayJournalFile, &next.ReplayJournalFile)
+	pinBool("rotate_kyber_keys", &prev.RotateKyberKeys, &next.RotateKyberKeys)
+	pinInt("kyber_key_overlap_days", &prev.KyberKeyOverlapDays, &next.KyberKeyOverlapDays)
+	pinString("control_socket", &prev.ControlSocket, &next.ControlSocket)
+
+	pinString("log_file", &prev.LogFile, &next.LogFile)
+	pinString("log_dir", &prev.LogDir, &next.LogDir)
+	pinInt("log_rotate_mb", &prev.LogRotateMB, &next.LogRotateMB)
+	pinInt("log_keep", &prev.LogKeep, &next.LogKeep)
+	pinBoolPtr("log_stderr", &prev.LogStderr, &next.LogStderr)
+
+	return changed
+}
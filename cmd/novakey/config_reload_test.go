@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPinRestartOnlyFields_KeepsRunningValues(t *testing.T) {
+	prev := &ServerConfig{ListenAddr: "127.0.0.1:60768", DevicesFile: "devices.json", ArmDurationMs: 20000}
+	next := &ServerConfig{ListenAddr: "0.0.0.0:60768", DevicesFile: "devices.json", ArmDurationMs: 5000}
+
+	changed := pinRestartOnlyFields(prev, next)
+	if len(changed) != 1 {
+		t.Fatalf("expected 1 restart-required field, got %d: %v", len(changed), changed)
+	}
+	if next.ListenAddr != prev.ListenAddr {
+		t.Fatalf("listen_addr was half-applied: %q", next.ListenAddr)
+	}
+	if next.ArmDurationMs != 5000 {
+		t.Fatalf("live field arm_duration_ms not applied: %d", next.ArmDurationMs)
+	}
+}
+
+func TestReloadConfig_RejectsInvalidFileAndKeepsSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server_config.yaml")
+
+	if err := os.WriteFile(path, []byte("arm_duration_ms: 1234\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	prevPath, prevCfg := cfgPath, cfgCurrent.Load()
+	t.Cleanup(func() {
+		cfgPath = prevPath
+		cfgCurrent.Store(prevCfg)
+	})
+	cfgPath = path
+	if err := reloadConfig("test"); err != nil {
+		t.Fatalf("reload valid: %v", err)
+	}
+	if got := currentConfig().ArmDurationMs; got != 1234 {
+		t.Fatalf("arm_duration_ms=%d want 1234", got)
+	}
+
+	if err := os.WriteFile(path, []byte("arm_duration_ms: [oops\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig("test"); err == nil {
+		t.Fatalf("expected reload of invalid file to fail")
+	}
+	if got := currentConfig().ArmDurationMs; got != 1234 {
+		t.Fatalf("snapshot changed after rejected reload: arm_duration_ms=%d", got)
+	}
+}
@@ -0,0 +1,21 @@
+// cmd/novakey/config_reload_unix.go
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchReloadSignal calls trigger on every SIGHUP.
+func watchReloadSignal(trigger func(reason string)) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			trigger("SIGHUP")
+		}
+	}()
+}
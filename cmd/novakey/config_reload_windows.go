@@ -0,0 +1,7 @@
+// cmd/novakey/config_reload_windows.go
+//go:build windows
+
+package main
+
+// watchReloadSignal is a no-op on Windows (no SIGHUP); the file watcher still applies.
+func watchReloadSignal(trigger func(reason string)) {}
@@ -0,0 +1,252 @@
+// cmd/novakey/config_validate.go
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// configIssue is one finding from config validation. Key is the top-level
+// config key the finding refers to (empty for syntax errors). Path and Line
+// point at the layer file that set the value; Line is 0 when the value came
+// from a default rather than a file.
+type configIssue struct {
+	Path string
+	Line int
+	Key  string
+	Msg  string
+}
+
+type configReport struct {
+	Errors   []configIssue
+	Warnings []configIssue
+}
+
+func (i configIssue) location(path string) string {
+	if i.Path != "" {
+		path = i.Path
+	}
+	if i.Line > 0 {
+		return fmt.Sprintf("%s:%d", path, i.Line)
+	}
+	return path
+}
+
+func (i configIssue) message() string {
+	if i.Key != "" && !strings.Contains(i.Msg, i.Key) {
+		return i.Key + ": " + i.Msg
+	}
+	return i.Msg
+}
+
+func (i configIssue) format(path string) string {
+	return i.location(path) + ": " + i.message()
+}
+
+func (i *configIssue) attachOrigin(origins map[string]configOrigin) {
+	if o, ok := origins[i.Key]; ok {
+		i.Path, i.Line = o.Path, o.Line
+	}
+}
+
+// validateExplicitZeros checks c before applyDefaults, which reads 0 as
+// "unset". A key written as 0 in a file that must be above zero is reported
+// instead of silently becoming its default.
+func validateExplicitZeros(c *ServerConfig) (errs []configIssue) {
+	for _, f := range []struct {
+		key string
+		v   int
+	}{
+		{"max_payload_len", c.MaxPayloadLen},
+		{"max_requests_per_min", c.MaxRequestsPerMin},
+		{"max_clock_skew_sec", c.MaxClockSkewSec},
+		{"max_msg_age_sec", c.MaxMsgAgeSec},
+		{"replay_cache_ttl_sec", c.ReplayCacheTTLSec},
+		{"pair_hello_max_per_min", c.PairHelloMaxPerMin},
+		{"log_rotate_mb", c.LogRotateMB},
+		{"log_keep", c.LogKeep},
+		{"arm_duration_ms", c.ArmDurationMs},
+		{"approve_window_ms", c.ApproveWindowMs},
+		{"max_inject_len", c.MaxInjectLen},
+		{"uinput_key_delay_ms", c.UinputKeyDelayMs},
+	} {
+		if _, set := c.origins[f.key]; set && f.v == 0 {
+			errs = append(errs, configIssue{Key: f.key, Msg: "must be above zero, got 0"})
+		}
+	}
+	return errs
+}
+
+// validateConfig range-checks c after defaults were applied. Errors make the
+// config unusable; warnings flag combinations that are legal but risky.
+func validateConfig(c *ServerConfig) (errs, warns []configIssue) {
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, configIssue{Key: key, Msg: fmt.Sprintf(format, args...)})
+	}
+	warn := func(key, format string, args ...any) {
+		warns = append(warns, configIssue{Key: key, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if err := checkListenAddr(c.ListenAddr); err != nil {
+		fail("listen_addr", "%v", err)
+	}
+
+	// Frames carry a u16 length prefix.
+	if c.MaxPayloadLen < 1 || c.MaxPayloadLen > 0xFFFF {
+		fail("max_payload_len", "must be 1..65535, got %d", c.MaxPayloadLen)
+	}
+	if c.MaxRequestsPerMin < 1 {
+		fail("max_requests_per_min", "must be above zero, got %d", c.MaxRequestsPerMin)
+	}
+	if c.MaxClockSkewSec < 1 {
+		fail("max_clock_skew_sec", "must be above zero, got %d", c.MaxClockSkewSec)
+	}
+	if c.MaxMsgAgeSec < 1 {
+		fail("max_msg_age_sec", "must be above zero, got %d", c.MaxMsgAgeSec)
+	}
+	// A nonce must stay in the replay cache for as long as its timestamp
+	// could still pass the freshness check.
+	if c.ReplayCacheTTLSec < c.MaxMsgAgeSec+c.MaxClockSkewSec {
+		fail("replay_cache_ttl_sec", "replay_cache_ttl_sec=%d must be at least max_msg_age_sec + max_clock_skew_sec = %d",
+			c.ReplayCacheTTLSec, c.MaxMsgAgeSec+c.MaxClockSkewSec)
+	}
+	if c.PairHelloMaxPerMin < 1 {
+		fail("pair_hello_max_per_min", "must be above zero, got %d", c.PairHelloMaxPerMin)
+	}
+	if c.KyberKeyOverlapDays < 0 {
+		fail("kyber_key_overlap_days", "must not be negative, got %d", c.KyberKeyOverlapDays)
+	}
+	if c.DeviceKeyMaxAgeDays < 0 {
+		fail("device_key_max_age_days", "must not be negative, got %d", c.DeviceKeyMaxAgeDays)
+	}
+	if c.DeviceKeyWarnDays < 0 {
+		fail("device_key_warn_days", "must not be negative, got %d", c.DeviceKeyWarnDays)
+	} else if c.DeviceKeyMaxAgeDays > 0 && c.DeviceKeyWarnDays >= c.DeviceKeyMaxAgeDays {
+		warn("device_key_warn_days", "device_key_warn_days=%d is not below device_key_max_age_days=%d; every key will be in its warning window",
+			c.DeviceKeyWarnDays, c.DeviceKeyMaxAgeDays)
+	}
+	if c.LogRotateMB < 1 {
+		fail("log_rotate_mb", "must be above zero, got %d", c.LogRotateMB)
+	}
+	if c.LogKeep < 1 {
+		fail("log_keep", "must be above zero, got %d", c.LogKeep)
+	}
+	if c.ArmDurationMs <= 0 {
+		fail("arm_duration_ms", "must be above zero, got %d", c.ArmDurationMs)
+	}
+	if c.ApproveWindowMs <= 0 {
+		fail("approve_window_ms", "must be above zero, got %d", c.ApproveWindowMs)
+	}
+	if c.MaxInjectLen <= 0 {
+		fail("max_inject_len", "must be above zero, got %d", c.MaxInjectLen)
+	} else if c.MaxInjectLen > c.MaxPayloadLen {
+		fail("max_inject_len", "max_inject_len=%d must be no larger than max_payload_len=%d", c.MaxInjectLen, c.MaxPayloadLen)
+	}
+	if _, ok := uinputKeymaps[c.UinputKeymap]; !ok {
+		fail("uinput_keymap", "must be one of %v, got %q", uinputKeymapNames(), c.UinputKeymap)
+	}
+	if c.UinputKeyDelayMs < 1 || c.UinputKeyDelayMs > 1000 {
+		fail("uinput_key_delay_ms", "must be 1..1000, got %d", c.UinputKeyDelayMs)
+	}
+	if missing, err := checkInjectBackends(c.InjectBackends); err != nil {
+		fail("inject_backends", "%v", err)
+	} else if len(missing) > 0 {
+		warn("inject_backends", "%v not available on this OS; skipped", missing)
+	}
+
+	if !validTwoManMode(c.TwoManMode) {
+		fail("two_man_mode", "must be %q or %q, got %q", TwoManModeSelf, TwoManModeDual, c.TwoManMode)
+	}
+
+	if err := checkQuorum(c.ApprovalQuorum, c.ApproverGroup); err != nil {
+		fail("approval_quorum", "%v", err)
+	}
+
+	if c.DefaultProfile != "" {
+		if _, ok := c.Profiles[c.DefaultProfile]; !ok {
+			fail("default_profile", "default_profile %q is not defined in profiles", c.DefaultProfile)
+		}
+	}
+	for name, p := range c.Profiles {
+		if name == "" {
+			fail("profiles", "profile with empty name")
+		}
+		if p.TwoManMode != nil && !validTwoManMode(*p.TwoManMode) {
+			fail("profiles", "profiles.%s.two_man_mode must be %q or %q, got %q", name, TwoManModeSelf, TwoManModeDual, *p.TwoManMode)
+		}
+		if p.ApprovalQuorum != nil || p.ApproverGroup != nil {
+			q, group := c.ApprovalQuorum, c.ApproverGroup
+			if p.ApprovalQuorum != nil {
+				q = *p.ApprovalQuorum
+			}
+			if p.ApproverGroup != nil {
+				group = p.ApproverGroup
+			}
+			if err := checkQuorum(q, group); err != nil {
+				fail("profiles", "profiles.%s: %v", name, err)
+			}
+		}
+		if p.MaxInjectLen != nil && (*p.MaxInjectLen <= 0 || *p.MaxInjectLen > c.MaxPayloadLen) {
+			fail("profiles", "profiles.%s.max_inject_len must be 1..max_payload_len=%d, got %d", name, c.MaxPayloadLen, *p.MaxInjectLen)
+		}
+	}
+
+	twoMan := boolDeref(c.TwoManEnabled, true)
+	if boolDeref(c.AllowClipboardWhenDisarmed, false) && !twoMan {
+		warn("allow_clipboard_when_disarmed", "clipboard fallback enabled while two-man is off; a single device can place secrets on the clipboard without approval")
+	} else if boolDeref(c.AllowClipboardWhenDisarmed, false) {
+		warn("allow_clipboard_when_disarmed", "clipboard fallback bypasses the arm, two-man and target policy gates")
+	}
+	if !c.TargetPolicyEnabled && !isLoopbackListen(c.ListenAddr) {
+		warn("target_policy_enabled", "listening on %s without target policy; any focused window can receive secrets", c.ListenAddr)
+	}
+	if !c.RequireSealedDeviceStore {
+		warn("require_sealed_device_store", "device store may fall back to plaintext when the OS keyring is unavailable")
+	}
+	return errs, warns
+}
+
+func checkQuorum(q int, group []string) error {
+	if q < 0 {
+		return fmt.Errorf("approval_quorum must not be negative, got %d", q)
+	}
+	if q > 0 && len(group) > 0 && q > len(group) {
+		return fmt.Errorf("approval_quorum=%d exceeds approver_group size %d", q, len(group))
+	}
+	return nil
+}
+
+func validTwoManMode(m string) bool {
+	return m == TwoManModeSelf || m == TwoManModeDual
+}
+
+func checkListenAddr(addr string) error {
+	addr = strings.TrimSpace(addr)
+	if _, err := strconv.Atoi(addr); err == nil {
+		addr = "127.0.0.1:" + addr
+	}
+	_, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listen_addr %q: %v", addr, err)
+	}
+	n, err := strconv.Atoi(p)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid listen_addr port %q", p)
+	}
+	return nil
+}
+
+func isLoopbackListen(addr string) bool {
+	h, _, err := net.SplitHostPort(strings.TrimSpace(addr))
+	if err != nil {
+		// bare port: splitHostPortOrDie binds these to 127.0.0.1
+		return true
+	}
+	if strings.EqualFold(h, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(h)
+	return ip != nil && ip.IsLoopback()
+}
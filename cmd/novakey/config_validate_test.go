@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempConfig(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckConfigFile_RejectsUnknownYAMLKeyWithLine(t *testing.T) {
+	path := writeTempConfig(t, "server_config.yaml", "listen_addr: \"127.0.0.1:60768\"\narm_duraton_ms: 5000\n")
+
+	_, rep, err := checkConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Errors) != 1 || rep.Errors[0].Line != 2 || rep.Errors[0].Key != "arm_duraton_ms" {
+		t.Fatalf("unexpected errors: %+v", rep.Errors)
+	}
+}
+
+func TestCheckConfigFile_RejectsUnknownJSONKeyWithLine(t *testing.T) {
+	path := writeTempConfig(t, "server_config.json", "{\n  \"listen_addr\": \"127.0.0.1:60768\",\n  \"bogus\": 1\n}\n")
+
+	_, rep, err := checkConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Errors) != 1 || rep.Errors[0].Line != 3 {
+		t.Fatalf("unexpected errors: %+v", rep.Errors)
+	}
+}
+
+func TestValidateConfig_RangeChecksAndWarnings(t *testing.T) {
+	off := false
+	on := true
+	c := ServerConfig{
+		ListenAddr:                 "127.0.0.1:60768",
+		MaxInjectLen:               8192,
+		ApproveWindowMs:            -1,
+		TwoManEnabled:              &off,
+		AllowClipboardWhenDisarmed: &on,
+		RequireSealedDeviceStore:   true,
+	}
+	applyDefaults(&c)
+
+	errs, warns := validateConfig(&c)
+
+	gotErr := map[string]bool{}
+	for _, e := range errs {
+		gotErr[e.Key] = true
+	}
+	if !gotErr["max_inject_len"] || !gotErr["approve_window_ms"] {
+		t.Fatalf("missing expected errors: %+v", errs)
+	}
+	if len(warns) != 1 || warns[0].Key != "allow_clipboard_when_disarmed" {
+		t.Fatalf("unexpected warnings: %+v", warns)
+	}
+}
+
+func TestValidateConfig_ReplayTTLMustCoverFreshnessWindow(t *testing.T) {
+	c := ServerConfig{ListenAddr: "127.0.0.1:60768", MaxMsgAgeSec: 600, MaxClockSkewSec: 60}
+	applyDefaults(&c)
+
+	errs, _ := validateConfig(&c)
+	if len(errs) != 1 || errs[0].Key != "replay_cache_ttl_sec" {
+		t.Fatalf("unexpected errors: %+v", errs)
+	}
+
+	c.ReplayCacheTTLSec = 660
+	if errs, _ := validateConfig(&c); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %+v", errs)
+	}
+}
+
+func TestCheckConfigFile_RejectsExplicitZeroBeforeDefaults(t *testing.T) {
+	path := writeTempConfig(t, "server_config.yaml", "listen_addr: \"127.0.0.1:60768\"\napprove_window_ms: 0\n")
+
+	_, rep, err := checkConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Errors) != 1 || rep.Errors[0].Key != "approve_window_ms" || rep.Errors[0].Line != 2 {
+		t.Fatalf("unexpected errors: %+v", rep.Errors)
+	}
+
+	// Leaving the key out still means the default.
+	path = writeTempConfig(t, "server_config.yaml", "listen_addr: \"127.0.0.1:60768\"\n")
+	if _, rep, err := checkConfigFile(path); err != nil || len(rep.Errors) != 0 {
+		t.Fatalf("err=%v errors=%+v", err, rep.Errors)
+	}
+}
+
+func TestCheckConfigFile_ExplicitZeroOverlapMeansNone(t *testing.T) {
+	path := writeTempConfig(t, "server_config.yaml", "listen_addr: \"127.0.0.1:60768\"\nkyber_key_overlap_days: 0\n")
+	c, rep, err := checkConfigFile(path)
+	if err != nil || len(rep.Errors) != 0 {
+		t.Fatalf("err=%v errors=%+v", err, rep.Errors)
+	}
+	if c.KyberKeyOverlapDays != 0 {
+		t.Fatalf("kyber_key_overlap_days=%d, want explicit 0 kept", c.KyberKeyOverlapDays)
+	}
+
+	path = writeTempConfig(t, "server_config.yaml", "listen_addr: \"127.0.0.1:60768\"\n")
+	if c, _, _ = checkConfigFile(path); c.KyberKeyOverlapDays != 30 {
+		t.Fatalf("kyber_key_overlap_days=%d, want default 30", c.KyberKeyOverlapDays)
+	}
+}
+
+func TestCheckConfigFile_ExplicitZeroWarnDaysMeansNoWarning(t *testing.T) {
+	path := writeTempConfig(t, "server_config.yaml", "listen_addr: \"127.0.0.1:60768\"\ndevice_key_warn_days: 0\n")
+	c, rep, err := checkConfigFile(path)
+	if err != nil || len(rep.Errors) != 0 {
+		t.Fatalf("err=%v errors=%+v", err, rep.Errors)
+	}
+	if c.DeviceKeyWarnDays != 0 {
+		t.Fatalf("device_key_warn_days=%d, want explicit 0 kept", c.DeviceKeyWarnDays)
+	}
+
+	path = writeTempConfig(t, "server_config.yaml", "listen_addr: \"127.0.0.1:60768\"\n")
+	if c, _, _ = checkConfigFile(path); c.DeviceKeyWarnDays != 14 {
+		t.Fatalf("device_key_warn_days=%d, want default 14", c.DeviceKeyWarnDays)
+	}
+}
@@ -0,0 +1,169 @@
+// cmd/novakey/control.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Local control channel: a Unix domain socket (peer-credential checked) or a
+// Windows named pipe (owner-only DACL). Lets the person at the desk arm and
+// disarm without a phone. One JSON request line, one JSON reply line.
+//
+//	{"op":"arm","ms":15000}
+//	{"op":"disarm"}
+//	{"op":"status"}
+//	{"op":"reload-devices"}
+
+const (
+	controlOff        = "off"
+	controlMaxRequest = 4096
+)
+
+type controlRequest struct {
+	Op string `json:"op"`
+	MS int    `json:"ms,omitempty"`
+}
+
+type controlReply struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	Msg   string `json:"msg,omitempty"`
+
+	Armed           bool  `json:"armed"`
+	ArmedUntilUnixM int64 `json:"armed_until_unix_ms,omitempty"`
+
+	// Status is set only for the "status" op.
+	Status *daemonStatus `json:"status,omitempty"`
+}
+
+// startControlListener starts the local control listener unless
+// control_socket is "off". Failure is logged, not fatal: the network path
+// keeps working without it.
+func startControlListener() {
+	path := currentConfig().ControlSocket
+	if path == "" || strings.EqualFold(path, controlOff) {
+		log.Printf("[control] local control channel disabled")
+		return
+	}
+	if err := listenControl(path, handleControlConn); err != nil {
+		log.Printf("[control] not available: %v", err)
+		return
+	}
+	log.Printf("[control] listening on %s", path)
+}
+
+// handleControlConn serves one request on an already-authorized connection.
+func handleControlConn(rw io.ReadWriteCloser) {
+	defer rw.Close()
+
+	br := bufio.NewReaderSize(io.LimitReader(rw, controlMaxRequest), 512)
+	line, err := br.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return
+	}
+
+	var req controlRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		writeControlReply(rw, controlReply{Error: "invalid request"})
+		return
+	}
+	writeControlReply(rw, runControlRequest(req))
+}
+
+func runControlRequest(req controlRequest) controlReply {
+	cfg := currentConfig()
+
+	switch req.Op {
+	case "arm":
+		ms := cfg.ArmDurationMs
+		if req.MS > 0 {
+			ms = req.MS
+		}
+		until := armGate.ArmFor(time.Duration(ms) * time.Millisecond)
+		log.Printf("[control] armed locally for %dms", ms)
+		r := armStatusReply()
+		r.Msg = fmt.Sprintf("armed until %s", until.Format(time.RFC3339))
+		return r
+
+	case "disarm":
+		armGate.Disarm()
+		log.Printf("[control] disarmed locally")
+		r := armStatusReply()
+		r.Msg = "disarmed"
+		return r
+
+	case "status":
+		r := armStatusReply()
+		r.Status = collectStatus()
+		return r
+
+	case "reload-devices":
+		if err := reloadDevicesFromDisk(); err != nil {
+			log.Printf("[control] reload devices failed: %v", err)
+			return controlReply{Error: fmt.Sprintf("reload devices: %v", err)}
+		}
+		r := armStatusReply()
+		r.Msg = "devices reloaded"
+		return r
+
+	default:
+		return controlReply{Error: fmt.Sprintf("unknown op %q", req.Op)}
+	}
+}
+
+func armStatusReply() controlReply {
+	r := controlReply{OK: true}
+	if until := armGate.ArmedUntil(); !until.IsZero() && time.Now().Before(until) {
+		r.Armed = true
+		r.ArmedUntilUnixM = until.UnixMilli()
+	}
+	return r
+}
+
+func writeControlReply(w io.Writer, r controlReply) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		b = []byte(`{"ok":false,"error":"marshal failed"}`)
+	}
+	_, _ = w.Write(append(b, '\n'))
+}
+
+// controlCall sends one request to the running daemon and returns its reply.
+func controlCall(path string, req controlRequest) (controlReply, error) {
+	var r controlReply
+	if path == "" || strings.EqualFold(path, controlOff) {
+		return r, fmt.Errorf("control_socket is disabled in the config")
+	}
+
+	conn, err := dialControl(path)
+	if err != nil {
+		return r, fmt.Errorf("connect %s (is the daemon running?): %w", path, err)
+	}
+	defer conn.Close()
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return r, err
+	}
+	if _, err := conn.Write(append(b, '\n')); err != nil {
+		return r, fmt.Errorf("send: %w", err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return r, fmt.Errorf("read reply: %w", err)
+	}
+	if err := json.Unmarshal(line, &r); err != nil {
+		return r, fmt.Errorf("parse reply: %w", err)
+	}
+	if !r.OK {
+		return r, fmt.Errorf("%s", r.Error)
+	}
+	return r, nil
+}
@@ -0,0 +1,141 @@
+// cmd/novakey/control_cli.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	// One-shot commands: `novakey arm [-ms N]`, `novakey disarm`, `novakey status [-json]`
+	if len(os.Args) >= 2 {
+		switch os.Args[1] {
+		case "arm", "disarm", "status":
+			os.Exit(runControlCommand(os.Args[1], os.Args[2:]))
+		}
+	}
+}
+
+// runControlCommand sends one request over the local control channel of the
+// daemon that uses the same config, and prints the result.
+func runControlCommand(op string, args []string) int {
+	fs := flag.NewFlagSet(op, flag.ContinueOnError)
+	fs.StringVar(&configFlagPath, "config", "", "path to server config file")
+	ms := 0
+	if op == "arm" {
+		fs.IntVar(&ms, "ms", 0, "arm duration in ms (default arm_duration_ms)")
+	}
+	asJSON := false
+	if op == "status" {
+		fs.BoolVar(&asJSON, "json", false, "print the status as JSON")
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "novakey %s: %v\n", op, err)
+		return 1
+	}
+
+	r, err := controlCall(currentConfig().ControlSocket, controlRequest{Op: op, MS: ms})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "novakey %s: %v\n", op, err)
+		return 1
+	}
+
+	switch {
+	case op != "status" || r.Status == nil:
+		printArmStatus(r)
+	case asJSON:
+		if err := printJSON(r.Status); err != nil {
+			fmt.Fprintf(os.Stderr, "novakey %s: %v\n", op, err)
+			return 1
+		}
+	default:
+		printStatus(r.Status)
+	}
+	return 0
+}
+
+func printArmStatus(r controlReply) {
+	if !r.Armed {
+		fmt.Println("disarmed")
+		return
+	}
+	fmt.Printf("armed until %s\n", untilText(r.ArmedUntilUnixM))
+}
+
+func printStatus(s *daemonStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	if s.Armed {
+		fmt.Fprintf(w, "armed:\tuntil %s\n", untilText(s.ArmedUntilUnixMs))
+	} else {
+		fmt.Fprintf(w, "armed:\tno\n")
+	}
+	fmt.Fprintf(w, "two-man:\t%s\n", onOff(s.TwoManEnabled))
+	if s.PairingActive {
+		fmt.Fprintf(w, "pairing:\tactive until %s\n", untilText(s.PairingExpiresUnixMs))
+	} else {
+		fmt.Fprintf(w, "pairing:\tinactive\n")
+	}
+	fmt.Fprintf(w, "server key:\t%s (kid %s)\n", s.ServerKeyFingerprint, s.ServerKID)
+	for _, k := range s.RetiredServerKeys {
+		fmt.Fprintf(w, "retired key:\tkid %s, accepted until %s\n", k.KID, unixText(k.NotAfterUnixMs/1000))
+	}
+	fmt.Fprintf(w, "session:\t%s\n", s.Session)
+	fmt.Fprintf(w, "listen:\t%s\n", s.ListenAddr)
+	fmt.Fprintf(w, "devices:\t%d\n", s.DeviceCount)
+	if len(s.MsgRejections) > 0 {
+		fmt.Fprintf(w, "rejected /msg:\t%s\n", rejectionsText(s.MsgRejections))
+	}
+	_ = w.Flush()
+
+	if len(s.Devices) == 0 {
+		return
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE\tLABEL\tROLE\tPROFILE\tAPPROVED\tRATE")
+	for _, d := range s.Devices {
+		approved := "-"
+		if d.ApprovedUntilUnixMs > 0 {
+			approved = untilText(d.ApprovedUntilUnixMs)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d/%d per min\n",
+			d.ID, orDash(d.Label), d.Role, orDash(d.Profile), approved, d.RateCount, s.RateLimitPerMin)
+	}
+	_ = w.Flush()
+}
+
+func untilText(unixMs int64) string {
+	t := time.UnixMilli(unixMs)
+	return fmt.Sprintf("%s (%s left)", t.Format("15:04:05"), time.Until(t).Round(time.Second))
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// rejectionsText renders msg_rejections as "class=n" pairs, sorted by class.
+func rejectionsText(m map[string]uint64) string {
+	classes := make([]string, 0, len(m))
+	for c := range m {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+	parts := make([]string, len(classes))
+	for i, c := range classes {
+		parts[i] = fmt.Sprintf("%s=%d", c, m[c])
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,14 @@
+// cmd/novakey/control_peercred_other.go
+//go:build !windows && !darwin && !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// No peer-credential support wired up here: refuse every peer.
+func controlPeerUID(c *net.UnixConn) (uint32, error) {
+	return 0, errors.New("peer credentials not supported on this platform")
+}
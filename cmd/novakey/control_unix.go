@@ -0,0 +1,92 @@
+// cmd/novakey/control_unix.go
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// defaultControlSocket is the control_socket default: the user's runtime
+// directory, not the config directory, which may be a root-owned
+// /etc/novakey. Without XDG_RUNTIME_DIR it is a per-user directory under the
+// temp dir ($TMPDIR is already per-user on macOS).
+func defaultControlSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "novakey.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("novakey-%d", os.Getuid()), "novakey.sock")
+}
+
+// listenControl serves the control socket. Only processes running as the
+// daemon's own user (or root) are served; the socket file is also 0600.
+func listenControl(path string, handle func(io.ReadWriteCloser)) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	// The default may be in a shared temp dir: don't use a directory another
+	// user created there first.
+	if fi, err := os.Stat(dir); err != nil {
+		return err
+	} else if st, ok := fi.Sys().(*syscall.Stat_t); ok && st.Uid != uint32(os.Getuid()) && st.Uid != 0 {
+		return fmt.Errorf("%s is owned by uid %d", dir, st.Uid)
+	}
+
+	// Replace a stale socket from a previous run, but never a live one or a
+	// regular file.
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = c.Close()
+			return fmt.Errorf("%s is in use by another process", path)
+		}
+		_ = os.Remove(path)
+	}
+
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = ln.Close()
+		return err
+	}
+	ln.SetUnlinkOnClose(true)
+
+	self := uint32(os.Getuid())
+	go func() {
+		for {
+			c, err := ln.AcceptUnix()
+			if err != nil {
+				log.Printf("[control] accept: %v", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+
+			uid, err := controlPeerUID(c)
+			if err != nil || (uid != self && uid != 0) {
+				log.Printf("[control] reject peer (uid=%d err=%v)", uid, err)
+				_ = c.Close()
+				continue
+			}
+
+			_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+			go handle(c)
+		}
+	}()
+	return nil
+}
+
+func dialControl(path string) (io.ReadWriteCloser, error) {
+	return net.DialTimeout("unix", path, 2*time.Second)
+}
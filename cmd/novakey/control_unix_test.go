@@ -0,0 +1,58 @@
+//go:build !windows
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestControlSocket_ArmStatusDisarm(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "novakey.sock")
+	if err := listenControl(path, handleControlConn); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(armGate.Disarm)
+
+	r, err := controlCall(path, controlRequest{Op: "arm", MS: 60000})
+	if err != nil || !r.Armed {
+		t.Fatalf("arm: %+v, %v", r, err)
+	}
+	if r, err := controlCall(path, controlRequest{Op: "status"}); err != nil || !r.Armed || r.ArmedUntilUnixM == 0 {
+		t.Fatalf("status: %+v, %v", r, err)
+	}
+	if r, err := controlCall(path, controlRequest{Op: "disarm"}); err != nil || r.Armed {
+		t.Fatalf("disarm: %+v, %v", r, err)
+	}
+	if _, err := controlCall(path, controlRequest{Op: "bogus"}); err == nil {
+		t.Fatal("expected error for unknown op")
+	}
+
+	// A second daemon must not steal a live socket.
+	if err := listenControl(path, handleControlConn); err == nil {
+		t.Fatal("listenControl replaced a live socket")
+	}
+}
+
+func TestReadConfigFile_ControlSocketDefaultsToRuntimeDir(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	path := writeTempConfig(t, "server_config.yaml", "listen_addr: \"127.0.0.1:60768\"\n")
+	c, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(runtimeDir, "novakey.sock"); c.ControlSocket != want {
+		t.Fatalf("control_socket=%q want %q", c.ControlSocket, want)
+	}
+
+	// Only an explicit relative value is anchored to the config dir.
+	path = writeTempConfig(t, "server_config.yaml", "control_socket: \"run/novakey.sock\"\n")
+	if c, err = readConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(filepath.Dir(path), "run", "novakey.sock"); c.ControlSocket != want {
+		t.Fatalf("control_socket=%q want %q", c.ControlSocket, want)
+	}
+}
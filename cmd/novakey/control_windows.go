@@ -0,0 +1,95 @@
+// cmd/novakey/control_windows.go
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// controlPipeSDDL grants access to the pipe's owner (the daemon's user) only.
+const controlPipeSDDL = "D:P(A;;GA;;;OW)"
+
+// defaultControlSocket is the control_socket default.
+func defaultControlSocket() string {
+	return `\\.\pipe\novakey`
+}
+
+// listenControl serves the control named pipe. Remote clients are rejected
+// and the DACL only admits the daemon's own user.
+func listenControl(path string, handle func(io.ReadWriteCloser)) error {
+	sd, err := windows.SecurityDescriptorFromString(controlPipeSDDL)
+	if err != nil {
+		return err
+	}
+	sa := &windows.SecurityAttributes{
+		Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+		SecurityDescriptor: sd,
+	}
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+
+	// FILE_FLAG_FIRST_PIPE_INSTANCE fails if someone else already owns the name.
+	h, err := createControlPipe(name, sa, true)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			err := windows.ConnectNamedPipe(h, nil)
+			if err != nil && !errors.Is(err, windows.ERROR_PIPE_CONNECTED) {
+				log.Printf("[control] ConnectNamedPipe: %v", err)
+				_ = windows.DisconnectNamedPipe(h)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+
+			conn := &controlPipeConn{File: os.NewFile(uintptr(h), path), h: h}
+			next, err := createControlPipe(name, sa, false)
+			if err != nil {
+				log.Printf("[control] CreateNamedPipe: %v", err)
+				_ = conn.Close()
+				return
+			}
+			go handle(conn)
+			h = next
+		}
+	}()
+	return nil
+}
+
+func createControlPipe(name *uint16, sa *windows.SecurityAttributes, first bool) (windows.Handle, error) {
+	flags := uint32(windows.PIPE_ACCESS_DUPLEX)
+	if first {
+		flags |= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+	mode := uint32(windows.PIPE_TYPE_BYTE | windows.PIPE_READMODE_BYTE | windows.PIPE_WAIT | windows.PIPE_REJECT_REMOTE_CLIENTS)
+	return windows.CreateNamedPipe(name, flags, mode, windows.PIPE_UNLIMITED_INSTANCES, 4096, 4096, 0, sa)
+}
+
+// controlPipeConn flushes and disconnects before closing so the client can
+// read the reply.
+type controlPipeConn struct {
+	*os.File
+	h windows.Handle
+}
+
+func (c *controlPipeConn) Close() error {
+	_ = windows.FlushFileBuffers(c.h)
+	_ = windows.DisconnectNamedPipe(c.h)
+	return c.File.Close()
+}
+
+func dialControl(path string) (io.ReadWriteCloser, error) {
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
@@ -19,30 +20,94 @@ import (
 )
 
 const (
-	protocolVersion = 3
-	msgTypePassword = 1
+	// /msg outer frame versions (see parseOuterFrame). v4 adds the cipher
+	// suite, flags and server key ID.
+	protocolVersionV3 = 3
+	protocolVersionV4 = 4
+	msgTypePassword   = 1
+
+	suiteMLKEM768XChaCha = 1    // ML-KEM-768 + HKDF-SHA256 + XChaCha20-Poly1305
+	outerFlagSealedReply = 0x01 // v4: seal the reply for the sender (reply_seal.go)
+	knownOuterFlags      = outerFlagSealedReply
+	serverKIDLen         = 4
 
 	defaultDevicesFile = "devices.json"
 
-	maxClockSkewSec = 120
-	maxMsgAgeSec    = 300
-	replayCacheTTL  = 600
+	// devicesStoreVersion is the device store schema written by this build.
+	// Version 1 (no "version" key) had no metadata; it loads unchanged and is
+	// rewritten as version 2 on the next store write.
+	devicesStoreVersion = 2
+
+	// Defaults for max_clock_skew_sec, max_msg_age_sec, replay_cache_ttl_sec.
+	defaultMaxClockSkewSec = 120
+	defaultMaxMsgAgeSec    = 300
+	defaultReplayCacheTTL  = 600
 
 	maxRequestsPerDevicePerMin = 60
 )
 
 type deviceConfig struct {
-	ID     string `json:"id"`
-	KeyHex string `json:"key_hex"` // 32 bytes hex
+	ID      string `json:"id"`
+	KeyHex  string `json:"key_hex"`           // 32 bytes hex
+	Profile string `json:"profile,omitempty"` // policy profile name (see profiles in server config)
+
+	// Two-man dual control (see device_roles.go)
+	Role      string   `json:"role,omitempty"`      // injector | approver | both (default)
+	Approvers []string `json:"approvers,omitempty"` // device IDs allowed to approve this device's injects
+
+	// Key lifetime (see device_key_expiry.go)
+	KeyCreatedUnix int64 `json:"key_created_unix,omitempty"`
+	ExpiresAtUnix  int64 `json:"expires_at_unix,omitempty"` // optional hard expiry for this key
+
+	// Previous key, still accepted until PrevKeyUntilUnix after an in-band
+	// rekey (see rekey.go)
+	PrevKeyHex       string `json:"prev_key_hex,omitempty"`
+	PrevKeyUntilUnix int64  `json:"prev_key_until_unix,omitempty"`
+
+	deviceMeta // informational only; never used for authorization (see device_meta.go)
 }
 
 type devicesConfigFile struct {
+	Version int            `json:"version,omitempty"`
 	Devices []deviceConfig `json:"devices"`
 }
 
 type deviceState struct {
 	id        string
 	staticKey []byte
+	profile   string
+	role      string
+	approvers []string
+	meta      deviceMeta
+
+	keyCreated int64 // unix seconds; 0 = unknown
+	expiresAt  int64 // unix seconds; 0 = no explicit expiry
+
+	prevKey      []byte // accepted until prevKeyUntil (rekey grace)
+	prevKeyUntil int64
+}
+
+// record converts st back to its persisted form.
+func (st deviceState) record() deviceConfig {
+	role := st.role
+	if role == RoleBoth {
+		role = "" // the default; keep older stores unchanged
+	}
+	rec := deviceConfig{
+		ID:             st.id,
+		KeyHex:         hex.EncodeToString(st.staticKey),
+		Profile:        st.profile,
+		Role:           role,
+		Approvers:      st.approvers,
+		KeyCreatedUnix: st.keyCreated,
+		ExpiresAtUnix:  st.expiresAt,
+		deviceMeta:     st.meta,
+	}
+	if len(st.prevKey) > 0 && time.Now().Unix() < st.prevKeyUntil {
+		rec.PrevKeyHex = hex.EncodeToString(st.prevKey)
+		rec.PrevKeyUntilUnix = st.prevKeyUntil
+	}
+	return rec
 }
 
 // Protect devices map (pairing reload swaps it).
@@ -63,12 +128,16 @@ type rateWindow struct {
 }
 
 func initCrypto() error {
+	cfg := currentConfig()
 	if err := loadOrCreateServerKeys(cfg.ServerKeysFile); err != nil {
 		return fmt.Errorf("loading server Kyber keys: %w", err)
 	}
 	if serverDecapKey == nil || len(serverEncapKey) == 0 {
 		return fmt.Errorf("server keys not initialized")
 	}
+	if err := openReplayJournal(cfg.ReplayJournalFile); err != nil {
+		return fmt.Errorf("loading replay journal: %w", err)
+	}
 
 	path := cfg.DevicesFile
 	if path == "" {
@@ -90,6 +159,7 @@ func initCrypto() error {
 		log.Printf("[fatal] device store error: %v", err)
 		return err
 	}
+	m = stampKeyCreation(path, m)
 
 	devicesMu.Lock()
 	devices = m
@@ -107,20 +177,36 @@ func isPaired() bool {
 }
 
 func reloadDevicesFromDisk() error {
-	path := cfg.DevicesFile
+	path := currentConfig().DevicesFile
 	if path == "" {
 		path = defaultDevicesFile
 	}
 
+	// An empty store is valid here: `novakey devices revoke` may have removed
+	// the last device, and it must stop being accepted.
 	m, err := loadDevicesFromDisk(path)
+	if errors.Is(err, ErrNotPaired) {
+		m, err = map[string]deviceState{}, nil
+	}
 	if err != nil {
 		return err
 	}
+	// Devices added by nvpair or a hand edit may have no key_created_unix yet.
+	m = stampKeyCreation(path, m)
 
 	devicesMu.Lock()
+	prev := devices
 	devices = m
 	devicesMu.Unlock()
 
+	// Approvals given by a device that is gone or has a new key don't survive.
+	for id, old := range prev {
+		if cur, ok := m[id]; !ok || !constTimeEq(cur.staticKey, old.staticKey) {
+			approvalGate.Forget(id)
+			quorum.Forget(id)
+		}
+	}
+
 	absPath, _ := filepath.Abs(path)
 	log.Printf("[pair] reloaded %d device keys from %s", len(m), absPath)
 	return nil
@@ -131,6 +217,11 @@ func buildDevicesMap(dc devicesConfigFile, path string) (map[string]deviceState,
 		return nil, fmt.Errorf("%w: %s has no devices", ErrNotPaired, path)
 	}
 
+	if dc.Version > devicesStoreVersion {
+		return nil, fmt.Errorf("%w: %s uses device store version %d; this build supports up to %d",
+			ErrDevicesUnavailable, path, dc.Version, devicesStoreVersion)
+	}
+
 	m := make(map[string]deviceState, len(dc.Devices))
 	for _, d := range dc.Devices {
 		if d.ID == "" {
@@ -144,7 +235,31 @@ func buildDevicesMap(dc devicesConfigFile, path string) (map[string]deviceState,
 			return nil, fmt.Errorf("device %q: key must be %d bytes, got %d",
 				d.ID, chacha20poly1305.KeySize, len(keyBytes))
 		}
-		m[d.ID] = deviceState{id: d.ID, staticKey: keyBytes}
+		role, err := normalizeDeviceRole(d.Role)
+		if err != nil {
+			return nil, fmt.Errorf("device %q: %w", d.ID, err)
+		}
+		var prevKey []byte
+		if d.PrevKeyHex != "" {
+			prevKey, err = hex.DecodeString(d.PrevKeyHex)
+			if err != nil || len(prevKey) != chacha20poly1305.KeySize {
+				return nil, fmt.Errorf("device %q: invalid prev_key_hex", d.ID)
+			}
+		}
+		m[d.ID] = deviceState{
+			id:        d.ID,
+			staticKey: keyBytes,
+			profile:   d.Profile,
+			role:      role,
+			approvers: d.Approvers,
+			meta:      d.deviceMeta,
+
+			keyCreated: d.KeyCreatedUnix,
+			expiresAt:  d.ExpiresAtUnix,
+
+			prevKey:      prevKey,
+			prevKeyUntil: d.PrevKeyUntilUnix,
+		}
 	}
 	return m, nil
 }
@@ -158,136 +273,275 @@ func deriveAEADKey(deviceKey, sharedKem []byte) ([]byte, error) {
 	return key, nil
 }
 
-func decryptMessageFrame(frame []byte) (deviceID string, msgType uint8, payload []byte, err error) {
-	devID, plaintext, nonce, err := decryptOuterV3(frame)
+// msgContext carries the per-message values from the outer frame that
+// handlers need after decryption.
+type msgContext struct {
+	sharedKem []byte // ML-KEM shared secret of this message
+	deviceKey []byte // device key the message authenticated under
+	nonce     []byte // outer AEAD nonce (unique per message)
+	prevKey   bool   // authenticated under the previous key (rekey grace)
+
+	serverKey serverKEMKey // server key the KEM ciphertext was for
+	sealReply bool         // v4 outerFlagSealedReply: reply must be sealed
+}
+
+// staleServerKey reports whether the message was encrypted to a retired
+// server key, i.e. the phone should pick up the current one.
+func (mc msgContext) staleServerKey() bool { return !mc.serverKey.notAfter.IsZero() }
+
+func decryptMessageFrame(cfg *ServerConfig, frame []byte) (deviceID string, msgType uint8, payload []byte, mc msgContext, err error) {
+	devID, plaintext, mc, err := decryptOuter(frame)
 	if err != nil {
-		return "", 0, nil, err
+		return "", 0, nil, mc, err
 	}
+	nonce := mc.nonce
 
 	// plaintext must be: [8-byte timestamp][inner frame v1...]
 	if len(plaintext) < 8 {
-		return "", 0, nil, fmt.Errorf("plaintext too short for timestamp: %d", len(plaintext))
+		return "", 0, nil, mc, fmt.Errorf("%w: plaintext too short for timestamp: %d", ErrBadFrame, len(plaintext))
 	}
 
 	ts := int64(binary.BigEndian.Uint64(plaintext[:8]))
-	if err := validateFreshnessAndRate(devID, nonce, ts); err != nil {
-		return "", 0, nil, err
+	if err := validateFreshnessAndRate(cfg, devID, nonce, ts); err != nil {
+		return "", 0, nil, mc, err
 	}
 
 	body := plaintext[8:]
 	if len(body) < 1 {
-		return "", 0, nil, fmt.Errorf("missing inner message frame (empty body)")
+		return "", 0, nil, mc, fmt.Errorf("%w: missing inner message frame (empty body)", ErrBadFrame)
 	}
 
 	// HARD REQUIRE: inner message frame v1
 	if body[0] != byte(frameVersionV1) {
-		return "", 0, nil, fmt.Errorf("missing required inner frame v%d (got first_byte=%d)", frameVersionV1, body[0])
+		return "", 0, nil, mc, fmt.Errorf("%w: missing required inner frame v%d (got first_byte=%d)", ErrBadFrame, frameVersionV1, body[0])
 	}
 
 	innerDev, innerType, innerPayload, derr := decodeMessageFrame(body)
 	if derr != nil {
-		return "", 0, nil, fmt.Errorf("invalid inner message frame: %w", derr)
+		return "", 0, nil, mc, fmt.Errorf("%w: invalid inner message frame: %v", ErrBadFrame, derr)
 	}
 	if innerDev != devID {
-		return "", 0, nil, fmt.Errorf("inner deviceID mismatch (outer=%q inner=%q)", devID, innerDev)
+		return "", 0, nil, mc, fmt.Errorf("%w: inner deviceID mismatch (outer=%q inner=%q)", ErrBadFrame, devID, innerDev)
 	}
 
-	return devID, innerType, innerPayload, nil
+	return devID, innerType, innerPayload, mc, nil
 }
 
-func decryptOuterV3(frame []byte) (string, []byte, []byte, error) {
+// outerFrame is the cleartext part of a /msg frame (v3 or v4), split out.
+type outerFrame struct {
+	version    uint8
+	suite      uint8
+	flags      uint8
+	kid        string // v4 only: server key the client encapsulated to
+	deviceID   string
+	kemCt      []byte
+	aad        []byte // frame[0 : end of kemCt]
+	nonce      []byte
+	ciphertext []byte
+}
+
+// parseOuterFrame splits a v3 or v4 /msg frame. v4 adds, after msgType:
+// suite (u8), flags (u8) and the 4-byte server key ID.
+func parseOuterFrame(frame []byte) (outerFrame, error) {
+	var of outerFrame
 	if len(frame) < 3 {
-		return "", nil, nil, fmt.Errorf("frame too short: %d", len(frame))
+		return of, fmt.Errorf("frame too short: %d", len(frame))
 	}
-	if frame[0] != protocolVersion {
-		return "", nil, nil, fmt.Errorf("unsupported protocol version: %d", frame[0])
+	of.version = frame[0]
+	switch of.version {
+	case protocolVersionV3, protocolVersionV4:
+	default:
+		return of, fmt.Errorf("unsupported protocol version: %d", frame[0])
 	}
 	if frame[1] != msgTypePassword {
-		return "", nil, nil, fmt.Errorf("unexpected msgType: %d", frame[1])
+		return of, fmt.Errorf("unexpected msgType: %d", frame[1])
+	}
+
+	off := 2
+	of.suite = suiteMLKEM768XChaCha
+	if of.version == protocolVersionV4 {
+		if len(frame) < off+2+serverKIDLen+1 {
+			return of, fmt.Errorf("frame too short for v4 header")
+		}
+		of.suite, of.flags = frame[off], frame[off+1]
+		of.kid = hex.EncodeToString(frame[off+2 : off+2+serverKIDLen])
+		off += 2 + serverKIDLen
+
+		if of.suite != suiteMLKEM768XChaCha {
+			return of, fmt.Errorf("unsupported cipher suite: %d", of.suite)
+		}
+		if of.flags&^knownOuterFlags != 0 {
+			return of, fmt.Errorf("unsupported flags: %#02x", of.flags)
+		}
 	}
 
-	idLen := int(frame[2])
+	idLen := int(frame[off])
 	if idLen <= 0 {
-		return "", nil, nil, fmt.Errorf("invalid idLen: %d", idLen)
+		return of, fmt.Errorf("invalid idLen: %d", idLen)
 	}
-	if len(frame) < 3+idLen {
-		return "", nil, nil, fmt.Errorf("frame too short for idLen=%d", idLen)
+	off++
+	if len(frame) < off+idLen {
+		return of, fmt.Errorf("frame too short for idLen=%d", idLen)
 	}
-	deviceID := string(frame[3 : 3+idLen])
+	of.deviceID = string(frame[off : off+idLen])
+	off += idLen
+
+	if len(frame) < off+2 {
+		return of, fmt.Errorf("frame too short for kemCtLen")
+	}
+	kemCtLen := int(binary.BigEndian.Uint16(frame[off : off+2]))
+	if kemCtLen != mlkem768.CiphertextSize {
+		return of, fmt.Errorf("invalid kemCtLen: got %d expected %d", kemCtLen, mlkem768.CiphertextSize)
+	}
+	off += 2
+	if len(frame) < off+kemCtLen {
+		return of, fmt.Errorf("frame too short for kemCt")
+	}
+	of.kemCt = frame[off : off+kemCtLen]
+	off += kemCtLen
+	of.aad = frame[:off]
+
+	rest := frame[off:]
+	if len(rest) < chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead {
+		return of, fmt.Errorf("frame too short for nonce+ciphertext")
+	}
+	of.nonce = rest[:chacha20poly1305.NonceSizeX]
+	of.ciphertext = rest[chacha20poly1305.NonceSizeX:]
+	return of, nil
+}
+
+// unknownDeviceKey stands in for the device key of an unknown deviceID.
+var unknownDeviceKey = func() []byte {
+	k := make([]byte, chacha20poly1305.KeySize)
+	_, _ = rand.Read(k)
+	return k
+}()
+
+func decryptOuter(frame []byte) (string, []byte, msgContext, error) {
+	var mc msgContext
+	of, err := parseOuterFrame(frame)
+	if err != nil {
+		return "", nil, mc, fmt.Errorf("%w: %v", ErrBadFrame, err)
+	}
+	deviceID := of.deviceID
 
 	devicesMu.RLock()
-	dev, ok := devices[deviceID]
+	dev, known := devices[deviceID]
 	devicesMu.RUnlock()
 
 	if devices == nil {
-		return "", nil, nil, fmt.Errorf("crypto not initialized (devices map nil)")
+		return "", nil, mc, fmt.Errorf("crypto not initialized (devices map nil)")
 	}
-	if !ok {
-		return "", nil, nil, fmt.Errorf("unknown deviceID: %q", deviceID)
+	if !known {
+		// Do the same ML-KEM and AEAD work as for a real device, so the
+		// reply time doesn't tell the sender which device IDs exist.
+		dev = deviceState{staticKey: unknownDeviceKey}
 	}
 	if serverDecapKey == nil {
-		return "", nil, nil, fmt.Errorf("serverDecapKey is nil")
+		return "", nil, mc, fmt.Errorf("serverDecapKey is nil")
+	}
+
+	// v4 names the server key. v3 doesn't, and ML-KEM decapsulation never
+	// fails on a ciphertext for another key (implicit rejection), so which
+	// key the phone used only shows when the AEAD opens: try the current
+	// key, then retired keys still in their overlap period.
+	// An unknown key ID still goes through the same work with the current
+	// key, and is only reported after the unknown-device check.
+	now := time.Now()
+	serverKeys := activeServerKeys(now)
+	unknownKID := false
+	if of.version == protocolVersionV4 {
+		k, ok := serverKeyByID(serverKeys, of.kid)
+		if !ok {
+			k, unknownKID = serverKeys[0], true
+		}
+		serverKeys = []serverKEMKey{k}
 	}
 
-	headerBaseEnd := 3 + idLen
-	if len(frame) < headerBaseEnd+2 {
-		return "", nil, nil, fmt.Errorf("frame too short for kemCtLen")
+	// Each with the device key and, during rekey grace, its previous key.
+	deviceKeys := [][]byte{dev.staticKey}
+	if len(dev.prevKey) > 0 && now.Unix() < dev.prevKeyUntil {
+		deviceKeys = append(deviceKeys, dev.prevKey)
 	}
 
-	kemCtLen := int(binary.BigEndian.Uint16(frame[headerBaseEnd : headerBaseEnd+2]))
-	if kemCtLen != mlkem768.CiphertextSize {
-		return "", nil, nil, fmt.Errorf("invalid kemCtLen: got %d expected %d", kemCtLen, mlkem768.CiphertextSize)
+	var openErr error
+	for _, sk := range serverKeys {
+		sharedKem, err := mlkem768.Decapsulate(sk.dk, of.kemCt)
+		if err != nil {
+			return "", nil, mc, fmt.Errorf("%w: mlkem768.Decapsulate failed: %v", ErrAuthFailed, err)
+		}
+		for i, dkey := range deviceKeys {
+			plaintext, err := openWithDeviceKey(dkey, sharedKem, of.nonce, of.ciphertext, of.aad)
+			if err != nil || !known || unknownKID {
+				openErr = err
+				continue
+			}
+			mc = msgContext{sharedKem: sharedKem, deviceKey: dkey, nonce: of.nonce, prevKey: i > 0, serverKey: sk,
+				sealReply: of.flags&outerFlagSealedReply != 0}
+			return deviceID, plaintext, mc, nil
+		}
 	}
-
-	kemStart := headerBaseEnd + 2
-	kemEnd := kemStart + kemCtLen
-	if len(frame) < kemEnd {
-		return "", nil, nil, fmt.Errorf("frame too short for kemCt")
+	if !known {
+		return "", nil, mc, fmt.Errorf("%w: %q", ErrUnknownDevice, deviceID)
 	}
-
-	kemCt := frame[kemStart:kemEnd]
-	header := frame[:kemEnd]
-
-	sharedKem, err := mlkem768.Decapsulate(serverDecapKey, kemCt)
-	if err != nil {
-		return "", nil, nil, fmt.Errorf("mlkem768.Decapsulate failed: %w", err)
+	if unknownKID {
+		return "", nil, mc, fmt.Errorf("%w: unknown or expired server key id %s", ErrAuthFailed, of.kid)
 	}
+	return "", nil, mc, fmt.Errorf("%w: AEAD.Open failed for device %q: %v", ErrAuthFailed, deviceID, openErr)
+}
 
-	aeadKey, err := deriveAEADKey(dev.staticKey, sharedKem)
+func openWithDeviceKey(deviceKey, sharedKem, nonce, ciphertext, header []byte) ([]byte, error) {
+	aeadKey, err := deriveAEADKey(deviceKey, sharedKem)
 	if err != nil {
-		return "", nil, nil, err
+		return nil, err
 	}
-
 	aead, err := chacha20poly1305.NewX(aeadKey)
 	if err != nil {
-		return "", nil, nil, fmt.Errorf("NewX failed: %w", err)
+		return nil, fmt.Errorf("NewX failed: %w", err)
 	}
+	return aead.Open(nil, nonce, ciphertext, header)
+}
 
-	rest := frame[kemEnd:]
-	nonceLen := aead.NonceSize()
-	if len(rest) < nonceLen+aead.Overhead() {
-		return "", nil, nil, fmt.Errorf("frame too short for nonce+ciphertext")
-	}
+// timestampError is returned by validateFreshnessAndRate when a message's
+// timestamp is outside the freshness window. The message has authenticated
+// by then, so the reply may tell the phone the server's time (Now).
+type timestampError struct {
+	TS  int64
+	Now int64
+}
 
-	nonce := rest[:nonceLen]
-	ciphertext := rest[nonceLen:]
+func (e *timestampError) Unwrap() error { return ErrStaleTimestamp }
 
-	plaintext, err := aead.Open(nil, nonce, ciphertext, header)
-	if err != nil {
-		return "", nil, nil, fmt.Errorf("AEAD.Open failed for device %q: %w", deviceID, err)
+func (e *timestampError) Error() string {
+	if e.TS > e.Now {
+		return fmt.Sprintf("message timestamp is in the future (ts=%d, now=%d)", e.TS, e.Now)
+	}
+	return fmt.Sprintf("message too old (ts=%d, now=%d)", e.TS, e.Now)
+}
+
+// freshnessWindows returns cfg's max_clock_skew_sec, max_msg_age_sec and
+// replay_cache_ttl_sec, with defaults for unset values.
+func freshnessWindows(cfg *ServerConfig) (skew, maxAge, replayTTL int64) {
+	skew, maxAge, replayTTL = defaultMaxClockSkewSec, defaultMaxMsgAgeSec, defaultReplayCacheTTL
+	if cfg.MaxClockSkewSec > 0 {
+		skew = int64(cfg.MaxClockSkewSec)
+	}
+	if cfg.MaxMsgAgeSec > 0 {
+		maxAge = int64(cfg.MaxMsgAgeSec)
 	}
-	return deviceID, plaintext, nonce, nil
+	if cfg.ReplayCacheTTLSec > 0 {
+		replayTTL = int64(cfg.ReplayCacheTTLSec)
+	}
+	return skew, maxAge, replayTTL
 }
 
-func validateFreshnessAndRate(deviceID string, nonce []byte, ts int64) error {
+func validateFreshnessAndRate(cfg *ServerConfig, deviceID string, nonce []byte, ts int64) error {
 	now := time.Now().Unix()
+	skew, maxAge, replayTTL := freshnessWindows(cfg)
 
 	// Freshness
-	if ts > now+maxClockSkewSec {
-		return fmt.Errorf("message timestamp is in the future (ts=%d, now=%d)", ts, now)
-	}
-	if now-ts > maxMsgAgeSec {
-		return fmt.Errorf("message too old (ts=%d, now=%d)", ts, now)
+	if ts > now+skew || now-ts > maxAge {
+		return &timestampError{TS: ts, Now: now}
 	}
 
 	nonceHex := hex.EncodeToString(nonce)
@@ -304,7 +558,7 @@ func validateFreshnessAndRate(deviceID string, nonce []byte, ts int64) error {
 
 	// Evict old replay entries
 	for k, seenAt := range m {
-		if now-seenAt > replayCacheTTL {
+		if now-seenAt > replayTTL {
 			delete(m, k)
 		}
 	}
@@ -322,17 +576,18 @@ func validateFreshnessAndRate(deviceID string, nonce []byte, ts int64) error {
 	}
 
 	if rw.count+1 > limit {
-		return fmt.Errorf("rate limit exceeded for device %q: %d requests in window (limit=%d)",
-			deviceID, rw.count+1, limit)
+		return fmt.Errorf("%w for device %q: %d requests in window (limit=%d)",
+			ErrRateLimited, deviceID, rw.count+1, limit)
 	}
 
 	// Replay check AFTER passing rate
 	if prevSeenAt, exists := m[nonceHex]; exists {
-		return fmt.Errorf("replay detected for device %q (nonce seen at ts=%d)", deviceID, prevSeenAt)
+		return fmt.Errorf("%w: replay detected for device %q (nonce seen at ts=%d)", ErrReplay, deviceID, prevSeenAt)
 	}
 
 	// Commit state
 	m[nonceHex] = now
+	journalReplay(deviceID, nonceHex, now)
 	rw.count++
 	rateState[deviceID] = rw
 
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"filippo.io/mlkem768"
+)
+
+// buildOuterHeader returns a /msg frame up to and including kemCt, plus a
+// zero nonce and a ciphertext the size of an empty AEAD output.
+func buildOuterHeader(version byte, v4 []byte, deviceID string) []byte {
+	f := []byte{version, msgTypePassword}
+	f = append(f, v4...)
+	f = append(f, byte(len(deviceID)))
+	f = append(f, deviceID...)
+	f = binary.BigEndian.AppendUint16(f, mlkem768.CiphertextSize)
+	f = append(f, make([]byte, mlkem768.CiphertextSize)...)
+	return append(f, make([]byte, 24+16)...)
+}
+
+func TestParseOuterFrame(t *testing.T) {
+	v3 := buildOuterHeader(protocolVersionV3, nil, "phone")
+	of, err := parseOuterFrame(v3)
+	if err != nil || of.deviceID != "phone" || of.kid != "" || len(of.aad) != len(v3)-40 {
+		t.Fatalf("v3: %+v, %v", of, err)
+	}
+
+	v4 := buildOuterHeader(protocolVersionV4, []byte{suiteMLKEM768XChaCha, 0, 0xde, 0xad, 0xbe, 0xef}, "phone")
+	of, err = parseOuterFrame(v4)
+	if err != nil || of.deviceID != "phone" || of.kid != "deadbeef" || !bytes.Equal(of.aad, v4[:len(v4)-40]) {
+		t.Fatalf("v4: %+v, %v", of, err)
+	}
+
+	for name, frame := range map[string][]byte{
+		"v5":            buildOuterHeader(5, nil, "phone"),
+		"unknown suite": buildOuterHeader(protocolVersionV4, []byte{2, 0, 1, 2, 3, 4}, "phone"),
+		"unknown flag":  buildOuterHeader(protocolVersionV4, []byte{suiteMLKEM768XChaCha, 0x80, 1, 2, 3, 4}, "phone"),
+		"short v4":      {protocolVersionV4, msgTypePassword, suiteMLKEM768XChaCha, 0, 1},
+		"truncated":     v4[:len(v4)-41],
+	} {
+		if _, err := parseOuterFrame(frame); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestValidateFreshness_UsesConfiguredWindows(t *testing.T) {
+	t.Cleanup(func() {
+		replayMu.Lock()
+		delete(replayCache, "clock-test")
+		delete(rateState, "clock-test")
+		replayMu.Unlock()
+	})
+
+	cfg := &ServerConfig{MaxClockSkewSec: 5, MaxMsgAgeSec: 10, ReplayCacheTTLSec: 15}
+	applyDefaults(cfg)
+	nonce := []byte("clock-test-nonce-0123456")
+	now := time.Now().Unix()
+
+	for _, ts := range []int64{now + 60, now - 60} {
+		err := validateFreshnessAndRate(cfg, "clock-test", nonce, ts)
+		var tsErr *timestampError
+		if !errors.As(err, &tsErr) || tsErr.TS != ts || tsErr.Now < now {
+			t.Fatalf("ts=%d: err=%v", ts, err)
+		}
+	}
+
+	// A rejected timestamp must not consume the nonce.
+	if err := validateFreshnessAndRate(cfg, "clock-test", nonce, now); err != nil {
+		t.Fatalf("fresh message: %v", err)
+	}
+}
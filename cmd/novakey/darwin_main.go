@@ -8,21 +8,26 @@ import (
 )
 
 func main() {
+	parseDaemonFlags()
+
 	if err := loadConfig(); err != nil {
 		log.Fatalf("loadConfig failed: %v", err)
 	}
 	initLoggingFromConfig()
+	logEffectiveConfig()
+	startConfigWatcher()
 
 	if err := initCrypto(); err != nil {
 		log.Fatalf("initCrypto failed: %v", err)
 	}
 
+	startControlListener()
 	maybeStartPairingQR()
 
 	if err := startUnifiedListener(); err != nil {
 		log.Fatalf("startUnifiedListener failed: %v", err)
 	}
 
-	log.Printf("NovaKey (macOS) started (listener=%s)", cfg.ListenAddr)
+	log.Printf("NovaKey (macOS) started (listener=%s)", currentConfig().ListenAddr)
 	select {}
 }
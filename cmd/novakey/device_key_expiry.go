@@ -0,0 +1,112 @@
+// cmd/novakey/device_key_expiry.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Device key lifetime.
+//
+// A key expires at the earlier of its device's expires_at_unix (if set) and
+// key_created_unix + device_key_max_age_days (if configured). Messages under
+// an expired key are authenticated first and then refused with
+// not_paired/rekey_required, so only the key holder learns why. Within
+// device_key_warn_days of expiry, replies carry a notice in msg so the phone
+// can rekey (MsgTypeRekey) or prompt for re-pairing ahead of time.
+
+type keyLifetime int
+
+const (
+	keyValid keyLifetime = iota
+	keyExpiring
+	keyExpired
+)
+
+const day = 24 * time.Hour
+
+// keyExpiry returns when st's key stops being accepted, or the zero time if
+// it never does.
+func keyExpiry(cfg *ServerConfig, st deviceState) time.Time {
+	var exp time.Time
+	if st.expiresAt > 0 {
+		exp = time.Unix(st.expiresAt, 0)
+	}
+	if cfg.DeviceKeyMaxAgeDays > 0 && st.keyCreated > 0 {
+		aged := time.Unix(st.keyCreated, 0).Add(time.Duration(cfg.DeviceKeyMaxAgeDays) * day)
+		if exp.IsZero() || aged.Before(exp) {
+			exp = aged
+		}
+	}
+	return exp
+}
+
+func checkKeyLifetime(cfg *ServerConfig, st deviceState, now time.Time) (keyLifetime, time.Time) {
+	exp := keyExpiry(cfg, st)
+	switch {
+	case exp.IsZero():
+		return keyValid, exp
+	case !now.Before(exp):
+		return keyExpired, exp
+	case now.Add(time.Duration(cfg.DeviceKeyWarnDays) * day).After(exp):
+		return keyExpiring, exp
+	default:
+		return keyValid, exp
+	}
+}
+
+// keyExpiryNotice is appended to reply msgs while a key is in its warning
+// window. key_expires_unix is machine-readable for clients.
+func keyExpiryNotice(exp time.Time) string {
+	return fmt.Sprintf("key_expires_unix=%d (rekey or re-pair before then)", exp.Unix())
+}
+
+// stampKeyCreation records key_created_unix for devices from stores that
+// predate it, so device_key_max_age_days can apply to them. Their age counts
+// from pairing when that is known, otherwise from now.
+func stampKeyCreation(path string, m map[string]deviceState) map[string]deviceState {
+	missing := false
+	for _, st := range m {
+		if st.keyCreated == 0 {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return m
+	}
+
+	unlock, err := lockDevicesStore(path)
+	if err != nil {
+		log.Printf("[devices] could not record key creation times: %v", err)
+		return m
+	}
+	defer unlock()
+
+	cur, err := loadDevicesFromDisk(path)
+	if err != nil {
+		log.Printf("[devices] could not record key creation times: %v", err)
+		return m
+	}
+
+	now := time.Now().Unix()
+	n := 0
+	for id, st := range cur {
+		if st.keyCreated != 0 {
+			continue
+		}
+		st.keyCreated = st.meta.PairedAtUnix
+		if st.keyCreated == 0 {
+			st.keyCreated = now
+		}
+		cur[id] = st
+		n++
+	}
+	if err := saveDevicesToDisk(path, devicesFileFromMap(cur)); err != nil {
+		log.Printf("[devices] could not record key creation times: %v", err)
+		return m
+	}
+	log.Printf("[devices] recorded key creation time for %d device(s)", n)
+	return cur
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckKeyLifetime(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	cfg := &ServerConfig{DeviceKeyMaxAgeDays: 90, DeviceKeyWarnDays: 14}
+	created := func(daysAgo int) int64 { return now.Add(-time.Duration(daysAgo) * day).Unix() }
+
+	cases := []struct {
+		name string
+		cfg  *ServerConfig
+		st   deviceState
+		want keyLifetime
+	}{
+		{"fresh key", cfg, deviceState{keyCreated: created(10)}, keyValid},
+		{"in warning window", cfg, deviceState{keyCreated: created(80)}, keyExpiring},
+		{"past max age", cfg, deviceState{keyCreated: created(91)}, keyExpired},
+		{"unknown creation never ages", cfg, deviceState{}, keyValid},
+		{"explicit expiry before max age", cfg, deviceState{keyCreated: created(10), expiresAt: now.Add(-time.Hour).Unix()}, keyExpired},
+		{"explicit expiry without max age", &ServerConfig{DeviceKeyWarnDays: 14}, deviceState{expiresAt: now.Add(3 * day).Unix()}, keyExpiring},
+		{"max age off", &ServerConfig{DeviceKeyWarnDays: 14}, deviceState{keyCreated: created(1000)}, keyValid},
+	}
+	for _, tc := range cases {
+		if got, _ := checkKeyLifetime(tc.cfg, tc.st, now); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// cmd/novakey/device_meta.go
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// deviceMeta is descriptive metadata kept with each device in the store
+// (schema version 2). It helps people tell devices apart; nothing in it is
+// used for authorization, and all of it is optional.
+type deviceMeta struct {
+	Label        string `json:"label,omitempty"`          // human label ("Alice's iPhone")
+	PairedAtUnix int64  `json:"paired_at_unix,omitempty"` // when the device was (last) paired
+	PairedFrom   string `json:"paired_from,omitempty"`    // remote IP of the pairing connection (or the tool that added it)
+	Platform     string `json:"platform,omitempty"`       // from the register message, e.g. "ios"
+	AppVersion   string `json:"app_version,omitempty"`    // from the register message
+	LastSeenUnix int64  `json:"last_seen_unix,omitempty"` // last authenticated message
+	MsgCount     uint64 `json:"msg_count,omitempty"`      // authenticated messages received
+}
+
+// maxMetaLen bounds client-supplied metadata strings (in runes).
+const maxMetaLen = 64
+
+// cleanMetaString trims s, drops control characters (so it is safe to print
+// in logs and tables) and caps its length.
+func cleanMetaString(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+
+	if r := []rune(s); len(r) > maxMetaLen {
+		s = string(r[:maxMetaLen])
+	}
+	return s
+}
+
+// Activity (last_seen_unix, msg_count) changes on every message, so it is
+// counted in memory and written to the store at most once per
+// deviceActivityFlushDelay. A crash loses at most that much activity.
+const deviceActivityFlushDelay = 30 * time.Second
+
+type deviceActivity struct {
+	lastSeen int64
+	count    uint64
+}
+
+var (
+	activityMu      sync.Mutex
+	activityPending = make(map[string]deviceActivity)
+	activityTimer   *time.Timer
+)
+
+// noteDeviceActivity records one authenticated message from deviceID.
+func noteDeviceActivity(deviceID string) {
+	now := time.Now().Unix()
+
+	devicesMu.Lock()
+	if st, ok := devices[deviceID]; ok {
+		st.meta.LastSeenUnix = now
+		st.meta.MsgCount++
+		devices[deviceID] = st
+	}
+	devicesMu.Unlock()
+
+	activityMu.Lock()
+	defer activityMu.Unlock()
+	a := activityPending[deviceID]
+	a.lastSeen = now
+	a.count++
+	activityPending[deviceID] = a
+	if activityTimer == nil {
+		activityTimer = time.AfterFunc(deviceActivityFlushDelay, flushDeviceActivity)
+	}
+}
+
+func flushDeviceActivity() {
+	activityMu.Lock()
+	pending := activityPending
+	activityPending = make(map[string]deviceActivity)
+	activityTimer = nil
+	activityMu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	if err := applyDeviceActivity(currentConfig().DevicesFile, pending); err != nil {
+		log.Printf("[devices] saving last-seen metadata failed: %v", err)
+	}
+}
+
+// applyDeviceActivity merges pending activity into the store on disk. Devices
+// that were revoked in the meantime are skipped, never re-added.
+func applyDeviceActivity(path string, pending map[string]deviceActivity) error {
+	unlock, err := lockDevicesStore(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m, err := loadDevicesFromDisk(path)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for id, a := range pending {
+		st, ok := m[id]
+		if !ok {
+			continue
+		}
+		if a.lastSeen > st.meta.LastSeenUnix {
+			st.meta.LastSeenUnix = a.lastSeen
+		}
+		st.meta.MsgCount += a.count
+		m[id] = st
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return saveDevicesToDisk(path, devicesFileFromMap(m))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDevicesStore_V1LoadsAndRewritesAsV2(t *testing.T) {
+	v1 := `{"devices":[{"id":"ios-3fa2","key_hex":"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"}]}`
+
+	var dc devicesConfigFile
+	if err := json.Unmarshal([]byte(v1), &dc); err != nil {
+		t.Fatal(err)
+	}
+	m, err := buildDevicesMap(dc, "devices.json")
+	if err != nil {
+		t.Fatalf("v1 store rejected: %v", err)
+	}
+
+	st := m["ios-3fa2"]
+	st.meta.Label = "Alice's iPhone"
+	st.meta.MsgCount = 7
+	m["ios-3fa2"] = st
+
+	out, err := json.Marshal(devicesFileFromMap(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"version":2`, `"label":"Alice's iPhone"`, `"msg_count":7`} {
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("rewritten store %s missing %s", out, want)
+		}
+	}
+
+	var back devicesConfigFile
+	if err := json.Unmarshal(out, &back); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := buildDevicesMap(back, "devices.json")
+	if err != nil || m2["ios-3fa2"].meta.Label != "Alice's iPhone" {
+		t.Fatalf("round trip: %+v, %v", m2, err)
+	}
+}
+
+func TestDevicesStore_RejectsNewerVersion(t *testing.T) {
+	dc := devicesConfigFile{Version: devicesStoreVersion + 1, Devices: []deviceConfig{{
+		ID:     "phone",
+		KeyHex: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+	}}}
+	if _, err := buildDevicesMap(dc, "devices.json"); !errors.Is(err, ErrDevicesUnavailable) {
+		t.Fatalf("err=%v, want ErrDevicesUnavailable", err)
+	}
+}
+
+func TestCleanMetaString(t *testing.T) {
+	if got := cleanMetaString("  iPhone\x1b[31m\n "); got != "iPhone[31m" {
+		t.Fatalf("got %q", got)
+	}
+	if got := cleanMetaString(strings.Repeat("é", 100)); len([]rune(got)) != maxMetaLen {
+		t.Fatalf("not capped: %d runes", len([]rune(got)))
+	}
+}
@@ -0,0 +1,105 @@
+// cmd/novakey/device_roles.go
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Device roles. A device with no role stored is treated as RoleBoth so stores
+// written before roles existed keep working.
+const (
+	RoleBoth     = "both"
+	RoleInjector = "injector"
+	RoleApprover = "approver"
+)
+
+// Two-man modes:
+//   - self: the injecting device approves its own inject (approve, then inject)
+//   - dual: the approval must come from a different paired device
+const (
+	TwoManModeSelf = "self"
+	TwoManModeDual = "dual"
+)
+
+func normalizeDeviceRole(role string) (string, error) {
+	switch r := strings.ToLower(strings.TrimSpace(role)); r {
+	case "", RoleBoth:
+		return RoleBoth, nil
+	case RoleInjector, RoleApprover:
+		return r, nil
+	default:
+		return "", fmt.Errorf("unknown role %q (want injector, approver or both)", role)
+	}
+}
+
+func (st deviceState) canInject() bool  { return st.role != RoleApprover }
+func (st deviceState) canApprove() bool { return st.role != RoleInjector }
+
+func lookupDevice(deviceID string) (deviceState, bool) {
+	devicesMu.RLock()
+	defer devicesMu.RUnlock()
+	st, ok := devices[deviceID]
+	return st, ok
+}
+
+// approversFor returns the devices whose approval unlocks an inject from
+// injectorID under the given two-man mode.
+//
+// In dual mode the injector's own approval never counts. If the injector has
+// an explicit approvers list only those devices count; otherwise any other
+// paired device with the approver role does.
+func approversFor(injectorID, mode string) []string {
+	if mode != TwoManModeDual {
+		return []string{injectorID}
+	}
+
+	devicesMu.RLock()
+	defer devicesMu.RUnlock()
+
+	inj := devices[injectorID]
+	var out []string
+	if len(inj.approvers) > 0 {
+		for _, id := range inj.approvers {
+			if st, ok := devices[id]; ok && id != injectorID && st.canApprove() {
+				out = append(out, id)
+			}
+		}
+		return out
+	}
+	for id, st := range devices {
+		if id != injectorID && st.canApprove() {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// approvalTargets returns the injectors an Approve from approverID can be
+// for: every paired injector with two-man on whose effective mode (global or
+// profile, from cfg) counts approverID as an approver.
+func approvalTargets(cfg *ServerConfig, approverID string) []string {
+	devicesMu.RLock()
+	ids := make([]string, 0, len(devices))
+	for id, st := range devices {
+		if st.canInject() {
+			ids = append(ids, id)
+		}
+	}
+	devicesMu.RUnlock()
+	sort.Strings(ids)
+
+	var out []string
+	for _, id := range ids {
+		eff, _, err := policyForDevice(cfg, id)
+		if err != nil || !boolDeref(eff.TwoManEnabled, true) {
+			continue
+		}
+		if slices.Contains(approversFor(id, eff.TwoManMode), approverID) {
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestApproversFor_DualExcludesInjector(t *testing.T) {
+	withTestDevices(t, map[string]deviceState{
+		"laptop-phone": {id: "laptop-phone", role: RoleInjector},
+		"admin-phone":  {id: "admin-phone", role: RoleApprover},
+		"other-phone":  {id: "other-phone", role: RoleBoth},
+		"kiosk":        {id: "kiosk", role: RoleInjector, approvers: []string{"admin-phone", "kiosk", "laptop-phone"}},
+	})
+
+	if got := approversFor("other-phone", TwoManModeSelf); !reflect.DeepEqual(got, []string{"other-phone"}) {
+		t.Fatalf("self mode approvers = %v", got)
+	}
+
+	got := approversFor("other-phone", TwoManModeDual)
+	sort.Strings(got)
+	if want := []string{"admin-phone"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("dual approvers = %v, want %v", got, want)
+	}
+
+	// Explicit list: the injector itself and injector-only devices never count.
+	if got := approversFor("kiosk", TwoManModeDual); !reflect.DeepEqual(got, []string{"admin-phone"}) {
+		t.Fatalf("kiosk approvers = %v", got)
+	}
+}
+
+func TestTwoManGate_ConsumeFromOnlyAllowedApprovers(t *testing.T) {
+	g := newTwoManGate()
+	g.Approve("injector", "injector", time.Minute)
+
+	if _, ok := g.ConsumeFrom("injector", []string{"approver"}, true); ok {
+		t.Fatal("self approval satisfied a dual-control inject")
+	}
+	if live := g.LiveApproversFor("injector"); len(live) != 1 || live[0] != "injector" {
+		t.Fatalf("live approvers = %v", live)
+	}
+
+	g.Approve("approver", "injector", time.Minute)
+	if by, ok := g.ConsumeFrom("injector", []string{"approver"}, true); !ok || by != "approver" {
+		t.Fatalf("ConsumeFrom = %q, %v", by, ok)
+	}
+	if _, ok := g.ConsumeFrom("injector", []string{"approver"}, true); ok {
+		t.Fatal("approval was not consumed")
+	}
+}
+
+func TestTwoManGate_ApprovalIsForOneInjector(t *testing.T) {
+	g := newTwoManGate()
+	g.Approve("approver", "injector-a", time.Minute)
+
+	// Injector B sees neither a usable approval nor a wrong_approver.
+	if _, ok := g.ConsumeFrom("injector-b", []string{"approver"}, true); ok {
+		t.Fatal("approval for injector-a released injector-b")
+	}
+	if live := g.LiveApproversFor("injector-b"); len(live) != 0 {
+		t.Fatalf("live approvers for injector-b = %v", live)
+	}
+	if by, ok := g.ConsumeFrom("injector-a", []string{"approver"}, true); !ok || by != "approver" {
+		t.Fatalf("ConsumeFrom(injector-a) = %q, %v", by, ok)
+	}
+}
+
+func TestApprovalTargets(t *testing.T) {
+	withTestDevices(t, map[string]deviceState{
+		"team-phone":  {id: "team-phone", role: RoleInjector, approvers: []string{"admin-phone"}},
+		"kiosk":       {id: "kiosk", role: RoleInjector, approvers: []string{"other-admin"}},
+		"admin-phone": {id: "admin-phone", role: RoleApprover},
+		"other-admin": {id: "other-admin", role: RoleApprover},
+		"personal":    {id: "personal", profile: "solo"},
+	})
+	self := TwoManModeSelf
+	cfg := &ServerConfig{
+		TwoManMode: TwoManModeDual,
+		Profiles:   map[string]PolicyProfile{"solo": {TwoManMode: &self}},
+	}
+	applyDefaults(cfg)
+
+	if got := approvalTargets(cfg, "admin-phone"); !reflect.DeepEqual(got, []string{"team-phone"}) {
+		t.Fatalf("admin-phone targets = %v", got)
+	}
+	if got := approvalTargets(cfg, "personal"); !reflect.DeepEqual(got, []string{"personal"}) {
+		t.Fatalf("personal targets = %v", got)
+	}
+}
+
+func TestBuildDevicesMap_RejectsUnknownRole(t *testing.T) {
+	dc := devicesConfigFile{Devices: []deviceConfig{{
+		ID:     "phone",
+		KeyHex: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		Role:   "admin",
+	}}}
+	if _, err := buildDevicesMap(dc, "devices.json"); err == nil {
+		t.Fatal("expected error for unknown role")
+	}
+}
@@ -0,0 +1,389 @@
+// cmd/novakey/devices_cli.go
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func init() {
+	// One-shot command: `novakey devices list|show|label|expire|revoke|rename|rekey ...`
+	if len(os.Args) >= 2 && os.Args[1] == "devices" {
+		os.Exit(runDevicesCommand(os.Args[2:]))
+	}
+}
+
+const devicesUsage = `usage:
+  novakey devices list   [-config path] [-json]
+  novakey devices show   [-config path] [-json] <device-id>
+  novakey devices label  [-config path] <device-id> <label>
+  novakey devices expire [-config path] <device-id> <YYYY-MM-DD|never>
+  novakey devices revoke [-config path] <device-id>
+  novakey devices rename [-config path] <device-id> <new-id>
+  novakey devices rekey  [-config path] <device-id>`
+
+// deviceInfo is the printable view of a stored device. The key itself is
+// never shown; key_fp is a short SHA-256 fingerprint of it.
+type deviceInfo struct {
+	ID        string   `json:"id"`
+	Role      string   `json:"role"`
+	Profile   string   `json:"profile,omitempty"`
+	Approvers []string `json:"approvers,omitempty"`
+	KeyFP     string   `json:"key_fp"`
+
+	KeyCreatedUnix int64 `json:"key_created_unix,omitempty"`
+	ExpiresAtUnix  int64 `json:"expires_at_unix,omitempty"`  // as stored
+	KeyExpiresUnix int64 `json:"key_expires_unix,omitempty"` // effective, including device_key_max_age_days
+	KeyExpired     bool  `json:"key_expired,omitempty"`
+
+	deviceMeta
+}
+
+// runDevicesCommand edits the device store (sealed or plaintext) through
+// loadDevicesFromDisk/saveDevicesToDisk, then asks a running daemon to reload
+// it over the control channel so changes apply immediately.
+func runDevicesCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, devicesUsage)
+		return 2
+	}
+	sub := args[0]
+
+	fs := flag.NewFlagSet("devices "+sub, flag.ContinueOnError)
+	fs.StringVar(&configFlagPath, "config", "", "path to server config file")
+	asJSON := false
+	nargs := 0
+	switch sub {
+	case "list":
+		fs.BoolVar(&asJSON, "json", false, "print as JSON")
+	case "show":
+		fs.BoolVar(&asJSON, "json", false, "print as JSON")
+		nargs = 1
+	case "revoke", "rekey":
+		nargs = 1
+	case "rename", "label", "expire":
+		nargs = 2
+	default:
+		fmt.Fprintf(os.Stderr, "novakey devices: unknown subcommand %q\n%s\n", sub, devicesUsage)
+		return 2
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != nargs {
+		fmt.Fprintln(os.Stderr, devicesUsage)
+		return 2
+	}
+
+	if err := runDevicesSubcommand(sub, fs.Args(), asJSON); err != nil {
+		fmt.Fprintf(os.Stderr, "novakey devices %s: %v\n", sub, err)
+		return 1
+	}
+	// Only once the store lock is released: the daemon's reload may need it.
+	if sub != "list" && sub != "show" {
+		notifyDevicesChanged()
+	}
+	return 0
+}
+
+func runDevicesSubcommand(sub string, args []string, asJSON bool) error {
+	if err := loadConfig(); err != nil {
+		return err
+	}
+	path := currentConfig().DevicesFile
+
+	if sub != "list" && sub != "show" {
+		unlock, err := lockDevicesStore(path)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	m, err := loadDevicesFromDisk(path)
+	if errors.Is(err, ErrNotPaired) {
+		m, err = map[string]deviceState{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "list":
+		infos := make([]deviceInfo, 0, len(m))
+		for _, st := range m {
+			infos = append(infos, st.info())
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+		if asJSON {
+			return printJSON(infos)
+		}
+		printDeviceTable(infos)
+		return nil
+
+	case "show":
+		st, ok := m[args[0]]
+		if !ok {
+			return fmt.Errorf("no device %q", args[0])
+		}
+		if asJSON {
+			return printJSON(st.info())
+		}
+		printDeviceDetails(st.info())
+		return nil
+
+	case "label":
+		id := args[0]
+		st, ok := m[id]
+		if !ok {
+			return fmt.Errorf("no device %q", id)
+		}
+		st.meta.Label = cleanMetaString(args[1])
+		m[id] = st
+		if err := saveDevicesToDisk(path, devicesFileFromMap(m)); err != nil {
+			return err
+		}
+		if st.meta.Label == "" {
+			fmt.Printf("cleared label of %s\n", id)
+		} else {
+			fmt.Printf("labelled %s %q\n", id, st.meta.Label)
+		}
+		return nil
+
+	case "expire":
+		id := args[0]
+		st, ok := m[id]
+		if !ok {
+			return fmt.Errorf("no device %q", id)
+		}
+		if strings.EqualFold(args[1], "never") {
+			st.expiresAt = 0
+		} else {
+			t, err := time.ParseInLocation("2006-01-02", args[1], time.Local)
+			if err != nil {
+				return fmt.Errorf("expiry must be YYYY-MM-DD or never: %w", err)
+			}
+			st.expiresAt = t.Unix()
+		}
+		m[id] = st
+		if err := saveDevicesToDisk(path, devicesFileFromMap(m)); err != nil {
+			return err
+		}
+		if st.expiresAt == 0 {
+			fmt.Printf("cleared explicit expiry of %s\n", id)
+		} else {
+			fmt.Printf("key of %s expires %s\n", id, unixText(st.expiresAt))
+		}
+		return nil
+
+	case "revoke":
+		id := args[0]
+		if _, ok := m[id]; !ok {
+			return fmt.Errorf("no device %q", id)
+		}
+		delete(m, id)
+		for k, st := range m {
+			st.approvers = removeString(st.approvers, id)
+			m[k] = st
+		}
+		if err := saveDevicesToDisk(path, devicesFileFromMap(m)); err != nil {
+			return err
+		}
+		fmt.Printf("revoked %s (%d device(s) left)\n", id, len(m))
+		warnConfigReferences(id)
+		return nil
+
+	case "rename":
+		id, newID := args[0], strings.TrimSpace(args[1])
+		st, ok := m[id]
+		if !ok {
+			return fmt.Errorf("no device %q", id)
+		}
+		if newID == "" {
+			return fmt.Errorf("new id is empty")
+		}
+		if _, taken := m[newID]; taken {
+			return fmt.Errorf("device %q already exists", newID)
+		}
+		delete(m, id)
+		st.id = newID
+		m[newID] = st
+		for k, other := range m {
+			for i, a := range other.approvers {
+				if a == id {
+					other.approvers[i] = newID
+				}
+			}
+			m[k] = other
+		}
+		if err := saveDevicesToDisk(path, devicesFileFromMap(m)); err != nil {
+			return err
+		}
+		fmt.Printf("renamed %s -> %s\n", id, newID)
+		fmt.Println("The phone identifies itself by device ID; update it to the new ID or it will be rejected.")
+		warnConfigReferences(id)
+		return nil
+
+	case "rekey":
+		id := args[0]
+		st, ok := m[id]
+		if !ok {
+			return fmt.Errorf("no device %q", id)
+		}
+		key := make([]byte, chacha20poly1305.KeySize)
+		if _, err := rand.Read(key); err != nil {
+			return fmt.Errorf("generate key: %w", err)
+		}
+		st.staticKey, st.prevKey, st.prevKeyUntil = key, nil, 0
+		st.keyCreated, st.expiresAt = time.Now().Unix(), 0
+		m[id] = st
+		if err := saveDevicesToDisk(path, devicesFileFromMap(m)); err != nil {
+			return err
+		}
+		fmt.Printf("rekeyed %s; the old key no longer works.\n", id)
+		fmt.Printf("new device_key_hex: %s\n", hex.EncodeToString(key))
+		fmt.Println("Provision this key on the device (or re-pair it); it is not shown again.")
+		return nil
+	}
+	return nil
+}
+
+func (st deviceState) info() deviceInfo {
+	role := st.role
+	if role == "" {
+		role = RoleBoth
+	}
+	info := deviceInfo{
+		ID:             st.id,
+		Role:           role,
+		Profile:        st.profile,
+		Approvers:      st.approvers,
+		KeyFP:          deviceKeyFingerprint(st.staticKey),
+		KeyCreatedUnix: st.keyCreated,
+		ExpiresAtUnix:  st.expiresAt,
+		deviceMeta:     st.meta,
+	}
+	if life, exp := checkKeyLifetime(currentConfig(), st, time.Now()); !exp.IsZero() {
+		info.KeyExpiresUnix = exp.Unix()
+		info.KeyExpired = life == keyExpired
+	}
+	return info
+}
+
+func deviceKeyFingerprint(key []byte) string {
+	h := sha256.Sum256(key)
+	return hex.EncodeToString(h[:8])
+}
+
+func printDeviceTable(infos []deviceInfo) {
+	if len(infos) == 0 {
+		fmt.Println("no paired devices")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE\tLABEL\tROLE\tPROFILE\tLAST SEEN\tKEY\tKEY EXPIRES")
+	for _, d := range infos {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			d.ID, orDash(d.Label), d.Role, orDash(d.Profile), unixText(d.LastSeenUnix), d.KeyFP, keyExpiresText(d))
+	}
+	_ = w.Flush()
+}
+
+func printDeviceDetails(d deviceInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "id:\t%s\n", d.ID)
+	if d.Label != "" {
+		fmt.Fprintf(w, "label:\t%s\n", d.Label)
+	}
+	fmt.Fprintf(w, "role:\t%s\n", d.Role)
+	if d.Profile != "" {
+		fmt.Fprintf(w, "profile:\t%s\n", d.Profile)
+	}
+	if len(d.Approvers) > 0 {
+		fmt.Fprintf(w, "approvers:\t%s\n", strings.Join(d.Approvers, ", "))
+	}
+	fmt.Fprintf(w, "key fingerprint:\t%s\n", d.KeyFP)
+	fmt.Fprintf(w, "key created:\t%s\n", unixText(d.KeyCreatedUnix))
+	fmt.Fprintf(w, "key expires:\t%s\n", keyExpiresText(d))
+	fmt.Fprintf(w, "paired:\t%s\n", unixText(d.PairedAtUnix))
+	if d.PairedFrom != "" {
+		fmt.Fprintf(w, "paired from:\t%s\n", d.PairedFrom)
+	}
+	if d.Platform != "" || d.AppVersion != "" {
+		fmt.Fprintf(w, "client:\t%s\n", strings.TrimSpace(d.Platform+" "+d.AppVersion))
+	}
+	fmt.Fprintf(w, "last seen:\t%s\n", unixText(d.LastSeenUnix))
+	fmt.Fprintf(w, "messages:\t%d\n", d.MsgCount)
+	_ = w.Flush()
+}
+
+func unixText(sec int64) string {
+	if sec == 0 {
+		return "-"
+	}
+	return time.Unix(sec, 0).Format("2006-01-02 15:04")
+}
+
+func keyExpiresText(d deviceInfo) string {
+	switch {
+	case d.KeyExpiresUnix == 0:
+		return "never"
+	case d.KeyExpired:
+		return unixText(d.KeyExpiresUnix) + " (expired)"
+	default:
+		return unixText(d.KeyExpiresUnix)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// warnConfigReferences points out config entries that still name a device
+// that was revoked or renamed.
+func warnConfigReferences(id string) {
+	if stringInSlice(id, currentConfig().ApproverGroup) {
+		fmt.Fprintf(os.Stderr, "note: approver_group in the server config still lists %q\n", id)
+	}
+}
+
+// notifyDevicesChanged asks a running daemon to reload the device store. If
+// none is reachable the change simply applies at its next start.
+func notifyDevicesChanged() {
+	if _, err := controlCall(currentConfig().ControlSocket, controlRequest{Op: "reload-devices"}); err != nil {
+		fmt.Fprintf(os.Stderr, "note: running daemon not updated (%v); the change applies when it next starts\n", err)
+		return
+	}
+	fmt.Println("running daemon reloaded the device store")
+}
+
+func removeString(list []string, s string) []string {
+	out := list[:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
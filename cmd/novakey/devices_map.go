@@ -0,0 +1,53 @@
+// cmd/novakey/devices_map.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// devicesFileFromMap converts loaded device state back to its persisted form,
+// sorted by ID so rewrites of the store are stable.
+func devicesFileFromMap(m map[string]deviceState) devicesConfigFile {
+	dc := devicesConfigFile{Version: devicesStoreVersion, Devices: make([]deviceConfig, 0, len(m))}
+	for _, st := range m {
+		dc.Devices = append(dc.Devices, st.record())
+	}
+	sort.Slice(dc.Devices, func(i, j int) bool { return dc.Devices[i].ID < dc.Devices[j].ID })
+	return dc
+}
+
+// Store lock. The daemon (pairing, activity flushes) and `novakey devices`
+// both read-modify-write the store; without a lock a background write could
+// undo a revoke made a moment earlier. An O_EXCL lock file works the same on
+// every platform. A lock older than devicesLockStale is assumed to belong to
+// a crashed process.
+const (
+	devicesLockWait  = 5 * time.Second
+	devicesLockStale = 30 * time.Second
+)
+
+func lockDevicesStore(path string) (unlock func(), err error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(devicesLockWait)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("lock %s: %w", lock, err)
+		}
+		if fi, serr := os.Stat(lock); serr == nil && time.Since(fi.ModTime()) > devicesLockStale {
+			_ = os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("device store is locked (%s); another novakey process is writing it", lock)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -27,7 +27,7 @@ func saveDevicesToDisk(path string, dc devicesConfigFile) error {
 
 	key, err := getOrCreateDevicesKey()
 	if err != nil {
-		if cfg.RequireSealedDeviceStore {
+		if currentConfig().RequireSealedDeviceStore {
 			return fmt.Errorf("%w: require_sealed_device_store=true but keyring is unavailable: %v",
 				ErrDevicesUnavailable, err)
 		}
@@ -4,17 +4,18 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // saveDevicesToDisk persists the devicesConfigFile to disk on Windows.
 // This is the counterpart to loadDevicesFromDisk(path) implemented in *_windows.go.
 //
-// Format: JSON (same shape as devicesConfigFile).
-// Write strategy: atomic-ish (write temp + replace).
+// Format: DPAPI-wrapped JSON at preferDPAPIPath(path), which is the file
+// loadDevicesFromDisk reads first. A plaintext file left at path would be
+// migrated over the sealed one on a later load, so it is removed.
 func saveDevicesToDisk(path string, dc devicesConfigFile) error {
 	if path == "" {
 		return fmt.Errorf("saveDevicesToDisk: empty path")
@@ -28,30 +29,13 @@ func saveDevicesToDisk(path string, dc devicesConfigFile) error {
 		}
 	}
 
-	b, err := json.MarshalIndent(dc, "", "  ")
-	if err != nil {
-		return fmt.Errorf("saveDevicesToDisk: marshal: %w", err)
+	sealed := preferDPAPIPath(path)
+	if err := writeDevicesDPAPIFile(sealed, dc); err != nil {
+		return fmt.Errorf("saveDevicesToDisk: %w", err)
 	}
 
-	tmp := path + ".tmp"
-
-	// Best-effort cleanup.
-	_ = os.Remove(tmp)
-
-	// Write tmp file first.
-	// Note: perms are best-effort on Windows.
-	if err := os.WriteFile(tmp, b, 0o600); err != nil {
-		return fmt.Errorf("saveDevicesToDisk: write tmp: %w", err)
+	if !strings.EqualFold(sealed, path) {
+		_ = os.Remove(path)
 	}
-
-	// On Windows, os.Rename won't reliably overwrite an existing file.
-	_ = os.Remove(path)
-
-	if err := os.Rename(tmp, path); err != nil {
-		_ = os.Remove(tmp)
-		return fmt.Errorf("saveDevicesToDisk: rename: %w", err)
-	}
-
 	return nil
 }
-
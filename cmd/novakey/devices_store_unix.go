@@ -42,7 +42,7 @@ func loadDevicesFromDisk(path string) (map[string]deviceState, error) {
 
 	// If the file is not a sealed wrapper, it's plaintext JSON.
 	// If require_sealed_device_store is enabled, fail closed.
-	if cfg.RequireSealedDeviceStore {
+	if currentConfig().RequireSealedDeviceStore {
 		return nil, fmt.Errorf("%w: require_sealed_device_store=true but devices file is not sealed (plaintext): %s",
 			ErrDevicesUnavailable, path)
 	}
@@ -4,7 +4,6 @@
 package main
 
 import (
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -32,7 +31,7 @@ func loadDevicesFromDisk(path string) (map[string]deviceState, error) {
 
 		pm, perr := loadDevicesFromPlainJSON(plain)
 		if perr == nil {
-			_ = writeDevicesDPAPIFile(prefer, pm)
+			_ = writeDevicesDPAPIFile(prefer, devicesFileFromMap(pm))
 			_ = os.Remove(plain)
 			return loadDevicesFromDPAPIFile(prefer)
 		}
@@ -116,15 +115,7 @@ func loadDevicesFromPlainJSON(path string) (map[string]deviceState, error) {
 	return buildDevicesMap(dc, path)
 }
 
-func writeDevicesDPAPIFile(path string, m map[string]deviceState) error {
-	inner := devicesConfigFile{Devices: make([]deviceConfig, 0, len(m))}
-	for _, st := range m {
-		inner.Devices = append(inner.Devices, deviceConfig{
-			ID:     st.id,
-			KeyHex: hex.EncodeToString(st.staticKey),
-		})
-	}
-
+func writeDevicesDPAPIFile(path string, inner devicesConfigFile) error {
 	innerJSON, err := json.Marshal(inner)
 	if err != nil {
 		return err
@@ -10,8 +10,9 @@ import (
 )
 
 // writeDevicesFile upserts (deviceID, deviceKeyHex) into the persisted device store.
-// It is used by pairing_proto.go.
-func writeDevicesFile(path string, deviceID string, deviceKeyHex string) error {
+// It is used by pairing_proto.go. paired carries the pairing metadata
+// (paired_at, source, platform, app version and an optional label).
+func writeDevicesFile(path string, deviceID string, deviceKeyHex string, paired deviceMeta) error {
 	if deviceID == "" {
 		return fmt.Errorf("writeDevicesFile: empty deviceID")
 	}
@@ -23,6 +24,12 @@ func writeDevicesFile(path string, deviceID string, deviceKeyHex string) error {
 		return fmt.Errorf("writeDevicesFile: device key must be %d bytes, got %d", chacha20poly1305.KeySize, len(k))
 	}
 
+	unlock, err := lockDevicesStore(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Load existing store (or start fresh if not paired yet).
 	existing, err := loadDevicesFromDisk(path)
 	if err != nil {
@@ -33,17 +40,23 @@ func writeDevicesFile(path string, deviceID string, deviceKeyHex string) error {
 		}
 	}
 
-	// Upsert
-	existing[deviceID] = deviceState{id: deviceID, staticKey: k}
-
-	// Convert to devicesConfigFile and persist via platform-specific saveDevicesToDisk.
-	dc := devicesConfigFile{Devices: make([]deviceConfig, 0, len(existing))}
-	for _, st := range existing {
-		dc.Devices = append(dc.Devices, deviceConfig{
-			ID:     st.id,
-			KeyHex: hex.EncodeToString(st.staticKey),
-		})
+	// Upsert (re-pairing keeps the device's profile, role, approvers, label
+	// and activity counters)
+	st := existing[deviceID]
+	if !constTimeEq(st.staticKey, k) {
+		st.keyCreated, st.expiresAt = paired.PairedAtUnix, 0
+		st.prevKey, st.prevKeyUntil = nil, 0
+	}
+	st.id, st.staticKey = deviceID, k
+	st.meta.PairedAtUnix = paired.PairedAtUnix
+	st.meta.PairedFrom = paired.PairedFrom
+	st.meta.Platform = paired.Platform
+	st.meta.AppVersion = paired.AppVersion
+	if paired.Label != "" {
+		st.meta.Label = paired.Label
 	}
+	existing[deviceID] = st
 
-	return saveDevicesToDisk(path, dc)
+	// Persist via platform-specific saveDevicesToDisk.
+	return saveDevicesToDisk(path, devicesFileFromMap(existing))
 }
@@ -10,3 +10,15 @@ var ErrNotPaired = errors.New("not paired (devices file missing/empty)")
 // ErrDevicesUnavailable means the store exists but cannot be read/decrypted/parsed.
 // This must be treated as fatal (do NOT start pairing automatically).
 var ErrDevicesUnavailable = errors.New("devices unavailable (cannot decrypt/access device store)")
+
+// /msg rejections from decryptMessageFrame. ErrUnknownDevice, ErrAuthFailed
+// and a malformed outer frame happen before the sender is authenticated and
+// all reply crypto_fail; the rest map to their own status (see classifyMsgError).
+var (
+	ErrBadFrame       = errors.New("malformed frame")
+	ErrUnknownDevice  = errors.New("unknown device")
+	ErrAuthFailed     = errors.New("authentication failed")
+	ErrStaleTimestamp = errors.New("timestamp outside freshness window")
+	ErrReplay         = errors.New("replayed nonce")
+	ErrRateLimited    = errors.New("rate limit exceeded")
+)
@@ -0,0 +1,96 @@
+// cmd/novakey/focus_guard.go
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// focusIdentity names the focused top-level window: its ID and the process
+// that owns it. See focusedIdentity in focused_target_*.go.
+type focusIdentity struct {
+	Window uint64
+	PID    uint32
+}
+
+// focusCheckInterval is how often a typing backend re-checks focus within a
+// chunk; it always checks just before each chunk.
+const focusCheckInterval = 50 * time.Millisecond
+
+// focusGuard lets typing backends make sure the window they type into is
+// still the one target policy looked at. Its baseline is the identity the
+// policy check returned; with target policy off it is looked up when the
+// guard is created. If the focused window couldn't be identified (Wayland),
+// verify never aborts.
+type focusGuard struct {
+	reqID     uint64
+	checkedAt time.Time
+	want      focusIdentity
+	known     bool
+	probe     func() (focusIdentity, error)
+
+	started   bool
+	lastCheck time.Time
+}
+
+// newFocusGuard guards checked, the window target policy allowed (nil when
+// target policy is off).
+func newFocusGuard(reqID uint64, checked *focusIdentity) *focusGuard {
+	return newFocusGuardWith(reqID, checked, focusedIdentity)
+}
+
+func newFocusGuardWith(reqID uint64, checked *focusIdentity, probe func() (focusIdentity, error)) *focusGuard {
+	g := &focusGuard{reqID: reqID, checkedAt: time.Now(), probe: probe}
+	if checked != nil {
+		g.want, g.known = *checked, true
+		return g
+	}
+	id, err := probe()
+	if err != nil {
+		logReqf(reqID, "focus guard off: can't identify focused window: %v", err)
+		return g
+	}
+	g.want, g.known = id, true
+	return g
+}
+
+// verify returns ErrFocusChanged if another window has focus now. Unless
+// force is set it only probes once per focusCheckInterval. The first call
+// marks the first keystroke and logs how long after the policy check it
+// came. A nil guard always passes.
+func (g *focusGuard) verify(force bool) error {
+	if g == nil {
+		return nil
+	}
+	now := time.Now()
+	if !g.started {
+		g.started = true
+		logReqf(g.reqID, "first keystroke %s after target policy check", now.Sub(g.checkedAt).Round(time.Microsecond))
+	}
+	if !g.known || (!force && now.Sub(g.lastCheck) < focusCheckInterval) {
+		return nil
+	}
+	g.lastCheck = now
+
+	id, err := g.probe()
+	if err != nil {
+		// Focus we can't read is focus we can't vouch for.
+		return fmt.Errorf("%w: can't identify focused window: %v", ErrFocusChanged, err)
+	}
+	if id != g.want {
+		return fmt.Errorf("%w: window 0x%x pid %d -> window 0x%x pid %d",
+			ErrFocusChanged, g.want.Window, g.want.PID, id.Window, id.PID)
+	}
+	return nil
+}
+
+// chunkText splits text into pieces of at most n characters.
+func chunkText(text string, n int) []string {
+	r := []rune(text)
+	var chunks []string
+	for len(r) > n {
+		chunks = append(chunks, string(r[:n]))
+		r = r[n:]
+	}
+	return append(chunks, string(r))
+}
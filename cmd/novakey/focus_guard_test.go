@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeFocus returns ids[i] on the i-th probe (the last one after that).
+func fakeFocus(ids ...focusIdentity) (probe func() (focusIdentity, error), calls *int) {
+	n := 0
+	return func() (focusIdentity, error) {
+		id := ids[min(n, len(ids)-1)]
+		n++
+		return id, nil
+	}, &n
+}
+
+func TestFocusGuard_AbortsOnChange(t *testing.T) {
+	a := focusIdentity{Window: 0x400001, PID: 100}
+	b := focusIdentity{Window: 0x600001, PID: 200}
+
+	probe, calls := fakeFocus(a, a, b)
+	g := newFocusGuardWith(1, nil, probe)
+	if err := g.verify(true); err != nil {
+		t.Fatalf("same window: %v", err)
+	}
+	// Within focusCheckInterval an unforced check doesn't probe.
+	if err := g.verify(false); err != nil || *calls != 2 {
+		t.Fatalf("unforced check: err=%v probes=%d", err, *calls)
+	}
+	err := g.verify(true)
+	if !errors.Is(err, ErrFocusChanged) || !strings.Contains(err.Error(), "pid 200") {
+		t.Fatalf("err = %v, want ErrFocusChanged", err)
+	}
+
+	// Same window, other process (window ID reused) also counts.
+	probe, _ = fakeFocus(a, focusIdentity{Window: a.Window, PID: 300})
+	if err := newFocusGuardWith(1, nil, probe).verify(true); !errors.Is(err, ErrFocusChanged) {
+		t.Fatalf("pid change: err = %v", err)
+	}
+
+	// A probe that stops working after the baseline aborts too.
+	n := 0
+	failing := func() (focusIdentity, error) {
+		if n++; n > 1 {
+			return focusIdentity{}, errors.New("display gone")
+		}
+		return a, nil
+	}
+	if err := newFocusGuardWith(1, nil, failing).verify(true); !errors.Is(err, ErrFocusChanged) {
+		t.Fatalf("probe error: err = %v", err)
+	}
+}
+
+func TestFocusGuard_InertWithoutBaseline(t *testing.T) {
+	n := 0
+	probe := func() (focusIdentity, error) {
+		n++
+		return focusIdentity{}, errors.New("wayland session")
+	}
+	g := newFocusGuardWith(1, nil, probe)
+	for range 3 {
+		if err := g.verify(true); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+	}
+	if n != 1 {
+		t.Fatalf("probed %d times after an unknown baseline", n)
+	}
+
+	var nilGuard *focusGuard
+	if err := nilGuard.verify(true); err != nil {
+		t.Fatalf("nil guard: %v", err)
+	}
+}
+
+func TestChunkText(t *testing.T) {
+	got := chunkText("abcdé", 2)
+	if strings.Join(got, "|") != "ab|cd|é" {
+		t.Fatalf("got %q", got)
+	}
+	if got := chunkText("", 16); len(got) != 1 || got[0] != "" {
+		t.Fatalf("empty: %q", got)
+	}
+}
+
+// Focus moves after target policy allowed window a but before the guard is
+// made: the guard must hold typing to a, not adopt b as its baseline.
+func TestFocusGuard_BaselineIsPolicyCheckedWindow(t *testing.T) {
+	a := focusIdentity{Window: 0x400001, PID: 100}
+	b := focusIdentity{Window: 0x600001, PID: 200}
+
+	probe, calls := fakeFocus(b)
+	g := newFocusGuardWith(1, &a, probe)
+	if *calls != 0 {
+		t.Fatalf("guard probed %d times for a baseline policy already checked", *calls)
+	}
+	if err := g.verify(true); !errors.Is(err, ErrFocusChanged) {
+		t.Fatalf("err = %v, want ErrFocusChanged", err)
+	}
+}
@@ -7,16 +7,22 @@ import (
 	"bytes"
 	"fmt"
 	"os/exec"
+	"strconv"
 	"strings"
 )
 
 // Uses osascript (System Events). This is "best effort" and may require Accessibility permissions.
-func getFocusedTarget() (string, string, error) {
-	// App name
-	appScript := `tell application "System Events" to get name of first application process whose frontmost is true`
-	app, err := runAppleScript(appScript)
+func getFocusedTarget() (focusedTarget, error) {
+	// App name and PID in one query, so the PID is the app the name describes.
+	appScript := `tell application "System Events" to tell (first application process whose frontmost is true) to return (unix id as text) & linefeed & name`
+	out, err := runAppleScript(appScript)
 	if err != nil {
-		return "", "", fmt.Errorf("osascript app name: %w", err)
+		return focusedTarget{}, fmt.Errorf("osascript frontmost app: %w", err)
+	}
+	pidStr, app, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	pid, err := strconv.ParseUint(strings.TrimSpace(pidStr), 10, 32)
+	if err != nil {
+		return focusedTarget{}, fmt.Errorf("frontmost pid %q: %w", strings.TrimSpace(pidStr), err)
 	}
 	app = strings.TrimSpace(app)
 
@@ -25,10 +31,25 @@ func getFocusedTarget() (string, string, error) {
 	title, _ := runAppleScript(titleScript)
 	title = strings.TrimSpace(title)
 
+	t := focusedTarget{Proc: app, Title: title, ID: focusIdentity{PID: uint32(pid)}}
 	if app == "" {
-		return "", title, fmt.Errorf("frontmost app name empty")
+		return t, fmt.Errorf("frontmost app name empty")
+	}
+	return t, nil
+}
+
+// focusedIdentity is the frontmost app's PID; macOS doesn't give us a
+// window ID without Accessibility APIs.
+func focusedIdentity() (focusIdentity, error) {
+	out, err := runAppleScript(`tell application "System Events" to get unix id of first application process whose frontmost is true`)
+	if err != nil {
+		return focusIdentity{}, fmt.Errorf("osascript frontmost pid: %w", err)
+	}
+	pid, err := strconv.ParseUint(strings.TrimSpace(out), 10, 32)
+	if err != nil {
+		return focusIdentity{}, fmt.Errorf("frontmost pid %q: %w", strings.TrimSpace(out), err)
 	}
-	return app, title, nil
+	return focusIdentity{PID: uint32(pid)}, nil
 }
 
 func runAppleScript(script string) (string, error) {
@@ -6,47 +6,91 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 )
 
-func getFocusedTarget() (string, string, error) {
+func getFocusedTarget() (focusedTarget, error) {
 	// Wayland: best effort is unreliable without compositor-specific protocols.
-	if os.Getenv("XDG_SESSION_TYPE") == "wayland" {
-		return "", "", fmt.Errorf("wayland session: focused app detection not implemented")
+	if waylandSession() {
+		return focusedTarget{}, fmt.Errorf("wayland session: focused app detection not implemented")
 	}
 
-	// X11 path using xdotool
-	winID, err := cmdOut("xdotool", "getwindowfocus")
-	if err != nil {
-		return "", "", fmt.Errorf("xdotool getwindowfocus: %w", err)
+	// X11: ask the server directly; xdotool if the display can't be reached.
+	t, err := x11FocusedTarget()
+	if err == nil {
+		return t, nil
 	}
-	winID = strings.TrimSpace(winID)
+	log.Printf("[linux] X11 focus lookup failed, trying xdotool: %v", err)
 
+	id, winID, err := xdotoolFocusedWindow()
+	if err != nil {
+		return focusedTarget{}, err
+	}
 	title, _ := cmdOut("xdotool", "getwindowname", winID)
-	title = strings.TrimSpace(title)
+	t = focusedTarget{Title: strings.TrimSpace(title), ID: id}
+	if id.PID == 0 {
+		return t, fmt.Errorf("xdotool getwindowpid: window %s has no pid", winID)
+	}
+	t.Proc, err = procName(fmt.Sprint(id.PID))
+	return t, err
+}
 
-	pidStr, err := cmdOut("xdotool", "getwindowpid", winID)
+// focusedIdentity is the focused X window and its _NET_WM_PID, read the same
+// way as getFocusedTarget.
+func focusedIdentity() (focusIdentity, error) {
+	if waylandSession() {
+		return focusIdentity{}, fmt.Errorf("wayland session: focused window not visible")
+	}
+	if x, err := dialX11(os.Getenv("DISPLAY")); err == nil {
+		defer x.Close()
+		w, err := x.focusedWindow()
+		if err != nil {
+			return focusIdentity{}, err
+		}
+		return focusIdentity{Window: uint64(w.ID), PID: w.PID}, nil
+	}
+	id, _, err := xdotoolFocusedWindow()
+	return id, err
+}
+
+// xdotoolFocusedWindow returns the focused window's identity and its ID as
+// xdotool prints it. Windows without a PID are identified by ID alone.
+func xdotoolFocusedWindow() (focusIdentity, string, error) {
+	out, err := cmdOut("xdotool", "getwindowfocus")
 	if err != nil {
-		return "", title, fmt.Errorf("xdotool getwindowpid: %w", err)
+		return focusIdentity{}, "", fmt.Errorf("xdotool getwindowfocus: %w", err)
 	}
-	pidStr = strings.TrimSpace(pidStr)
+	winID := strings.TrimSpace(out)
+	id, err := strconv.ParseUint(winID, 10, 64)
+	if err != nil {
+		return focusIdentity{}, "", fmt.Errorf("xdotool getwindowfocus: %q", winID)
+	}
+	pid := uint64(0)
+	if out, err := cmdOut("xdotool", "getwindowpid", winID); err == nil {
+		pid, _ = strconv.ParseUint(strings.TrimSpace(out), 10, 32)
+	}
+	return focusIdentity{Window: id, PID: uint32(pid)}, winID, nil
+}
 
+// procName returns a process's command name.
+func procName(pidStr string) (string, error) {
 	// process name via /proc
 	commPath := fmt.Sprintf("/proc/%s/comm", pidStr)
 	b, err := os.ReadFile(commPath)
 	if err == nil {
-		proc := strings.TrimSpace(string(b))
-		return proc, title, nil
+		return strings.TrimSpace(string(b)), nil
 	}
 
     // alternate: ps
 	proc, err := cmdOut("ps", "-p", pidStr, "-o", "comm=")
 	if err != nil {
-		return "", title, fmt.Errorf("ps comm: %w", err)
+		return "", fmt.Errorf("ps comm: %w", err)
 	}
-	return strings.TrimSpace(proc), title, nil
+	return strings.TrimSpace(proc), nil
 }
 
 func cmdOut(name string, args ...string) (string, error) {
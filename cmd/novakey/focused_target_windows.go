@@ -10,10 +10,10 @@ import (
 	"golang.org/x/sys/windows"
 )
 
-func getFocusedTarget() (string, string, error) {
+func getFocusedTarget() (focusedTarget, error) {
 	hwnd, _, _ := procGetForegroundWindow.Call()
 	if hwnd == 0 {
-		return "", "", fmt.Errorf("GetForegroundWindow returned NULL")
+		return focusedTarget{}, fmt.Errorf("GetForegroundWindow returned NULL")
 	}
 
 	// Window title
@@ -33,19 +33,19 @@ func getFocusedTarget() (string, string, error) {
 	var pid uint32
 	procGetWindowThreadProcessId.Call(hwnd, uintptr(unsafe.Pointer(&pid)))
 	if pid == 0 {
-		return "", title, fmt.Errorf("GetWindowThreadProcessId returned pid=0")
+		return focusedTarget{Title: title}, fmt.Errorf("GetWindowThreadProcessId returned pid=0")
 	}
 
 	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
 	if err != nil {
-		return "", title, fmt.Errorf("OpenProcess: %w", err)
+		return focusedTarget{Title: title}, fmt.Errorf("OpenProcess: %w", err)
 	}
 	defer windows.CloseHandle(h)
 
 	var size uint32 = 4096
 	buf := make([]uint16, size)
 	if err := windows.QueryFullProcessImageName(h, 0, &buf[0], &size); err != nil {
-		return "", title, fmt.Errorf("QueryFullProcessImageName: %w", err)
+		return focusedTarget{Title: title}, fmt.Errorf("QueryFullProcessImageName: %w", err)
 	}
 	full := windows.UTF16ToString(buf[:size])
 
@@ -60,5 +60,16 @@ func getFocusedTarget() (string, string, error) {
 	if exe == "" {
 		exe = full
 	}
-	return exe, title, nil
+	return focusedTarget{Proc: exe, Title: title, ID: focusIdentity{Window: uint64(hwnd), PID: pid}}, nil
+}
+
+// focusedIdentity is the foreground window and the process that owns it.
+func focusedIdentity() (focusIdentity, error) {
+	hwnd, _, _ := procGetForegroundWindow.Call()
+	if hwnd == 0 {
+		return focusIdentity{}, fmt.Errorf("GetForegroundWindow returned NULL")
+	}
+	var pid uint32
+	procGetWindowThreadProcessId.Call(hwnd, uintptr(unsafe.Pointer(&pid)))
+	return focusIdentity{Window: uint64(hwnd), PID: pid}, nil
 }
@@ -8,10 +8,10 @@ func boolDeref(ptr *bool, def bool) bool {
 	return *ptr
 }
 
-func allowClipboardWhenBlocked() bool {
+func allowClipboardWhenBlocked(cfg *ServerConfig) bool {
 	return boolDeref(cfg.AllowClipboardWhenDisarmed, false)
 }
 
-func allowClipboardOnInjectFailure() bool {
+func allowClipboardOnInjectFailure(cfg *ServerConfig) bool {
 	return boolDeref(cfg.AllowClipboardOnInjectFailure, false)
 }
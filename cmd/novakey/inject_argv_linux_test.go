@@ -0,0 +1,92 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeHelpers puts shell scripts named like the injection helpers first in
+// PATH. Each logs its argv to one file and its stdin to another.
+func fakeHelpers(t *testing.T) (argvLog, stdinLog string) {
+	t.Helper()
+	dir := t.TempDir()
+	argvLog = filepath.Join(dir, "argv.log")
+	stdinLog = filepath.Join(dir, "stdin.log")
+	script := "#!/bin/sh\n" +
+		"printf '%s %s\\n' \"$(basename \"$0\")\" \"$*\" >> " + argvLog + "\n" +
+		"cat >> " + stdinLog + "\n"
+	for _, name := range []string{"wl-copy", "xclip", "xdotool", "wtype", "ydotool"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argvLog, stdinLog
+}
+
+func TestHelpers_NeverGetSecretInArgv(t *testing.T) {
+	const secret = "s3cret-Pa55 with spaces"
+	cfg := &ServerConfig{}
+
+	sessions := []struct {
+		name    string
+		wayland bool
+		calls   []func() error
+		helpers []string
+	}{
+		{"x11", false, []func() error{
+			func() error { return trySetClipboard(secret) },
+			func() error { _, err := injectViaXdotool(cfg, secret, nil); return err },
+			func() error { _, err := pasteViaClipboard(cfg, secret, nil); return err },
+		}, []string{"xclip", "xdotool"}},
+		{"wayland", true, []func() error{
+			func() error { return trySetClipboard(secret) },
+			func() error { _, err := injectViaWtype(cfg, secret, nil); return err },
+			func() error { _, err := injectViaYdotool(cfg, secret, nil); return err },
+			func() error { _, err := pasteViaClipboard(cfg, secret, nil); return err },
+		}, []string{"wl-copy", "wtype", "ydotool"}},
+	}
+
+	for _, s := range sessions {
+		t.Run(s.name, func(t *testing.T) {
+			argvLog, stdinLog := fakeHelpers(t)
+			if s.wayland {
+				t.Setenv("XDG_SESSION_TYPE", "wayland")
+				t.Setenv("WAYLAND_DISPLAY", "wayland-0")
+			} else {
+				t.Setenv("XDG_SESSION_TYPE", "x11")
+				t.Setenv("WAYLAND_DISPLAY", "")
+			}
+
+			for i, call := range s.calls {
+				if err := call(); err != nil {
+					t.Fatalf("call %d: %v", i, err)
+				}
+			}
+
+			argv, err := os.ReadFile(argvLog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, h := range s.helpers {
+				if !strings.Contains(string(argv), h+" ") {
+					t.Errorf("helper %s was never run; argv log:\n%s", h, argv)
+				}
+			}
+			if strings.Contains(string(argv), "s3cret") {
+				t.Fatalf("secret passed in argv:\n%s", argv)
+			}
+			stdin, err := os.ReadFile(stdinLog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := strings.Count(string(stdin), secret); n != len(s.calls) {
+				t.Fatalf("secret reached %d helpers on stdin, want %d", n, len(s.calls))
+			}
+		})
+	}
+}
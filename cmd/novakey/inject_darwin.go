@@ -8,51 +8,54 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"strings"
 )
 
-// macOS injection:
-// - Default (per keylogger concern): clipboard paste (pbcopy + Cmd+V), then OPTIONAL AppleScript typing fallback.
+// macOS injection backends (see injector.go):
+// - clipboard_paste: pbcopy + Cmd+V, restoring the old clipboard. Default first (per keylogger concern).
+// - applescript: System Events keystroke typing; needs allow_typing_fallback.
+// - macos_prefer_clipboard=false swaps the default order.
 // - We return which method was used so the client can show a clear visual cue.
-func InjectPasswordToFocusedControl(password string) (InjectMethod, error) {
-	log.Printf("[darwin] InjectPasswordToFocusedControl called; len=%d", len(password))
-
-	preferClipboard := boolDeref(cfg.MacOSPreferClipboard, true)
-	allowTyping := boolDeref(cfg.AllowTypingFallback, true)
-
-	if preferClipboard {
-		if err := injectViaClipboardPaste(password); err == nil {
-			log.Printf("[darwin] clipboard+Cmd+V path succeeded")
-			return InjectMethodClipboard, nil
-		} else {
-			log.Printf("[darwin] clipboard-paste failed: %v", err)
-		}
-
-		if allowTyping {
-			if err := injectViaAppleScriptType(password); err != nil {
-				return "", fmt.Errorf("clipboard paste failed and typing fallback failed: %w", err)
-			}
-			log.Printf("[darwin] AppleScript keystroke typing succeeded (fallback)")
-			return InjectMethodTyping, nil
-		}
-
-		return "", fmt.Errorf("clipboard paste failed and typing fallback disabled")
-	}
-
-	// If user flips preference, try typing first.
-	if allowTyping {
-		if err := injectViaAppleScriptType(password); err == nil {
-			log.Printf("[darwin] AppleScript keystroke typing succeeded")
-			return InjectMethodTyping, nil
-		} else {
-			log.Printf("[darwin] AppleScript typing failed: %v", err)
-		}
+func platformInjectors() []Injector {
+	return []Injector{
+		injectorFuncs{
+			name: "clipboard_paste",
+			inject: func(cfg *ServerConfig, password string, focus *focusGuard) (InjectMethod, error) {
+				if err := focus.verify(true); err != nil {
+					return "", err
+				}
+				if err := injectViaClipboardPaste(password); err != nil {
+					return "", err
+				}
+				return InjectMethodClipboard, nil
+			},
+		},
+		injectorFuncs{
+			name: "applescript",
+			available: func(cfg *ServerConfig) error {
+				if !boolDeref(cfg.AllowTypingFallback, true) {
+					return fmt.Errorf("allow_typing_fallback=false")
+				}
+				return nil
+			},
+			inject: func(cfg *ServerConfig, password string, focus *focusGuard) (InjectMethod, error) {
+				if err := focus.verify(true); err != nil {
+					return "", err
+				}
+				if err := injectViaAppleScriptType(password); err != nil {
+					return "", err
+				}
+				return InjectMethodTyping, nil
+			},
+		},
 	}
+}
 
-	if err := injectViaClipboardPaste(password); err != nil {
-		return "", fmt.Errorf("typing failed/disabled and clipboard paste failed: %w", err)
+func defaultInjectBackends(cfg *ServerConfig) []string {
+	if boolDeref(cfg.MacOSPreferClipboard, true) {
+		return []string{"clipboard_paste", "applescript"}
 	}
-	log.Printf("[darwin] clipboard+Cmd+V path succeeded (fallback)")
-	return InjectMethodClipboard, nil
+	return []string{"applescript", "clipboard_paste"}
 }
 
 func injectViaClipboardPaste(password string) error {
@@ -97,15 +100,11 @@ func restoreClipboardMac(old []byte) {
 }
 
 func injectViaAppleScriptType(password string) error {
-	script := `
-on run argv
-    set t to item 1 of argv
-    tell application "System Events"
-        keystroke t
-    end tell
-end run
-`
-	cmd := exec.Command("osascript", "-e", script, "--", password)
+	// The script, secret included, goes to osascript on stdin so the secret
+	// never shows in argv (ps, sysctl kern.procargs).
+	script := `tell application "System Events" to keystroke "` + appleScriptQuote(password) + `"`
+	cmd := exec.Command("osascript", "-")
+	cmd.Stdin = strings.NewReader(script)
 	out, err := cmd.CombinedOutput()
 	if len(out) > 0 {
 		log.Printf("[darwin] osascript type output: %s", string(out))
@@ -116,3 +115,7 @@ end run
 	return nil
 }
 
+// appleScriptQuote escapes s for use inside an AppleScript string literal.
+func appleScriptQuote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
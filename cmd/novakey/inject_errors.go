@@ -5,3 +5,12 @@ import "errors"
 // Sentinel error used by msg_handler.go to detect "can't inject; clipboard-only is acceptable" cases.
 // Defined in a common file so all targets compile.
 var ErrInjectUnavailableWayland = errors.New("inject unavailable on wayland")
+
+// ErrFocusChanged means the focused window changed while a secret was being
+// typed; the rest was not typed (see focus_guard.go).
+var ErrFocusChanged = errors.New("focused window changed during injection")
+
+// ErrPartiallyTyped means a backend failed after some of the secret reached
+// the focused window. No other backend or clipboard fallback may run: the
+// field would end up with part of the secret followed by all of it.
+var ErrPartiallyTyped = errors.New("injection failed after typing started")
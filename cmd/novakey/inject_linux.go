@@ -4,50 +4,198 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
+
+	"golang.org/x/sys/unix"
 )
 
-// Linux injection:
+// Linux injection backends (see injector.go):
+//
+//   - x11: XTEST typing over a direct X connection (x11_inject_linux.go). Default, first.
+//   - xdotool: X11/Xwayland typing through the xdotool helper. Default, second.
+//   - uinput: virtual keyboard, works on Wayland (linux_uinput_enabled). Default, third.
+//   - wtype, ydotool: Wayland typing helpers; opt-in through inject_backends.
+//   - clipboard_paste: set the clipboard, then press Ctrl+V; opt-in.
 //
-// - Wayland: do NOT attempt keystroke injection; return ErrInjectUnavailableWayland.
-// - X11/Xwayland: type via xdotool.
-// - IMPORTANT: do NOT set clipboard unless the user enabled clipboard fallback AND injection failed;
-//   that logic lives in msg_handler.go via allowClipboardOnInjectFailure().
-func InjectPasswordToFocusedControl(password string) (InjectMethod, error) {
-	display := os.Getenv("DISPLAY")
-	session := strings.ToLower(strings.TrimSpace(os.Getenv("XDG_SESSION_TYPE")))
+// A backend that can't work because the session is Wayland says so with
+// ErrInjectUnavailableWayland, which msg_handler.go reports as
+// inject_unavailable_wayland. IMPORTANT: do NOT set the clipboard outside
+// clipboard_paste unless the user enabled clipboard fallback AND injection
+// failed; that logic lives in msg_handler.go via allowClipboardOnInjectFailure().
 
-	log.Printf("[linux] InjectPasswordToFocusedControl called; len=%d DISPLAY=%s XDG_SESSION_TYPE=%s",
-		len(password), display, session)
+func platformInjectors() []Injector {
+	return []Injector{
+		injectorFuncs{name: "x11", available: x11Available, inject: injectViaX11},
+		injectorFuncs{name: "xdotool", available: xdotoolAvailable, inject: injectViaXdotool},
+		injectorFuncs{name: "uinput", available: uinputAvailable, inject: injectViaUinputBackend},
+		injectorFuncs{name: "wtype", available: wtypeAvailable, inject: injectViaWtype},
+		injectorFuncs{name: "ydotool", available: ydotoolAvailable, inject: injectViaYdotool},
+		injectorFuncs{name: "clipboard_paste", available: clipboardPasteAvailable, inject: pasteViaClipboard},
+	}
+}
 
-	// Wayland path
-	if session == "wayland" || os.Getenv("WAYLAND_DISPLAY") != "" {
-		log.Printf("[linux] Wayland session detected; keystroke injection not supported")
-		return "", ErrInjectUnavailableWayland
+func defaultInjectBackends(cfg *ServerConfig) []string {
+	return []string{"x11", "xdotool", "uinput"}
+}
+
+func needHelper(name string) error {
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("%s not found in PATH", name)
 	}
+	return nil
+}
+
+// ---- xdotool ----
 
-	// X11 / Xwayland typing via xdotool
-	if err := injectViaXdotoolType(password); err != nil {
-		return "", fmt.Errorf("xdotool typing failed: %w", err)
+func xdotoolAvailable(cfg *ServerConfig) error {
+	if waylandSession() {
+		return fmt.Errorf("%w: xdotool can't type into Wayland windows", ErrInjectUnavailableWayland)
 	}
+	if os.Getenv("DISPLAY") == "" {
+		return fmt.Errorf("DISPLAY not set")
+	}
+	return needHelper("xdotool")
+}
 
+// xdotoolChunk is how many characters go to one xdotool run; focus is
+// checked before each.
+const xdotoolChunk = 16
+
+func injectViaXdotool(cfg *ServerConfig, password string, focus *focusGuard) (InjectMethod, error) {
+	for i, chunk := range chunkText(password, xdotoolChunk) {
+		if err := focus.verify(true); err != nil {
+			return "", err
+		}
+		// "--file -" reads the text from stdin; in argv it would show in ps and
+		// /proc/<pid>/cmdline while typing.
+		if err := runWithStdin(chunk, "xdotool", "type", "--clearmodifiers", "--delay", "1", "--file", "-"); err != nil {
+			err = fmt.Errorf("xdotool typing failed: %w", err)
+			if i > 0 {
+				err = partiallyTyped(err)
+			}
+			return "", err
+		}
+	}
 	return InjectMethodTyping, nil
 }
 
-func injectViaXdotoolType(password string) error {
-	cmd := exec.Command("xdotool", "type", "--clearmodifiers", "--delay", "1", "--", password)
-	cmd.Env = os.Environ()
-	out, err := cmd.CombinedOutput()
-	if len(out) > 0 {
-		log.Printf("[linux] xdotool type output: %s", string(out))
+// ---- uinput (uinput_linux.go) ----
+
+// uinput is the only default backend that works under Wayland, so its
+// failures there are reported as ErrInjectUnavailableWayland.
+func uinputAvailable(cfg *ServerConfig) error {
+	if !cfg.LinuxUinputEnabled {
+		return onWayland(fmt.Errorf("linux_uinput_enabled=false"))
 	}
-	if err != nil {
-		return fmt.Errorf("xdotool type failed: %w", err)
+	if err := unix.Access(uinputPath, unix.W_OK); err != nil {
+		return onWayland(fmt.Errorf("no write access to %s: %v", uinputPath, err))
 	}
 	return nil
 }
 
+func injectViaUinputBackend(cfg *ServerConfig, password string, focus *focusGuard) (InjectMethod, error) {
+	if err := injectViaUinput(cfg, password, focus); err != nil {
+		return "", onWayland(err)
+	}
+	return InjectMethodUinput, nil
+}
+
+func onWayland(err error) error {
+	if waylandSession() && !errors.Is(err, ErrFocusChanged) && !errors.Is(err, ErrPartiallyTyped) {
+		return fmt.Errorf("%w: %v", ErrInjectUnavailableWayland, err)
+	}
+	return err
+}
+
+// ---- wtype / ydotool (Wayland helpers; text goes in on stdin) ----
+
+func wtypeAvailable(cfg *ServerConfig) error {
+	if !waylandSession() {
+		return fmt.Errorf("not a Wayland session")
+	}
+	return needHelper("wtype")
+}
+
+func injectViaWtype(cfg *ServerConfig, password string, focus *focusGuard) (InjectMethod, error) {
+	if err := focus.verify(true); err != nil {
+		return "", err
+	}
+	// "wtype -" reads the text from stdin.
+	if err := runWithStdin(password, "wtype", "-"); err != nil {
+		return "", fmt.Errorf("wtype failed: %w", err)
+	}
+	return InjectMethodTyping, nil
+}
+
+// ydotool talks to ydotoold, which must already be running with uinput access.
+func ydotoolAvailable(cfg *ServerConfig) error {
+	return needHelper("ydotool")
+}
+
+func injectViaYdotool(cfg *ServerConfig, password string, focus *focusGuard) (InjectMethod, error) {
+	if err := focus.verify(true); err != nil {
+		return "", err
+	}
+	if err := runWithStdin(password, "ydotool", "type", "--file", "-"); err != nil {
+		return "", fmt.Errorf("ydotool type failed: %w", err)
+	}
+	return InjectMethodTyping, nil
+}
+
+// ---- clipboard_paste ----
+
+// clipboardPasteAvailable needs a clipboard helper (see trySetClipboard) and
+// something to press Ctrl+V with: xdotool on X11, wtype on Wayland.
+func clipboardPasteAvailable(cfg *ServerConfig) error {
+	if waylandSession() {
+		if err := needHelper("wl-copy"); err != nil {
+			return err
+		}
+		return needHelper("wtype")
+	}
+	if err := needHelper("xclip"); err != nil {
+		return err
+	}
+	return needHelper("xdotool")
+}
+
+// pasteViaClipboard leaves the secret on the clipboard, as the
+// clipboard fallback does.
+func pasteViaClipboard(cfg *ServerConfig, password string, focus *focusGuard) (InjectMethod, error) {
+	if err := focus.verify(true); err != nil {
+		return "", err
+	}
+	if err := trySetClipboard(password); err != nil {
+		return "", err
+	}
+	var paste *exec.Cmd
+	if waylandSession() {
+		paste = exec.Command("wtype", "-M", "ctrl", "v", "-m", "ctrl")
+	} else {
+		paste = exec.Command("xdotool", "key", "--clearmodifiers", "ctrl+v")
+	}
+	if out, err := paste.CombinedOutput(); err != nil {
+		if len(out) > 0 {
+			log.Printf("[linux] paste output: %s", string(out))
+		}
+		return "", fmt.Errorf("ctrl+v failed: %w", err)
+	}
+	return InjectMethodClipboard, nil
+}
+
+// runWithStdin runs a helper with text on stdin. Secrets never go in a
+// helper's argv (see inject_argv_linux_test.go).
+func runWithStdin(text, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(text)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		log.Printf("[linux] %s output: %s", name, string(out))
+	}
+	return err
+}
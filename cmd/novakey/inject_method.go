@@ -7,5 +7,8 @@ const (
 	InjectMethodDirect   InjectMethod = "direct"
 	InjectMethodTyping   InjectMethod = "typing"
 	InjectMethodClipboard InjectMethod = "clipboard"
+
+	// Typed through a /dev/uinput virtual keyboard (Linux, incl. Wayland).
+	InjectMethodUinput InjectMethod = "uinput"
 )
 
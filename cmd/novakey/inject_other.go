@@ -3,9 +3,7 @@
 
 package main
 
-import "fmt"
-
-func InjectPasswordToFocusedControl(password string) (InjectMethod, error) {
-	return "", fmt.Errorf("injection not supported on this OS")
-}
+// No injection backends on this OS; injectWithBackends reports that.
+func platformInjectors() []Injector { return nil }
 
+func defaultInjectBackends(cfg *ServerConfig) []string { return nil }
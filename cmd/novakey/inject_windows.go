@@ -60,17 +60,43 @@ func getWindowClass(hwnd windows.Handle) (string, error) {
 	return syscall.UTF16ToString(buf[:r1]), nil
 }
 
-// Windows injection:
+// Windows injection backends (see injector.go):
 //
-//  1) Find focused control
-//  2) Try direct messages (EM_REPLACESEL / WM_SETTEXT) for known-safe Edit/RichEdit controls
-//  3) If direct fails and allow_typing_fallback=true, use keybd_event typing
+//  1. win_message: direct messages (EM_REPLACESEL / WM_SETTEXT) to the focused control,
+//     only for known-safe Edit/RichEdit controls
+//  2. win_keybd: keybd_event typing, only if allow_typing_fallback=true
 //
 // IMPORTANT: We do NOT touch clipboard here. Clipboard fallback (if enabled) is handled in msg_handler.go
 // only after injection failure.
-func InjectPasswordToFocusedControl(password string) (InjectMethod, error) {
-	log.Printf("[windows] InjectPasswordToFocusedControl called; len=%d", len(password))
+func platformInjectors() []Injector {
+	return []Injector{
+		injectorFuncs{name: "win_message", inject: injectViaFocusedControl},
+		injectorFuncs{
+			name: "win_keybd",
+			available: func(cfg *ServerConfig) error {
+				if !boolDeref(cfg.AllowTypingFallback, true) {
+					return fmt.Errorf("allow_typing_fallback=false")
+				}
+				return nil
+			},
+			inject: func(cfg *ServerConfig, password string, focus *focusGuard) (InjectMethod, error) {
+				if err := injectViaKeybdEvent(password, focus); err != nil {
+					return "", fmt.Errorf("keybd_event typing failed: %w", err)
+				}
+				return InjectMethodTyping, nil
+			},
+		},
+	}
+}
 
+func defaultInjectBackends(cfg *ServerConfig) []string {
+	return []string{"win_message", "win_keybd"}
+}
+
+func injectViaFocusedControl(cfg *ServerConfig, password string, focus *focusGuard) (InjectMethod, error) {
+	if err := focus.verify(true); err != nil {
+		return "", err
+	}
 	hwnd, err := getFocusedControl()
 	if err != nil {
 		return "", fmt.Errorf("getFocusedControl: %w", err)
@@ -87,39 +113,24 @@ func InjectPasswordToFocusedControl(password string) (InjectMethod, error) {
 	log.Printf("[windows] focused HWND=0x%X class=%q", uintptr(hwnd), className)
 
 	// Only use direct messages on known-safe text controls
-	safeDirect := className == "Edit" || className == "RichEdit20W" || className == "RichEdit20A"
-
-	if safeDirect {
-		beforeLen := getTextLength(hwnd)
-		log.Printf("[windows] initial text length=%d", beforeLen)
-
-		if err := injectViaMessages(hwnd, password); err == nil {
-			afterLen := getTextLength(hwnd)
-			log.Printf("[windows] post-message text length=%d", afterLen)
-
-			if beforeLen >= 0 && afterLen >= 0 && afterLen != beforeLen {
-				log.Printf("[windows] direct message injection succeeded (len %d -> %d)", beforeLen, afterLen)
-				return InjectMethodDirect, nil
-			}
-			log.Printf("[windows] direct message injection uncertain/no change (len %d -> %d), will consider typing fallback", beforeLen, afterLen)
-		} else {
-			log.Printf("[windows] direct message injection failed: %v", err)
-		}
-	} else {
-		log.Printf("[windows] control class %q not in safe list; skipping direct injection", className)
+	if className != "Edit" && className != "RichEdit20W" && className != "RichEdit20A" {
+		return "", fmt.Errorf("control class %q not in safe list", className)
 	}
 
-	// Optional typing fallback
-	if !boolDeref(cfg.AllowTypingFallback, true) {
-		return "", fmt.Errorf("direct injection failed and typing fallback disabled")
+	beforeLen := getTextLength(hwnd)
+	log.Printf("[windows] initial text length=%d", beforeLen)
+
+	if err := injectViaMessages(hwnd, password); err != nil {
+		return "", fmt.Errorf("direct message injection failed: %w", err)
 	}
+	afterLen := getTextLength(hwnd)
+	log.Printf("[windows] post-message text length=%d", afterLen)
 
-	if err := injectViaKeybdEvent(password); err != nil {
-		log.Printf("[windows] keybd_event typing failed: %v", err)
-		return "", fmt.Errorf("keybd_event typing failed: %w", err)
+	if beforeLen >= 0 && afterLen >= 0 && afterLen != beforeLen {
+		log.Printf("[windows] direct message injection succeeded (len %d -> %d)", beforeLen, afterLen)
+		return InjectMethodDirect, nil
 	}
-	log.Printf("[windows] keybd_event typing path succeeded (fallback)")
-	return InjectMethodTyping, nil
+	return "", fmt.Errorf("direct message injection uncertain/no change (len %d -> %d)", beforeLen, afterLen)
 }
 
 func injectViaMessages(hwnd windows.Handle, password string) error {
@@ -167,9 +178,22 @@ func getTextLength(hwnd windows.Handle) int {
 	return int(int32(r1))
 }
 
-func injectViaKeybdEvent(password string) error {
+// keybdChunk is how often injectViaKeybdEvent re-checks focus
+// unconditionally.
+const keybdChunk = 16
+
+func injectViaKeybdEvent(password string, focus *focusGuard) (err error) {
 	log.Printf("[windows] injectViaKeybdEvent start, len=%d", len(password))
-	for _, r := range password {
+	typed := false
+	defer func() {
+		if err != nil && typed {
+			err = partiallyTyped(err)
+		}
+	}()
+	for i, r := range []rune(password) {
+		if err := focus.verify(i%keybdChunk == 0); err != nil {
+			return err
+		}
 		if r > 0x7f {
 			return fmt.Errorf("keybd_event alternate path does not support non-ASCII char %q", r)
 		}
@@ -186,6 +210,7 @@ func injectViaKeybdEvent(password string) error {
 
 		keyEvent(vk, true)
 		keyEvent(vk, false)
+		typed = true
 
 		if shiftNeeded {
 			keyEvent(VK_SHIFT, false)
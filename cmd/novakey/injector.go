@@ -0,0 +1,152 @@
+// cmd/novakey/injector.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Injector is one way of getting a secret into the focused control. Each OS
+// registers its backends in platformInjectors; inject_backends picks the
+// order they are tried in.
+type Injector interface {
+	Name() string
+	// Available reports why the backend can't be used right now (helper
+	// not installed, wrong session type, disabled by config), or nil.
+	Available(cfg *ServerConfig) error
+	// Inject types or pastes text. Typing backends call focus.verify before
+	// and while typing and stop with ErrFocusChanged. A failure after some
+	// input was sent wraps ErrPartiallyTyped.
+	Inject(cfg *ServerConfig, text string, focus *focusGuard) (InjectMethod, error)
+}
+
+// injectorFuncs adapts a set of functions to Injector.
+type injectorFuncs struct {
+	name      string
+	available func(cfg *ServerConfig) error
+	inject    func(cfg *ServerConfig, text string, focus *focusGuard) (InjectMethod, error)
+}
+
+func (f injectorFuncs) Name() string { return f.name }
+
+func (f injectorFuncs) Available(cfg *ServerConfig) error {
+	if f.available == nil {
+		return nil
+	}
+	return f.available(cfg)
+}
+
+func (f injectorFuncs) Inject(cfg *ServerConfig, text string, focus *focusGuard) (InjectMethod, error) {
+	return f.inject(cfg, text, focus)
+}
+
+// knownInjectBackends lists backend names on any OS, so one config file can
+// be shared between machines; names for another OS are skipped.
+var knownInjectBackends = []string{
+	"x11", "xdotool", "uinput", "wtype", "ydotool", "clipboard_paste", // linux (clipboard_paste also darwin)
+	"applescript",              // darwin
+	"win_message", "win_keybd", // windows
+}
+
+// injectors returns this OS's backends; tests replace it.
+var injectors = platformInjectors
+
+// waylandSession reports whether the daemon runs in a Wayland session. Focus
+// lookup, target policy and the backends all use this one test, so they
+// agree on when the focused window can't be identified.
+func waylandSession() bool {
+	session := strings.ToLower(strings.TrimSpace(os.Getenv("XDG_SESSION_TYPE")))
+	return session == "wayland" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// injectResult says how a secret was injected.
+type injectResult struct {
+	backend string
+	method  InjectMethod
+}
+
+// injectChain returns the backends to try, in order: inject_backends if set,
+// else the platform default.
+func injectChain(cfg *ServerConfig) []Injector {
+	all := injectors()
+	names := cfg.InjectBackends
+	if len(names) == 0 {
+		names = defaultInjectBackends(cfg)
+	}
+	var chain []Injector
+	for _, n := range names {
+		for _, inj := range all {
+			if inj.Name() == n {
+				chain = append(chain, inj)
+			}
+		}
+	}
+	return chain
+}
+
+// partiallyTyped marks err as happening after input was sent.
+func partiallyTyped(err error) error {
+	return fmt.Errorf("%w: %w", ErrPartiallyTyped, err)
+}
+
+// injectWithBackends walks the chain and returns the first backend that
+// succeeds. If none does, the error joins every backend's reason, so callers
+// can still test for sentinels like ErrInjectUnavailableWayland. A focus
+// change ends the walk: the next backend would type into the wrong window.
+// So does a failure after typing started (ErrPartiallyTyped): the next
+// backend would type the whole secret after the part already sent.
+func injectWithBackends(cfg *ServerConfig, text string, focus *focusGuard) (injectResult, error) {
+	chain := injectChain(cfg)
+	if len(chain) == 0 {
+		return injectResult{}, fmt.Errorf("no injection backend configured for this OS")
+	}
+
+	var errs []error
+	for _, inj := range chain {
+		if err := inj.Available(cfg); err != nil {
+			log.Printf("[inject] backend %s unavailable: %v", inj.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", inj.Name(), err))
+			continue
+		}
+		method, err := inj.Inject(cfg, text, focus)
+		if err != nil {
+			log.Printf("[inject] backend %s failed: %v", inj.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", inj.Name(), err))
+			if errors.Is(err, ErrFocusChanged) || errors.Is(err, ErrPartiallyTyped) {
+				break
+			}
+			continue
+		}
+		return injectResult{backend: inj.Name(), method: method}, nil
+	}
+	return injectResult{}, errors.Join(errs...)
+}
+
+// checkInjectBackends validates inject_backends. It returns an error for an
+// unknown or repeated name, and the names this OS doesn't provide.
+func checkInjectBackends(names []string) (missing []string, err error) {
+	seen := map[string]bool{}
+	for _, n := range names {
+		if !slices.Contains(knownInjectBackends, n) {
+			return nil, fmt.Errorf("unknown backend %q (known: %v)", n, knownInjectBackends)
+		}
+		if seen[n] {
+			return nil, fmt.Errorf("backend %q listed twice", n)
+		}
+		seen[n] = true
+	}
+	var here []string
+	for _, inj := range injectors() {
+		here = append(here, inj.Name())
+	}
+	for _, n := range names {
+		if !slices.Contains(here, n) {
+			missing = append(missing, n)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func fakeInjectors(t *testing.T, calls *[]string, list ...injectorFuncs) {
+	t.Helper()
+	old := injectors
+	t.Cleanup(func() { injectors = old })
+	injectors = func() []Injector {
+		out := make([]Injector, len(list))
+		for i, f := range list {
+			inject := f.inject
+			name := f.name
+			f.inject = func(cfg *ServerConfig, text string, focus *focusGuard) (InjectMethod, error) {
+				*calls = append(*calls, name)
+				return inject(cfg, text, focus)
+			}
+			out[i] = f
+		}
+		return out
+	}
+}
+
+func TestInjectWithBackends_WalksChainInOrder(t *testing.T) {
+	var calls []string
+	fakeInjectors(t, &calls,
+		injectorFuncs{name: "xdotool",
+			available: func(*ServerConfig) error { return fmt.Errorf("%w: no X", ErrInjectUnavailableWayland) },
+			inject:    func(*ServerConfig, string, *focusGuard) (InjectMethod, error) { return InjectMethodTyping, nil }},
+		injectorFuncs{name: "wtype",
+			inject: func(*ServerConfig, string, *focusGuard) (InjectMethod, error) { return "", errors.New("exit 1") }},
+		injectorFuncs{name: "uinput",
+			inject: func(*ServerConfig, string, *focusGuard) (InjectMethod, error) { return InjectMethodUinput, nil }},
+	)
+
+	cfg := &ServerConfig{InjectBackends: []string{"xdotool", "wtype", "uinput"}}
+	res, err := injectWithBackends(cfg, "pw", nil)
+	if err != nil {
+		t.Fatalf("injectWithBackends: %v", err)
+	}
+	if res.backend != "uinput" || res.method != InjectMethodUinput {
+		t.Fatalf("got %+v, want uinput", res)
+	}
+	if strings.Join(calls, ",") != "wtype,uinput" {
+		t.Fatalf("Inject called on %v; unavailable backends must be skipped", calls)
+	}
+
+	// Config order wins over registration order.
+	calls = nil
+	cfg.InjectBackends = []string{"uinput", "wtype"}
+	if res, _ := injectWithBackends(cfg, "pw", nil); res.backend != "uinput" || len(calls) != 1 {
+		t.Fatalf("got %+v after %v, want uinput first", res, calls)
+	}
+}
+
+func TestInjectWithBackends_AllFailKeepsSentinel(t *testing.T) {
+	var calls []string
+	fakeInjectors(t, &calls,
+		injectorFuncs{name: "xdotool",
+			available: func(*ServerConfig) error { return fmt.Errorf("%w: no X", ErrInjectUnavailableWayland) }},
+		injectorFuncs{name: "wtype",
+			inject: func(*ServerConfig, string, *focusGuard) (InjectMethod, error) { return "", errors.New("exit 1") }},
+	)
+
+	_, err := injectWithBackends(&ServerConfig{InjectBackends: []string{"xdotool", "wtype"}}, "pw", nil)
+	if !errors.Is(err, ErrInjectUnavailableWayland) {
+		t.Fatalf("err = %v, want ErrInjectUnavailableWayland in the chain", err)
+	}
+	for _, want := range []string{"xdotool:", "wtype: exit 1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err %q does not mention %q", err, want)
+		}
+	}
+
+	if _, err := injectWithBackends(&ServerConfig{InjectBackends: []string{"applescript"}}, "pw", nil); err == nil {
+		t.Fatal("expected an error when no configured backend exists here")
+	}
+}
+
+func TestInjectWithBackends_FocusChangeStopsChain(t *testing.T) {
+	var calls []string
+	fakeInjectors(t, &calls,
+		injectorFuncs{name: "x11",
+			inject: func(*ServerConfig, string, *focusGuard) (InjectMethod, error) {
+				return "", fmt.Errorf("%w: window 0x1 -> 0x2", ErrFocusChanged)
+			}},
+		injectorFuncs{name: "xdotool",
+			inject: func(*ServerConfig, string, *focusGuard) (InjectMethod, error) { return InjectMethodTyping, nil }},
+	)
+
+	_, err := injectWithBackends(&ServerConfig{InjectBackends: []string{"x11", "xdotool"}}, "pw", nil)
+	if !errors.Is(err, ErrFocusChanged) {
+		t.Fatalf("err = %v, want ErrFocusChanged", err)
+	}
+	if strings.Join(calls, ",") != "x11" {
+		t.Fatalf("backends tried: %v; nothing may type after a focus change", calls)
+	}
+}
+
+func TestInjectWithBackends_FailureAfterTypingStopsChain(t *testing.T) {
+	var calls []string
+	fakeInjectors(t, &calls,
+		injectorFuncs{name: "x11",
+			inject: func(*ServerConfig, string, *focusGuard) (InjectMethod, error) {
+				return "", partiallyTyped(errors.New("sync: broken pipe"))
+			}},
+		injectorFuncs{name: "xdotool",
+			inject: func(*ServerConfig, string, *focusGuard) (InjectMethod, error) { return InjectMethodTyping, nil }},
+	)
+
+	_, err := injectWithBackends(&ServerConfig{InjectBackends: []string{"x11", "xdotool"}}, "pw", nil)
+	if !errors.Is(err, ErrPartiallyTyped) {
+		t.Fatalf("err = %v, want ErrPartiallyTyped", err)
+	}
+	if strings.Join(calls, ",") != "x11" {
+		t.Fatalf("backends tried: %v; nothing may type after a partial failure", calls)
+	}
+}
+
+func TestCheckInjectBackends(t *testing.T) {
+	var calls []string
+	fakeInjectors(t, &calls, injectorFuncs{name: "xdotool"})
+
+	if _, err := checkInjectBackends([]string{"xdotool", "typo"}); err == nil {
+		t.Fatal("unknown backend accepted")
+	}
+	if _, err := checkInjectBackends([]string{"xdotool", "xdotool"}); err == nil {
+		t.Fatal("duplicate backend accepted")
+	}
+	missing, err := checkInjectBackends([]string{"xdotool", "win_keybd"})
+	if err != nil || len(missing) != 1 || missing[0] != "win_keybd" {
+		t.Fatalf("missing=%v err=%v, want [win_keybd]", missing, err)
+	}
+}
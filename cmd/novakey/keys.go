@@ -2,109 +2,269 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"filippo.io/mlkem768"
+	"golang.org/x/crypto/hkdf"
 )
 
+// server_keys.json holds the current ML-KEM-768 keypair and, since version 2,
+// keys retired by rotate_kyber_keys. A retired key still decrypts /msg until
+// its not_after_unix, so phones that pinned it keep working while they pick
+// up the new public key (see staleServerKeyUpdate).
+const serverKeysVersion = 2
+
 type serverKeys struct {
-	KyberPub  string `json:"kyber768_public"`
-	KyberPriv string `json:"kyber768_secret"` // base64 seed (dk.Bytes())
+	Version     int    `json:"version,omitempty"`
+	KID         string `json:"kid,omitempty"`
+	CreatedUnix int64  `json:"created_unix,omitempty"`
+	KyberPub    string `json:"kyber768_public"`
+	KyberPriv   string `json:"kyber768_secret"` // base64 seed (dk.Bytes())
+
+	Retired []retiredServerKey `json:"retired,omitempty"`
+}
+
+type retiredServerKey struct {
+	KID          string `json:"kid"`
+	KyberPub     string `json:"kyber768_public"`
+	KyberPriv    string `json:"kyber768_secret"`
+	NotAfterUnix int64  `json:"not_after_unix"`
+}
+
+// serverKEMKey is a materialized key from server_keys.json.
+type serverKEMKey struct {
+	kid      string
+	dk       *mlkem768.DecapsulationKey
+	pub      []byte
+	notAfter time.Time // zero for the current key
 }
 
 var (
 	srvKeys        serverKeys
 	serverDecapKey *mlkem768.DecapsulationKey
 	serverEncapKey []byte // raw bytes
+	serverKID      string
+
+	retiredServerKeys []serverKEMKey
 )
 
+// serverKeyID is the key ID of an ML-KEM public key: the first 4 bytes of its
+// SHA-256, in hex (a prefix of the fp16 fingerprint).
+func serverKeyID(pub []byte) string {
+	h := sha256.Sum256(pub)
+	return hex.EncodeToString(h[:4])
+}
+
 // loadOrCreateServerKeys loads server_keys.json if present; otherwise generates new keys.
-// If cfg.RotateKyberKeys is true, it ALWAYS generates and overwrites the file.
+// If cfg.RotateKyberKeys is true, it generates a new current key and retires
+// the old one for kyber_key_overlap_days.
 func loadOrCreateServerKeys(path string) error {
 	if path == "" {
 		path = "server_keys.json"
 	}
 	abs, _ := filepath.Abs(path)
 
-	if cfg.RotateKyberKeys {
-		log.Printf("[keys] rotate_kyber_keys=true; generating new ML-KEM-768 keypair (%s)", abs)
-		return generateAndSaveServerKeys(path)
-	}
-
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			log.Printf("[keys] server keys file %s not found; generating new ML-KEM-768 keypair", abs)
-			return generateAndSaveServerKeys(path)
+			return generateAndSaveServerKeys(path, serverKeys{})
 		}
 		return fmt.Errorf("reading %s: %w", abs, err)
 	}
 
-	if err := json.Unmarshal(data, &srvKeys); err != nil {
+	var sk serverKeys
+	if err := json.Unmarshal(data, &sk); err != nil {
 		return fmt.Errorf("parsing %s: %w", abs, err)
 	}
-	if srvKeys.KyberPub == "" || srvKeys.KyberPriv == "" {
+	if sk.KyberPub == "" || sk.KyberPriv == "" {
 		return fmt.Errorf("invalid %s: missing kyber768_public or kyber768_secret", abs)
 	}
+	if sk.Version > serverKeysVersion {
+		return fmt.Errorf("%s has version %d; this build understands up to %d", abs, sk.Version, serverKeysVersion)
+	}
+
+	if cfg := currentConfig(); cfg.RotateKyberKeys {
+		notAfter := time.Now().Add(time.Duration(cfg.KyberKeyOverlapDays) * day)
+		sk = retireServerKey(sk, notAfter)
+		log.Printf("[keys] rotate_kyber_keys=true; generating new ML-KEM-768 keypair (%s); old key kid=%s accepted until %s",
+			abs, sk.Retired[len(sk.Retired)-1].KID, notAfter.Format(time.RFC3339))
+		return generateAndSaveServerKeys(path, sk)
+	}
+
+	// Drop retired keys past their overlap, and upgrade version 1 files.
+	n := len(sk.Retired)
+	sk = pruneRetiredServerKeys(sk, time.Now())
+	if err := materializeServerKeys(abs, sk); err != nil {
+		return err
+	}
+	if sk.Version != serverKeysVersion || sk.KID != serverKID || len(sk.Retired) != n {
+		sk.Version, sk.KID = serverKeysVersion, serverKID
+		if err := writeServerKeys(path, sk); err != nil {
+			return err
+		}
+	}
+	srvKeys = sk
+
+	log.Printf("[keys] loaded server ML-KEM keys from %s (kid=%s, %d retired)", abs, serverKID, len(retiredServerKeys))
+	return nil
+}
 
-	if err := materializeServerKeys(abs); err != nil {
+// retireServerKey moves sk's current key to its retired list, accepted until notAfter.
+func retireServerKey(sk serverKeys, notAfter time.Time) serverKeys {
+	kid := sk.KID
+	if pub, err := base64.StdEncoding.DecodeString(sk.KyberPub); err == nil {
+		kid = serverKeyID(pub)
+	}
+	sk.Retired = append(sk.Retired, retiredServerKey{
+		KID:          kid,
+		KyberPub:     sk.KyberPub,
+		KyberPriv:    sk.KyberPriv,
+		NotAfterUnix: notAfter.Unix(),
+	})
+	return pruneRetiredServerKeys(sk, time.Now())
+}
+
+func pruneRetiredServerKeys(sk serverKeys, now time.Time) serverKeys {
+	var keep []retiredServerKey
+	for _, r := range sk.Retired {
+		if now.Unix() < r.NotAfterUnix {
+			keep = append(keep, r)
+		}
+	}
+	sk.Retired = keep
+	return sk
+}
+
+func materializeServerKeys(absPath string, sk serverKeys) error {
+	cur, err := materializeServerKey(absPath, "kyber768", sk.KyberPub, sk.KyberPriv)
+	if err != nil {
 		return err
 	}
 
-	log.Printf("[keys] loaded server ML-KEM keys from %s", abs)
+	var retired []serverKEMKey
+	for i, r := range sk.Retired {
+		k, err := materializeServerKey(absPath, fmt.Sprintf("retired[%d]", i), r.KyberPub, r.KyberPriv)
+		if err != nil {
+			return err
+		}
+		k.notAfter = time.Unix(r.NotAfterUnix, 0)
+		retired = append(retired, k)
+	}
+
+	serverDecapKey = cur.dk
+	serverEncapKey = cur.pub
+	serverKID = cur.kid
+	retiredServerKeys = retired
 	return nil
 }
 
-func materializeServerKeys(absPath string) error {
-	privSeed, err := base64.StdEncoding.DecodeString(srvKeys.KyberPriv)
+func materializeServerKey(absPath, name, pubB64, privB64 string) (serverKEMKey, error) {
+	privSeed, err := base64.StdEncoding.DecodeString(privB64)
 	if err != nil {
-		return fmt.Errorf("decoding kyber768_secret in %s: %w", absPath, err)
+		return serverKEMKey{}, fmt.Errorf("decoding %s secret in %s: %w", name, absPath, err)
 	}
 	if len(privSeed) != mlkem768.SeedSize {
-		return fmt.Errorf("kyber768_secret in %s has wrong length: got %d want %d",
-			absPath, len(privSeed), mlkem768.SeedSize)
+		return serverKEMKey{}, fmt.Errorf("%s secret in %s has wrong length: got %d want %d",
+			name, absPath, len(privSeed), mlkem768.SeedSize)
 	}
 
 	dk, err := mlkem768.NewKeyFromSeed(privSeed)
 	if err != nil {
-		return fmt.Errorf("mlkem768.NewKeyFromSeed: %w", err)
+		return serverKEMKey{}, fmt.Errorf("mlkem768.NewKeyFromSeed: %w", err)
 	}
-	serverDecapKey = dk
 
-	pubBytes, err := base64.StdEncoding.DecodeString(srvKeys.KyberPub)
+	pubBytes, err := base64.StdEncoding.DecodeString(pubB64)
 	if err != nil {
-		return fmt.Errorf("decoding kyber768_public in %s: %w", absPath, err)
+		return serverKEMKey{}, fmt.Errorf("decoding %s public in %s: %w", name, absPath, err)
 	}
 	if len(pubBytes) != mlkem768.EncapsulationKeySize {
-		return fmt.Errorf("kyber768_public in %s has wrong length: got %d want %d",
-			absPath, len(pubBytes), mlkem768.EncapsulationKeySize)
-	}
-
-	// Optional consistency check: pub in file should match pub derived from seed
-	derived := dk.EncapsulationKey()
-	if len(derived) == len(pubBytes) {
-		same := true
-		for i := range derived {
-			if derived[i] != pubBytes[i] {
-				same = false
-				break
-			}
-		}
-		if !same {
-			return fmt.Errorf("server keys mismatch in %s: public key does not match private seed", absPath)
+		return serverKEMKey{}, fmt.Errorf("%s public in %s has wrong length: got %d want %d",
+			name, absPath, len(pubBytes), mlkem768.EncapsulationKeySize)
+	}
+
+	// Consistency check: pub in file must match pub derived from seed
+	if !constTimeEq(dk.EncapsulationKey(), pubBytes) {
+		return serverKEMKey{}, fmt.Errorf("server keys mismatch in %s: %s public key does not match private seed", absPath, name)
+	}
+
+	return serverKEMKey{kid: serverKeyID(pubBytes), dk: dk, pub: pubBytes}, nil
+}
+
+// activeServerKeys returns the current key followed by the retired keys
+// still inside their overlap period.
+func activeServerKeys(now time.Time) []serverKEMKey {
+	keys := []serverKEMKey{{kid: serverKID, dk: serverDecapKey, pub: serverEncapKey}}
+	for _, k := range retiredServerKeys {
+		if now.Before(k.notAfter) {
+			keys = append(keys, k)
 		}
 	}
+	return keys
+}
 
-	serverEncapKey = pubBytes
-	return nil
+// serverKeyUpdate is attached to replies for messages encrypted to a retired
+// server key. The MAC lets the phone check the new key came from the daemon
+// it already trusts:
+//
+//	macKey = HKDF-SHA256(ikm=sharedKem, salt=deviceKey, info="NovaKey v3 server key update")
+//	mac    = HMAC-SHA256(macKey, kid || kyber768_public)
+type serverKeyUpdate struct {
+	KID           string `json:"kid"`
+	KyberPubB64   string `json:"kyber_pub_b64"`
+	MACB64        string `json:"mac_b64"`
+	StaleKID      string `json:"stale_kid"`
+	StaleNotAfter int64  `json:"stale_not_after_unix"`
 }
 
-func generateAndSaveServerKeys(path string) error {
+func staleServerKeyUpdate(mc msgContext) (*serverKeyUpdate, error) {
+	h := hkdf.New(sha256.New, mc.sharedKem, mc.deviceKey, []byte("NovaKey v3 server key update"))
+	macKey := make([]byte, 32)
+	if _, err := io.ReadFull(h, macKey); err != nil {
+		return nil, fmt.Errorf("hkdf server key update: %w", err)
+	}
+	m := hmac.New(sha256.New, macKey)
+	m.Write([]byte(serverKID))
+	m.Write(serverEncapKey)
+
+	return &serverKeyUpdate{
+		KID:           serverKID,
+		KyberPubB64:   base64.StdEncoding.EncodeToString(serverEncapKey),
+		MACB64:        base64.StdEncoding.EncodeToString(m.Sum(nil)),
+		StaleKID:      mc.serverKey.kid,
+		StaleNotAfter: mc.serverKey.notAfter.Unix(),
+	}, nil
+}
+
+// staleServerKeyNotice is appended to reply msgs for clients that don't read
+// server_key. server_key_kid is the key to switch to.
+func staleServerKeyNotice(used serverKEMKey) string {
+	return fmt.Sprintf("server_key_kid=%s server_key_retired_unix=%d (update the server key or re-pair before then)",
+		serverKID, used.notAfter.Unix())
+}
+
+// serverKeyByID finds kid among keys (from activeServerKeys).
+func serverKeyByID(keys []serverKEMKey, kid string) (serverKEMKey, bool) {
+	for _, k := range keys {
+		if k.kid == kid {
+			return k, true
+		}
+	}
+	return serverKEMKey{}, false
+}
+
+// generateAndSaveServerKeys writes a new current key, keeping prev's retired keys.
+func generateAndSaveServerKeys(path string, prev serverKeys) error {
 	abs, _ := filepath.Abs(path)
 
 	dk, err := mlkem768.GenerateKey()
@@ -115,12 +275,31 @@ func generateAndSaveServerKeys(path string) error {
 	privSeed := dk.Bytes()
 	pubKey := dk.EncapsulationKey()
 
-	srvKeys = serverKeys{
-		KyberPub:  base64.StdEncoding.EncodeToString(pubKey),
-		KyberPriv: base64.StdEncoding.EncodeToString(privSeed),
+	sk := serverKeys{
+		Version:     serverKeysVersion,
+		KID:         serverKeyID(pubKey),
+		CreatedUnix: time.Now().Unix(),
+		KyberPub:    base64.StdEncoding.EncodeToString(pubKey),
+		KyberPriv:   base64.StdEncoding.EncodeToString(privSeed),
+		Retired:     prev.Retired,
+	}
+
+	if err := writeServerKeys(path, sk); err != nil {
+		return err
+	}
+
+	// Initialize runtime objects from what we just created
+	if err := materializeServerKeys(abs, sk); err != nil {
+		return err
 	}
+	srvKeys = sk
 
-	data, err := json.MarshalIndent(&srvKeys, "", "  ")
+	log.Printf("[keys] generated new server ML-KEM keys at %s (kid=%s)", abs, serverKID)
+	return nil
+}
+
+func writeServerKeys(path string, sk serverKeys) error {
+	data, err := json.MarshalIndent(&sk, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal server keys: %w", err)
 	}
@@ -132,12 +311,5 @@ func generateAndSaveServerKeys(path string) error {
 	if err := os.Rename(tmp, path); err != nil {
 		return fmt.Errorf("rename %s -> %s: %w", tmp, path, err)
 	}
-
-	// Initialize runtime objects from what we just created
-	if err := materializeServerKeys(abs); err != nil {
-		return err
-	}
-
-	log.Printf("[keys] generated new server ML-KEM keys at %s", abs)
 	return nil
 }
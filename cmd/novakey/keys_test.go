@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/mlkem768"
+)
+
+func TestServerKeys_RotateKeepsRetiredKeyForOverlap(t *testing.T) {
+	prevCfg, prevDK, prevPub, prevKID, prevRetired := currentConfig(), serverDecapKey, serverEncapKey, serverKID, retiredServerKeys
+	t.Cleanup(func() {
+		cfgCurrent.Store(prevCfg)
+		serverDecapKey, serverEncapKey, serverKID, retiredServerKeys = prevDK, prevPub, prevKID, prevRetired
+	})
+
+	// A version 1 file: one keypair, no kid.
+	dk, err := mlkem768.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPub := dk.EncapsulationKey()
+	path := filepath.Join(t.TempDir(), "server_keys.json")
+	v1, _ := json.Marshal(serverKeys{
+		KyberPub:  base64.StdEncoding.EncodeToString(oldPub),
+		KyberPriv: base64.StdEncoding.EncodeToString(dk.Bytes()),
+	})
+	if err := os.WriteFile(path, v1, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ServerConfig{}
+	applyDefaults(cfg)
+	cfgCurrent.Store(cfg)
+	if err := loadOrCreateServerKeys(path); err != nil {
+		t.Fatalf("load v1: %v", err)
+	}
+	oldKID := serverKeyID(oldPub)
+	if serverKID != oldKID || len(retiredServerKeys) != 0 {
+		t.Fatalf("after load: kid=%s retired=%d", serverKID, len(retiredServerKeys))
+	}
+
+	rotate := *cfg
+	rotate.RotateKyberKeys = true
+	cfgCurrent.Store(&rotate)
+	if err := loadOrCreateServerKeys(path); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if serverKID == oldKID {
+		t.Fatal("current key not rotated")
+	}
+
+	keys := activeServerKeys(time.Now())
+	if len(keys) != 2 || keys[1].kid != oldKID {
+		t.Fatalf("active keys: %+v", keys)
+	}
+	wantNotAfter := time.Now().Add(time.Duration(cfg.KyberKeyOverlapDays) * day)
+	if d := keys[1].notAfter.Sub(wantNotAfter); d < -time.Minute || d > time.Minute {
+		t.Fatalf("retired not_after=%s, want about %s", keys[1].notAfter, wantNotAfter)
+	}
+
+	// A phone still pinning the old public key gets through via the retired key.
+	ct, shared, err := mlkem768.Encapsulate(oldPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := mlkem768.Decapsulate(keys[1].dk, ct); !bytes.Equal(got, shared) {
+		t.Fatal("retired key does not decapsulate for the old public key")
+	}
+
+	// Past its overlap the retired key is dropped from the file on load.
+	if later := activeServerKeys(wantNotAfter.Add(day)); len(later) != 1 || later[0].kid != serverKID {
+		t.Fatalf("retired key still active after overlap: %+v", later)
+	}
+	var sk serverKeys
+	data, _ := os.ReadFile(path)
+	_ = json.Unmarshal(data, &sk)
+	sk.Retired[0].NotAfterUnix = time.Now().Add(-time.Minute).Unix()
+	data, _ = json.Marshal(sk)
+	_ = os.WriteFile(path, data, 0o600)
+
+	cfgCurrent.Store(cfg)
+	if err := loadOrCreateServerKeys(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(path)
+	sk = serverKeys{}
+	_ = json.Unmarshal(data, &sk)
+	if len(sk.Retired) != 0 || len(retiredServerKeys) != 0 || sk.Version != serverKeysVersion {
+		t.Fatalf("expired retired key kept: %+v", sk.Retired)
+	}
+}
@@ -16,6 +16,7 @@ type logOutputs struct {
 }
 
 func selectLogOutputs() logOutputs {
+	cfg := currentConfig()
 	toStderr := true
 	if cfg.LogStderr != nil {
 		toStderr = *cfg.LogStderr
@@ -86,10 +86,7 @@ func initLoggingFromConfig() {
 }
 
 func loggingRedactEnabled() bool {
-	if cfg.LogRedact == nil {
-		return true
-	}
-	return *cfg.LogRedact
+	return boolDeref(currentConfig().LogRedact, true)
 }
 
 func addSecret(s string) {
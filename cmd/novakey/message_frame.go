@@ -13,12 +13,13 @@ const (
 	MsgTypeApprove = 2
 	MsgTypeArm     = 3
 	MsgTypeDisarm  = 4
+	MsgTypeRekey   = 5
 )
 
 // Frame format (plaintext BEFORE encryption):
 //
 //	[0]   = version (uint8) = 1
-//	[1]   = msgType (uint8) = 1 inject, 2 approve, 3 arm, 4 disarm
+//	[1]   = msgType (uint8) = 1 inject, 2 approve, 3 arm, 4 disarm, 5 rekey
 //	[2:4] = deviceIDLen (uint16, big endian)
 //	[4:8] = payloadLen  (uint32, big endian)
 //	[..]  = deviceID bytes (UTF-8)
@@ -29,12 +30,13 @@ const (
 // - payload for MsgTypeDisarm is typically empty.
 // - payload for MsgTypeArm is optional JSON: {"ms":15000}
 // - payload for MsgTypeInject is the secret string.
+// - payload for MsgTypeRekey is optional JSON: {"new_key_hex":"..."} (see rekey.go)
 func encodeMessageFrame(deviceID string, msgType uint8, payload []byte) ([]byte, error) {
 	if deviceID == "" {
 		return nil, fmt.Errorf("deviceID required")
 	}
 	switch msgType {
-	case MsgTypeInject, MsgTypeApprove, MsgTypeArm, MsgTypeDisarm:
+	case MsgTypeInject, MsgTypeApprove, MsgTypeArm, MsgTypeDisarm, MsgTypeRekey:
 	default:
 		return nil, fmt.Errorf("invalid msgType=%d", msgType)
 	}
@@ -75,7 +77,7 @@ func decodeMessageFrame(b []byte) (deviceID string, msgType uint8, payload []byt
 
 	msgType = b[1]
 	switch msgType {
-	case MsgTypeInject, MsgTypeApprove, MsgTypeArm, MsgTypeDisarm:
+	case MsgTypeInject, MsgTypeApprove, MsgTypeArm, MsgTypeDisarm, MsgTypeRekey:
 	default:
 		return "", 0, nil, fmt.Errorf("invalid msgType=%d", msgType)
 	}
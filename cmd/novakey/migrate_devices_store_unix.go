@@ -7,6 +7,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -19,6 +20,10 @@ import (
 func init() {
 	// One-shot command: `novakey migrate-devices-store`
 	if len(os.Args) >= 2 && os.Args[1] == "migrate-devices-store" {
+		fs := flag.NewFlagSet("migrate-devices-store", flag.ExitOnError)
+		fs.StringVar(&configFlagPath, "config", "", "path to server config file")
+		_ = fs.Parse(os.Args[2:])
+
 		if err := runMigrateDevicesStore(); err != nil {
 			fmt.Fprintf(os.Stderr, "migrate-devices-store: %v\n", err)
 			os.Exit(1)
@@ -34,7 +39,7 @@ func runMigrateDevicesStore() error {
 		return err
 	}
 
-	path := cfg.DevicesFile
+	path := currentConfig().DevicesFile
 	if path == "" {
 		path = "devices.json"
 	}
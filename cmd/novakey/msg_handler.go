@@ -8,8 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"os"
-	"strings"
+	"slices"
 	"time"
 )
 
@@ -21,13 +20,52 @@ func handleMsgConn(conn net.Conn) error {
 	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
 	defer func() { _ = conn.SetDeadline(time.Time{}) }()
 
+	// One config snapshot per request so a concurrent reload can't mix old and new settings.
+	cfg := currentConfig()
+
 	reqID := nextReqID()
 	remote := conn.RemoteAddr().String()
 	logReqf(reqID, "connection opened from %s", remote)
 
+	// Set once the device is authenticated: its key is close to expiry, or
+	// it encrypted to a retired server key.
+	keyNotice := ""
+	var serverKey *serverKeyUpdate
+	addNotice := func(n string) {
+		if keyNotice != "" {
+			keyNotice += "; "
+		}
+		keyNotice += n
+	}
+	withNotice := func(msg string) string {
+		if keyNotice == "" {
+			return msg
+		}
+		if msg == "" {
+			return keyNotice
+		}
+		return msg + "; " + keyNotice
+	}
+	// Set once the request authenticated, if it asked for a sealed reply.
+	var sealFor *msgContext
+	send := func(r ServerReply) {
+		r.ServerKey = serverKey
+		if sealFor != nil {
+			writeSealedReplyLine(conn, *sealFor, r)
+			return
+		}
+		writeReplyLine(conn, r)
+	}
+
 	// ALWAYS reply with ONE newline-terminated JSON line (machine-readable).
 	respond := func(st RespStatus, stage ReplyStage, reason ReplyReason, msg string) {
-		writeReplyLine(conn, makeReply(reqID, st, stage, reason, msg))
+		send(makeReply(reqID, st, stage, reason, withNotice(msg)))
+	}
+	// Same, plus the pending request a client needs for request-scoped approve.
+	respondPending := func(st RespStatus, stage ReplyStage, reason ReplyReason, msg string, q quorumStatus) {
+		r := makeReply(reqID, st, stage, reason, withNotice(msg))
+		r.PendingID, r.Fingerprint = q.ID, q.Fingerprint
+		send(r)
 	}
 
 	maxLen := cfg.MaxPayloadLen
@@ -61,17 +99,74 @@ func handleMsgConn(conn net.Conn) error {
 	}
 
 	// ---- Decrypt FIRST. Never branch on msgType until err == nil. ----
-	deviceID, msgType, payload, err := decryptMessageFrame(buf)
+	deviceID, msgType, payload, mc, err := decryptMessageFrame(cfg, buf)
 	if err != nil {
-		logReqf(reqID, "decryptMessageFrame failed: %v", err)
-		respond(StatusCryptoFail, StageMsg, ReasonCryptoFail, "decrypt/auth failed")
+		rej := classifyMsgError(err, mc)
+		countMsgReject(rej.class)
+		logReqf(reqID, "decryptMessageFrame failed (%s): %v", rej.class, err)
+		if rej.authenticated && mc.sealReply {
+			sealFor = &mc
+		}
+		r := makeReply(reqID, rej.status, StageMsg, rej.reason, rej.msg)
+		r.ServerTimeUnix = rej.serverTime
+		send(r)
+		return nil
+	}
+
+	if mc.sealReply {
+		sealFor = &mc
+	}
+
+	// Authenticated, so the new server key can go out with every reply below.
+	if mc.staleServerKey() {
+		logReqf(reqID, "device=%q used retired server key kid=%s (accepted until %s)",
+			deviceID, mc.serverKey.kid, mc.serverKey.notAfter.Format(time.RFC3339))
+		if serverKey, err = staleServerKeyUpdate(mc); err != nil {
+			logReqf(reqID, "server key update: %v", err)
+		}
+		addNotice(staleServerKeyNotice(mc.serverKey))
+	}
+
+	// Key lifetime is checked only after authentication, so an expired
+	// device learns why and nobody else learns anything.
+	dev, _ := lookupDevice(deviceID)
+	switch life, exp := checkKeyLifetime(cfg, dev, time.Now()); life {
+	case keyExpired:
+		logReqf(reqID, "device=%q key expired at %s; rekey required", deviceID, exp.Format(time.RFC3339))
+		respond(StatusNotPaired, StageMsg, ReasonRekeyRequired, "device key expired; re-pair this device")
 		return nil
+	case keyExpiring:
+		logReqf(reqID, "device=%q key expires at %s", deviceID, exp.Format(time.RFC3339))
+		addNotice(keyExpiryNotice(exp))
+	}
+	if mc.prevKey {
+		logReqf(reqID, "device=%q authenticated with its previous key (rekey grace)", deviceID)
+	}
+	noteDeviceActivity(deviceID)
+
+	// Per-device policy: from here on cfg is the device's effective config.
+	// An Approve needs the global one to find the injector it is for.
+	globalCfg := cfg
+	cfg, profile, err := policyForDevice(cfg, deviceID)
+	if err != nil {
+		logReqf(reqID, "policy profile error: %v", err)
+		respond(StatusBadRequest, StageMsg, ReasonBadRequest, "device policy profile unavailable")
+		return nil
+	}
+	if profile != "" {
+		logReqf(reqID, "device=%q policy profile=%q", deviceID, profile)
 	}
 
 	// ---- Route by msgType ----
 	switch msgType {
 
 	case MsgTypeArm:
+		if !boolDeref(cfg.NetworkArmEnabled, true) {
+			logReqf(reqID, "arm from device=%q rejected: network_arm_enabled=false", deviceID)
+			respond(StatusBadRequest, StageArm, ReasonBadRequest, "network arm disabled; arm on the computer (novakey arm)")
+			return nil
+		}
+
 		// payload is optional JSON: {"ms":15000}
 		ms := cfg.ArmDurationMs
 		if len(payload) > 0 {
@@ -92,18 +187,136 @@ func handleMsgConn(conn net.Conn) error {
 		return nil
 
 	case MsgTypeApprove:
-		// two_man_enabled defaults true via boolDeref(..., true)
-		if !boolDeref(cfg.TwoManEnabled, true) {
+		dev, _ := lookupDevice(deviceID)
+		if !dev.canApprove() {
+			logReqf(reqID, "approve from device=%q rejected: role=%s", deviceID, dev.role)
+			respond(StatusBadRequest, StageApprove, ReasonBadRequest, "device role does not allow approve")
+			return nil
+		}
+
+		// payload is optional JSON: {"request_id":"<pending_id>"}
+		var ap approvePayload
+		if len(payload) > 0 {
+			if err := json.Unmarshal(payload, &ap); err != nil {
+				logReqf(reqID, "approve from device=%q: invalid payload: %v", deviceID, err)
+				respond(StatusBadRequest, StageApprove, ReasonBadRequest, "invalid approve payload")
+				return nil
+			}
+		}
+
+		// Request-scoped approve: release exactly the named pending inject.
+		if ap.RequestID != "" {
+			q, err := quorum.ApproveRequest(deviceID, ap.RequestID)
+			switch {
+			case errors.Is(err, errNotInGroup):
+				logReqf(reqID, "approve from device=%q for request=%q rejected: not an allowed approver for injector=%q", deviceID, ap.RequestID, q.Injector)
+				respondPending(StatusBadRequest, StageApprove, ReasonWrongApprover, err.Error(), q)
+			case err != nil:
+				logReqf(reqID, "approve from device=%q for request=%q rejected: %v", deviceID, ap.RequestID, err)
+				respond(StatusBadRequest, StageApprove, ReasonBadRequest, err.Error())
+			default:
+				logReqf(reqID, "approve from device=%q for request=%q injector=%q (%d of %d)", deviceID, q.ID, q.Injector, q.Got, q.Need)
+				respondPending(StatusOK, StageApprove, ReasonOK,
+					fmt.Sprintf("approved for %s (%d of %d approvals received)", q.Injector, q.Got, q.Need), q)
+			}
+			return nil
+		}
+
+		// A pending request this device may approve takes precedence over a
+		// plain approval window.
+		q, matched := quorum.Approve(deviceID)
+		if matched > 1 {
+			logReqf(reqID, "approve from device=%q is ambiguous: %d pending requests", deviceID, matched)
+			respond(StatusBadRequest, StageApprove, ReasonBadRequest,
+				fmt.Sprintf("ambiguous approve: %d pending requests (send request_id)", matched))
+			return nil
+		}
+		if matched == 1 {
+			logReqf(reqID, "approve from device=%q for request=%q injector=%q (%d of %d)", deviceID, q.ID, q.Injector, q.Got, q.Need)
+			respondPending(StatusOK, StageApprove, ReasonOK,
+				fmt.Sprintf("approved for %s (%d of %d approvals received)", q.Injector, q.Got, q.Need), q)
+			return nil
+		}
+		// A request-scoped inject is only released by its request_id, never
+		// by an approval window.
+		if n := quorum.ScopedPending(deviceID); n > 0 {
+			logReqf(reqID, "approve from device=%q without request_id: %d request-scoped pending", deviceID, n)
+			respond(StatusBadRequest, StageApprove, ReasonBadRequest, "request-scoped approve pending (send request_id)")
+			return nil
+		}
+
+		// two_man_enabled defaults true via boolDeref(..., true). An
+		// approver-only device approves for others, so its own profile's
+		// two-man setting doesn't matter.
+		if dev.role != RoleApprover && !boolDeref(cfg.TwoManEnabled, true) {
 			logReqf(reqID, "approve message received but two_man_enabled=false; ignoring")
 			respond(StatusBadRequest, StageApprove, ReasonBadRequest, "two-man disabled; approve ignored")
 			return nil
 		}
-		until := approvalGate.Approve(deviceID, approveWindow())
-		logReqf(reqID, "two-man approve received from device=%q; approved until %s",
-			deviceID, until.Format(time.RFC3339Nano))
+		// The window is for one injector: the one named in the payload, this
+		// device itself when it may approve its own injects, or else the only
+		// injector that counts this device as an approver.
+		injector := ap.Injector
+		if injector == "" {
+			targets := approvalTargets(globalCfg, deviceID)
+			switch {
+			case slices.Contains(targets, deviceID), len(targets) == 0 && dev.canInject():
+				injector = deviceID
+			case len(targets) == 1:
+				injector = targets[0]
+			case len(targets) == 0:
+				logReqf(reqID, "approve from device=%q rejected: not an allowed approver for any injector", deviceID)
+				respond(StatusBadRequest, StageApprove, ReasonWrongApprover, "not an allowed approver for any injector")
+				return nil
+			default:
+				logReqf(reqID, "approve from device=%q is ambiguous: approver for %q", deviceID, targets)
+				respond(StatusBadRequest, StageApprove, ReasonBadRequest,
+					fmt.Sprintf("ambiguous approve: approver for %d injectors (send injector)", len(targets)))
+				return nil
+			}
+		} else if _, ok := lookupDevice(injector); !ok {
+			logReqf(reqID, "approve from device=%q rejected: unknown injector %q", deviceID, injector)
+			respond(StatusBadRequest, StageApprove, ReasonBadRequest, "unknown injector")
+			return nil
+		}
+		until := approvalGate.Approve(deviceID, injector, approveWindow(cfg))
+		logReqf(reqID, "two-man approve received from device=%q for injector=%q; approved until %s",
+			deviceID, injector, until.Format(time.RFC3339Nano))
 		respond(StatusOK, StageApprove, ReasonOK, "approved")
 		return nil
 
+	case MsgTypeRekey:
+		newKey, generated, err := rekeyNewKey(payload, mc.deviceKey)
+		if err != nil {
+			respond(StatusBadRequest, StageRekey, ReasonBadRequest, err.Error())
+			return nil
+		}
+
+		// Seal before swapping so a failure leaves the old key in place.
+		var sealed string
+		if generated {
+			if sealed, err = sealRekeyKey(mc, newKey); err != nil {
+				logReqf(reqID, "rekey seal failed: %v", err)
+				respond(StatusInternal, StageRekey, ReasonInternal, "rekey failed")
+				return nil
+			}
+		}
+
+		until, err := rotateDeviceKey(cfg.DevicesFile, deviceID, mc.deviceKey, newKey, mc.prevKey)
+		if err != nil {
+			logReqf(reqID, "rekey for device=%q failed: %v", deviceID, err)
+			respond(StatusInternal, StageRekey, ReasonInternal, "rekey failed")
+			return nil
+		}
+		logReqf(reqID, "device=%q rekeyed (generated=%v); previous key accepted until %s",
+			deviceID, generated, until.Format(time.RFC3339))
+
+		// The expiry notice was about the old key.
+		r := makeReply(reqID, StatusOK, StageRekey, ReasonOK, fmt.Sprintf("rekeyed; previous key accepted until %d", until.Unix()))
+		r.NewKeySealed = sealed
+		send(r)
+		return nil
+
 	case MsgTypeInject:
 		// continue below
 
@@ -117,11 +330,17 @@ func handleMsgConn(conn net.Conn) error {
 	password := string(payload)
 	logReqf(reqID, "decrypted payload from device=%q (len=%d)", deviceID, len(payload))
 
+	if dev, _ := lookupDevice(deviceID); !dev.canInject() {
+		logReqf(reqID, "blocked injection: device=%q role=%s", deviceID, dev.role)
+		respond(StatusBadRequest, StageInject, ReasonBadRequest, "device role does not allow inject")
+		return nil
+	}
+
 	// Unsafe-text filter
-	if err := validateInjectText(password); err != nil {
+	if err := validateInjectText(cfg, password); err != nil {
 		logReqf(reqID, "blocked injection (unsafe text): %v", err)
 
-		if allowClipboardWhenBlocked() {
+		if allowClipboardWhenBlocked(cfg) {
 			if err2 := trySetClipboard(password); err2 != nil {
 				logReqf(reqID, "clipboard set failed: %v", err2)
 				respond(StatusBadRequest, StageInject, ReasonBadRequest, "unsafe text; clipboard failed")
@@ -137,14 +356,14 @@ func handleMsgConn(conn net.Conn) error {
 	}
 
 	// Target policy (do BEFORE consuming gates)
-	if err := enforceTargetPolicy(); err != nil {
+	checked, err := enforceTargetPolicy(cfg)
+	if err != nil {
 		logReqf(reqID, "blocked injection (target policy): %v", err)
 
 		// Wayland: focused app detection is not implemented, so target policy cannot be evaluated.
 		// Return a stable reply so clients can handle it cleanly.
-		xdg := strings.ToLower(strings.TrimSpace(os.Getenv("XDG_SESSION_TYPE")))
-		if xdg == "wayland" || os.Getenv("WAYLAND_DISPLAY") != "" {
-			if allowClipboardWhenBlocked() {
+		if waylandSession() {
+			if allowClipboardWhenBlocked(cfg) {
 				if err2 := trySetClipboard(password); err2 != nil {
 					logReqf(reqID, "clipboard set failed: %v", err2)
 					respond(StatusBadRequest, StageInject, ReasonBadRequest, "target policy unavailable on wayland; clipboard failed")
@@ -161,7 +380,7 @@ func handleMsgConn(conn net.Conn) error {
 		}
 
 		// Normal target policy denial (or other focused-target error)
-		if allowClipboardWhenBlocked() {
+		if allowClipboardWhenBlocked(cfg) {
 			if err2 := trySetClipboard(password); err2 != nil {
 				logReqf(reqID, "clipboard set failed: %v", err2)
 				respond(StatusBadRequest, StageInject, ReasonBadRequest, "target policy blocked; clipboard failed")
@@ -176,22 +395,71 @@ func handleMsgConn(conn net.Conn) error {
 		return nil
 	}
 
+	// Typing stops if focus leaves the window target policy just checked.
+	focus := newFocusGuard(reqID, checked)
+
 	// Serialize injection to avoid overlapping OS-level input / clipboard behavior.
 	injectMu.Lock()
 	defer injectMu.Unlock()
 
-	// Two-man gate
-	if boolDeref(cfg.TwoManEnabled, true) {
+	// Two-man gate: pending request bound to this payload (approval_quorum
+	// or request_scoped_approve)
+	if boolDeref(cfg.TwoManEnabled, true) && (cfg.ApprovalQuorum > 0 || cfg.RequestScopedApprove) {
 		consume := boolDeref(cfg.ApproveConsumeOnInject, true)
-		if !approvalGate.Consume(deviceID, consume) {
-			until := approvalGate.ApprovedUntil(deviceID)
-			if until.IsZero() {
-				logReqf(reqID, "blocked injection (two-man: not approved)")
-			} else {
-				logReqf(reqID, "blocked injection (two-man: approval expired at %s)", until.Format(time.RFC3339Nano))
+		group, need := pendingApprovalRule(cfg, deviceID)
+		if len(group) < need {
+			logReqf(reqID, "warning: %d approvals required but only %d eligible approvers %q", need, len(group), group)
+		}
+
+		q, ok := quorum.Check(deviceID, payloadFingerprint(payload), group, need, approveWindow(cfg), consume, cfg.RequestScopedApprove)
+		if !ok {
+			progress := fmt.Sprintf("%d of %d approvals received", q.Got, q.Need)
+			logReqf(reqID, "blocked injection (two-man: request=%q fingerprint=%s %s)", q.ID, q.Fingerprint, progress)
+
+			if allowClipboardWhenBlocked(cfg) {
+				if err2 := trySetClipboard(password); err2 != nil {
+					logReqf(reqID, "clipboard set failed: %v", err2)
+					respondPending(StatusNeedsApprove, StageInject, ReasonNeedsApprove, progress+"; clipboard failed", q)
+				} else {
+					logReqf(reqID, "blocked injection (two-man request); clipboard set")
+					respond(StatusOKClipboard, StageInject, ReasonClipboardFallback, "clipboard set (needs approve)")
+				}
+				return nil
 			}
 
-			if allowClipboardWhenBlocked() {
+			respondPending(StatusNeedsApprove, StageInject, ReasonNeedsApprove, progress, q)
+			return nil
+		}
+		logReqf(reqID, "two-man request=%q approved (%d of %d); proceeding", q.ID, q.Got, q.Need)
+	} else if boolDeref(cfg.TwoManEnabled, true) {
+		// Two-man gate: single approval window
+		consume := boolDeref(cfg.ApproveConsumeOnInject, true)
+		approvers := approversFor(deviceID, cfg.TwoManMode)
+		approver, ok := approvalGate.ConsumeFrom(deviceID, approvers, consume)
+		if !ok {
+			// Any live approval for this injector is from a device outside approvers.
+			if live := approvalGate.LiveApproversFor(deviceID); len(live) > 0 {
+				logReqf(reqID, "blocked injection (two-man mode=%s: approval from %q, allowed approvers %q)",
+					cfg.TwoManMode, live, approvers)
+
+				if allowClipboardWhenBlocked(cfg) {
+					if err2 := trySetClipboard(password); err2 != nil {
+						logReqf(reqID, "clipboard set failed: %v", err2)
+						respond(StatusNeedsApprove, StageInject, ReasonWrongApprover, "approval from wrong device; clipboard failed")
+					} else {
+						logReqf(reqID, "blocked injection (two-man: wrong approver); clipboard set")
+						respond(StatusOKClipboard, StageInject, ReasonClipboardFallback, "clipboard set (approval from wrong device)")
+					}
+					return nil
+				}
+
+				respond(StatusNeedsApprove, StageInject, ReasonWrongApprover, "approval from wrong device")
+				return nil
+			}
+
+			logReqf(reqID, "blocked injection (two-man mode=%s: not approved)", cfg.TwoManMode)
+
+			if allowClipboardWhenBlocked(cfg) {
 				if err2 := trySetClipboard(password); err2 != nil {
 					logReqf(reqID, "clipboard set failed: %v", err2)
 					respond(StatusNeedsApprove, StageInject, ReasonNeedsApprove, "needs approve; clipboard failed")
@@ -205,7 +473,7 @@ func handleMsgConn(conn net.Conn) error {
 			respond(StatusNeedsApprove, StageInject, ReasonNeedsApprove, "needs approve")
 			return nil
 		}
-		logReqf(reqID, "two-man approval OK; proceeding")
+		logReqf(reqID, "two-man approval OK (mode=%s approver=%q); proceeding", cfg.TwoManMode, approver)
 	}
 
 	// Arm gate (always enforced; controlled by arm_duration_ms + arm_consume_on_inject)
@@ -213,7 +481,7 @@ func handleMsgConn(conn net.Conn) error {
 	if !armGate.Consume(consumeArm) {
 		logReqf(reqID, "blocked injection (not armed)")
 
-		if allowClipboardWhenBlocked() {
+		if allowClipboardWhenBlocked(cfg) {
 			if err2 := trySetClipboard(password); err2 != nil {
 				logReqf(reqID, "clipboard set failed: %v", err2)
 				respond(StatusNotArmed, StageInject, ReasonNotArmed, "not armed; clipboard failed")
@@ -230,11 +498,24 @@ func handleMsgConn(conn net.Conn) error {
 	logReqf(reqID, "armed gate open; proceeding with injection")
 
 	// Perform injection (now returns method + err)
-	method, err := InjectPasswordToFocusedControl(password)
+	res, err := injectWithBackends(cfg, password, focus)
+	if errors.Is(err, ErrFocusChanged) {
+		// No clipboard fallback: whoever took focus would get the paste.
+		logReqf(reqID, "injection aborted: %v", err)
+		respond(StatusBadRequest, StageInject, ReasonFocusChanged, "focus changed during typing; aborted")
+		return nil
+	}
+	if errors.Is(err, ErrPartiallyTyped) {
+		// No clipboard fallback or other backend: part of the secret is
+		// already in the field.
+		logReqf(reqID, "injection failed after typing started: %v", err)
+		respond(StatusInternal, StageInject, ReasonInternal, "inject failed after typing started; clear the field")
+		return nil
+	}
 	if err != nil {
-		logReqf(reqID, "InjectPasswordToFocusedControl error: %v", err)
+		logReqf(reqID, "injection failed on every backend: %v", err)
 
-		if allowClipboardOnInjectFailure() {
+		if allowClipboardOnInjectFailure(cfg) {
 			if err2 := trySetClipboard(password); err2 != nil {
 				logReqf(reqID, "clipboard set failed: %v", err2)
 				respond(StatusInternal, StageInject, ReasonInternal, "inject failed; clipboard failed")
@@ -257,18 +538,25 @@ func handleMsgConn(conn net.Conn) error {
 	}
 
 	// Success: include deterministic reason for UI cues
-	logReqf(reqID, "injection complete; method=%s", method)
-	switch method {
+	logReqf(reqID, "injection complete; backend=%s method=%s", res.backend, res.method)
+	respondInjected := func(st RespStatus, stage ReplyStage, reason ReplyReason, msg string) {
+		r := makeReply(reqID, st, stage, reason, withNotice(msg))
+		r.InjectBackend = res.backend
+		send(r)
+	}
+	switch res.method {
 	case InjectMethodDirect:
-		respond(StatusOK, StageInject, ReasonOK, "ok")
+		respondInjected(StatusOK, StageInject, ReasonOK, "ok")
 	case InjectMethodTyping:
-		respond(StatusOK, StageInject, ReasonTypingFallback, "auto-typing used")
+		respondInjected(StatusOK, StageInject, ReasonTypingFallback, "auto-typing used")
+	case InjectMethodUinput:
+		respondInjected(StatusOK, StageInject, ReasonTypingFallback, "auto-typing used (uinput)")
 	case InjectMethodClipboard:
 		// macOS clipboard+Cmd+V succeeded (actual paste occurred)
-		respond(StatusOK, StageInject, ReasonClipboardFallback, "clipboard paste used")
+		respondInjected(StatusOK, StageInject, ReasonClipboardFallback, "clipboard paste used")
 	default:
 		// Defensive: should not happen, but don't crash client logic
-		respond(StatusOK, StageInject, ReasonOK, "ok")
+		respondInjected(StatusOK, StageInject, ReasonOK, "ok")
 	}
 	return nil
 }
@@ -0,0 +1,102 @@
+// cmd/novakey/msg_reject.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Rejection classes, as counted in status (msg_rejections).
+const (
+	rejectBadFrame       = "bad_frame"
+	rejectUnknownDevice  = "unknown_device"
+	rejectAuthFailed     = "auth_failed"
+	rejectStaleTimestamp = "stale_timestamp"
+	rejectReplay         = "replay"
+	rejectRateLimited    = "rate_limited"
+	rejectOther          = "other"
+)
+
+// msgReject is the reply for a decryptMessageFrame error.
+type msgReject struct {
+	class  string
+	status RespStatus
+	reason ReplyReason
+	msg    string
+
+	authenticated bool  // the frame opened under the device's key
+	serverTime    int64 // bad_timestamp only
+}
+
+// classifyMsgError maps a decryptMessageFrame error onto a reply. Until the
+// frame has authenticated (mc carries a device key) every failure replies
+// crypto_fail, so a sender can't tell an unknown device from a wrong key or
+// a malformed frame. The class is still counted.
+func classifyMsgError(err error, mc msgContext) msgReject {
+	r := msgReject{
+		class:         rejectOther,
+		status:        StatusCryptoFail,
+		reason:        ReasonCryptoFail,
+		msg:           "decrypt/auth failed",
+		authenticated: mc.deviceKey != nil,
+	}
+
+	var tsErr *timestampError
+	switch {
+	case errors.Is(err, ErrUnknownDevice):
+		r.class = rejectUnknownDevice
+	case errors.Is(err, ErrAuthFailed):
+		r.class = rejectAuthFailed
+	case errors.Is(err, ErrBadFrame):
+		r.class = rejectBadFrame
+		if r.authenticated {
+			r.status, r.reason, r.msg = StatusBadRequest, ReasonBadRequest, "invalid inner frame"
+		}
+	case errors.As(err, &tsErr):
+		r.class = rejectStaleTimestamp
+		if r.authenticated {
+			// The phone's clock is off: send the server time so it can
+			// correct its offset.
+			r.status, r.reason = StatusBadTimestamp, ReasonBadTimestamp
+			r.msg = fmt.Sprintf("timestamp outside window; server_time_unix=%d", tsErr.Now)
+			r.serverTime = tsErr.Now
+		}
+	case errors.Is(err, ErrReplay):
+		r.class = rejectReplay
+		if r.authenticated {
+			r.status, r.reason, r.msg = StatusReplay, ReasonReplay, "message already seen"
+		}
+	case errors.Is(err, ErrRateLimited):
+		r.class = rejectRateLimited
+		if r.authenticated {
+			r.status, r.reason, r.msg = StatusRateLimit, ReasonRateLimit, "too many requests; retry in a minute"
+		}
+	}
+	return r
+}
+
+var (
+	msgRejectMu     sync.Mutex
+	msgRejectCounts = make(map[string]uint64) // class -> count since start
+)
+
+func countMsgReject(class string) {
+	msgRejectMu.Lock()
+	msgRejectCounts[class]++
+	msgRejectMu.Unlock()
+}
+
+// msgRejectSnapshot copies the per-class rejection counters.
+func msgRejectSnapshot() map[string]uint64 {
+	msgRejectMu.Lock()
+	defer msgRejectMu.Unlock()
+	if len(msgRejectCounts) == 0 {
+		return nil
+	}
+	out := make(map[string]uint64, len(msgRejectCounts))
+	for k, v := range msgRejectCounts {
+		out[k] = v
+	}
+	return out
+}
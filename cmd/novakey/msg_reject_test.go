@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"filippo.io/mlkem768"
+)
+
+func TestClassifyMsgError_NoOracleBeforeAuth(t *testing.T) {
+	authed := msgContext{deviceKey: make([]byte, 32)}
+	cases := []struct {
+		err    error
+		mc     msgContext
+		class  string
+		status RespStatus
+	}{
+		{fmt.Errorf("%w: %q", ErrUnknownDevice, "x"), msgContext{}, rejectUnknownDevice, StatusCryptoFail},
+		{fmt.Errorf("%w: bad tag", ErrAuthFailed), msgContext{}, rejectAuthFailed, StatusCryptoFail},
+		{fmt.Errorf("%w: short", ErrBadFrame), msgContext{}, rejectBadFrame, StatusCryptoFail},
+		{fmt.Errorf("%w: short", ErrBadFrame), authed, rejectBadFrame, StatusBadRequest},
+		{&timestampError{TS: 1, Now: 1000}, authed, rejectStaleTimestamp, StatusBadTimestamp},
+		{fmt.Errorf("%w: seen", ErrReplay), authed, rejectReplay, StatusReplay},
+		{fmt.Errorf("%w: seen", ErrReplay), msgContext{}, rejectReplay, StatusCryptoFail},
+		{fmt.Errorf("%w for device", ErrRateLimited), authed, rejectRateLimited, StatusRateLimit},
+		{errors.New("crypto not initialized"), msgContext{}, rejectOther, StatusCryptoFail},
+	}
+	for _, c := range cases {
+		r := classifyMsgError(c.err, c.mc)
+		if r.class != c.class || r.status != c.status {
+			t.Errorf("%v (authed=%t): got class=%s status=%d, want %s/%d",
+				c.err, c.mc.deviceKey != nil, r.class, r.status, c.class, c.status)
+		}
+		if !r.authenticated && r.msg != "decrypt/auth failed" {
+			t.Errorf("%v: unauthenticated reply leaks detail: %q", c.err, r.msg)
+		}
+	}
+	if r := classifyMsgError(&timestampError{TS: 1, Now: 1000}, authed); r.serverTime != 1000 {
+		t.Fatalf("server time not set: %+v", r)
+	}
+}
+
+func TestDecryptOuter_UnknownDeviceVersusBadKey(t *testing.T) {
+	prevDK, prevPub, prevKID := serverDecapKey, serverEncapKey, serverKID
+	devicesMu.Lock()
+	prevDevices := devices
+	devices = map[string]deviceState{"known": {id: "known", staticKey: make([]byte, 32)}}
+	devicesMu.Unlock()
+	t.Cleanup(func() {
+		serverDecapKey, serverEncapKey, serverKID = prevDK, prevPub, prevKID
+		devicesMu.Lock()
+		devices = prevDevices
+		devicesMu.Unlock()
+	})
+
+	dk, err := mlkem768.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverDecapKey, serverEncapKey = dk, dk.EncapsulationKey()
+	serverKID = serverKeyID(serverEncapKey)
+
+	if _, _, _, err := decryptOuter(buildOuterHeader(protocolVersionV3, nil, "known")); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("known device, bad frame: %v", err)
+	}
+	if _, _, _, err := decryptOuter(buildOuterHeader(protocolVersionV3, nil, "stranger")); !errors.Is(err, ErrUnknownDevice) {
+		t.Fatalf("unknown device: %v", err)
+	}
+	// A v4 frame naming a server key that doesn't exist: the device check
+	// still decides the rejection.
+	badKID := []byte{suiteMLKEM768XChaCha, 0, 0xde, 0xad, 0xbe, 0xef}
+	if _, _, _, err := decryptOuter(buildOuterHeader(protocolVersionV4, badKID, "stranger")); !errors.Is(err, ErrUnknownDevice) {
+		t.Fatalf("unknown device, unknown kid: %v", err)
+	}
+	if _, _, _, err := decryptOuter(buildOuterHeader(protocolVersionV4, badKID, "known")); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("known device, unknown kid: %v", err)
+	}
+	if _, _, _, err := decryptOuter([]byte{9, 9, 9}); !errors.Is(err, ErrBadFrame) {
+		t.Fatalf("garbage: %v", err)
+	}
+}
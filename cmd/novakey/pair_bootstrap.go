@@ -17,7 +17,7 @@ func startPairingBootstrapIfUnpaired() {
 		return
 	}
 
-	host, port := splitHostPortOrDie(cfg.ListenAddr)
+	host, port := splitHostPortOrDie(currentConfig().ListenAddr)
 	advertiseHost := chooseAdvertiseHost(host)
 
 	tokenB64, tokenID, exp := startOrRefreshPairToken(10 * time.Minute)
@@ -76,10 +76,12 @@ func handlePairBootstrapTCP(conn net.Conn) error {
 		return err
 	}
 
+	cfg := currentConfig()
 	deviceID := "ios-" + randHex(8)
 	deviceKeyHex := randHex(32) // 32 bytes -> 64 hex chars
 
-	if err := writeDevicesFile(cfg.DevicesFile, deviceID, deviceKeyHex); err != nil {
+	paired := deviceMeta{PairedAtUnix: time.Now().Unix(), PairedFrom: remoteIP(conn)}
+	if err := writeDevicesFile(cfg.DevicesFile, deviceID, deviceKeyHex, paired); err != nil {
 		return fmt.Errorf("write devices: %w", err)
 	}
 	if err := reloadDevicesFromDisk(); err != nil {
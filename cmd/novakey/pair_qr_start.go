@@ -14,7 +14,7 @@ func maybeStartPairingQR() {
 		return
 	}
 
-	host, port := splitHostPortOrDie(cfg.ListenAddr)
+	host, port := splitHostPortOrDie(currentConfig().ListenAddr)
 	advertiseHost := chooseAdvertiseHost(host)
 
 	tokenB64, tokenID, exp := startOrRefreshPairToken(10 * time.Minute)
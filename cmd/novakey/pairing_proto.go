@@ -41,6 +41,11 @@ type pairRegister struct {
 	V            int    `json:"v"`
 	DeviceID     string `json:"device_id"`
 	DeviceKeyHex string `json:"device_key_hex"`
+
+	// Optional client description, stored as device metadata.
+	DeviceName string `json:"device_name,omitempty"`
+	Platform   string `json:"platform,omitempty"`
+	AppVersion string `json:"app_version,omitempty"`
 }
 
 // --- per-IP hello limiter (in-memory, per-uptime) ---
@@ -50,7 +55,7 @@ var (
 )
 
 func allowPairHelloFromIP(ip string) bool {
-	limit := cfg.PairHelloMaxPerMin
+	limit := currentConfig().PairHelloMaxPerMin
 	if limit <= 0 {
 		limit = 30
 	}
@@ -124,7 +129,7 @@ func handlePairConn(conn net.Conn) error {
     resp := pairServerKey{
         Op:          "server_key",
         V:           1,
-        KID:         "1",
+        KID:         serverKID,
         KyberPubB64: base64.StdEncoding.EncodeToString(serverEncapKey),
         FP16Hex:     fp16,
         ExpiresUnix: time.Now().Add(2 * time.Minute).Unix(),
@@ -180,6 +185,7 @@ func handlePairConn(conn net.Conn) error {
         reg.DeviceID = "ios-" + randHex(8)
     }
 
+    cfg := currentConfig()
     if cfg.RotateDevicePSKOnRepair {
         devicesMu.RLock()
         _, exists := devices[reg.DeviceID]
@@ -193,7 +199,14 @@ func handlePairConn(conn net.Conn) error {
         reg.DeviceKeyHex = randHex(32)
     }
 
-    if err := writeDevicesFile(cfg.DevicesFile, reg.DeviceID, reg.DeviceKeyHex); err != nil {
+    paired := deviceMeta{
+        Label:        cleanMetaString(reg.DeviceName),
+        PairedAtUnix: time.Now().Unix(),
+        PairedFrom:   remoteIP(conn),
+        Platform:     cleanMetaString(reg.Platform),
+        AppVersion:   cleanMetaString(reg.AppVersion),
+    }
+    if err := writeDevicesFile(cfg.DevicesFile, reg.DeviceID, reg.DeviceKeyHex, paired); err != nil {
         return fmt.Errorf("write devices: %w", err)
     }
     if err := reloadDevicesFromDisk(); err != nil {
@@ -0,0 +1,118 @@
+// cmd/novakey/policy_profile.go
+package main
+
+import (
+	"fmt"
+)
+
+// PolicyProfile overrides injection policy for the devices assigned to it.
+// Unset fields (nil) inherit the global value from ServerConfig.
+//
+// Target lists replace the global lists when set; an empty list ([]) clears
+// them for this profile.
+type PolicyProfile struct {
+	MaxInjectLen  *int  `json:"max_inject_len" yaml:"max_inject_len"`
+	AllowNewlines *bool `json:"allow_newlines" yaml:"allow_newlines"`
+
+	TwoManEnabled          *bool    `json:"two_man_enabled" yaml:"two_man_enabled"`
+	TwoManMode             *string  `json:"two_man_mode" yaml:"two_man_mode"`
+	ApproveConsumeOnInject *bool    `json:"approve_consume_on_inject" yaml:"approve_consume_on_inject"`
+	ArmConsumeOnInject     *bool    `json:"arm_consume_on_inject" yaml:"arm_consume_on_inject"`
+	ApprovalQuorum         *int     `json:"approval_quorum" yaml:"approval_quorum"`
+	ApproverGroup          []string `json:"approver_group" yaml:"approver_group"`
+	RequestScopedApprove   *bool    `json:"request_scoped_approve" yaml:"request_scoped_approve"`
+
+	TargetPolicyEnabled *bool    `json:"target_policy_enabled" yaml:"target_policy_enabled"`
+	UseBuiltInAllowlist *bool    `json:"use_built_in_allowlist" yaml:"use_built_in_allowlist"`
+	AllowedProcessNames []string `json:"allowed_process_names" yaml:"allowed_process_names"`
+	AllowedWindowTitles []string `json:"allowed_window_titles" yaml:"allowed_window_titles"`
+	DeniedProcessNames  []string `json:"denied_process_names" yaml:"denied_process_names"`
+	DeniedWindowTitles  []string `json:"denied_window_titles" yaml:"denied_window_titles"`
+}
+
+// deviceProfileName returns the profile stored with a paired device ("" if none).
+func deviceProfileName(deviceID string) string {
+	devicesMu.RLock()
+	defer devicesMu.RUnlock()
+	return devices[deviceID].profile
+}
+
+// policyForDevice returns the effective config for deviceID: cfg with the
+// device's profile (or default_profile) applied. The result is a copy; cfg is
+// never modified. An unknown profile name is an error so a typo can't silently
+// fall back to the (possibly looser) global policy.
+func policyForDevice(cfg *ServerConfig, deviceID string) (*ServerConfig, string, error) {
+	name := deviceProfileName(deviceID)
+	if name == "" {
+		name = cfg.DefaultProfile
+	}
+	if name == "" {
+		return cfg, "", nil
+	}
+
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return nil, name, fmt.Errorf("device %q uses unknown policy profile %q", deviceID, name)
+	}
+	return p.apply(cfg), name, nil
+}
+
+func (p PolicyProfile) apply(cfg *ServerConfig) *ServerConfig {
+	eff := *cfg
+
+	if p.MaxInjectLen != nil {
+		eff.MaxInjectLen = *p.MaxInjectLen
+	}
+	if p.AllowNewlines != nil {
+		eff.AllowNewlines = *p.AllowNewlines
+	}
+	if p.TwoManEnabled != nil {
+		eff.TwoManEnabled = p.TwoManEnabled
+	}
+	if p.TwoManMode != nil {
+		eff.TwoManMode = *p.TwoManMode
+	}
+	if p.ApproveConsumeOnInject != nil {
+		eff.ApproveConsumeOnInject = p.ApproveConsumeOnInject
+	}
+	if p.ApprovalQuorum != nil {
+		eff.ApprovalQuorum = *p.ApprovalQuorum
+	}
+	if p.ApproverGroup != nil {
+		eff.ApproverGroup = p.ApproverGroup
+	}
+	if p.RequestScopedApprove != nil {
+		eff.RequestScopedApprove = *p.RequestScopedApprove
+	}
+	if p.ArmConsumeOnInject != nil {
+		eff.ArmConsumeOnInject = p.ArmConsumeOnInject
+	}
+
+	if p.TargetPolicyEnabled != nil {
+		eff.TargetPolicyEnabled = *p.TargetPolicyEnabled
+	}
+	if p.UseBuiltInAllowlist != nil {
+		eff.UseBuiltInAllowlist = *p.UseBuiltInAllowlist
+	}
+	if p.AllowedProcessNames != nil {
+		eff.AllowedProcessNames = p.AllowedProcessNames
+	}
+	if p.AllowedWindowTitles != nil {
+		eff.AllowedWindowTitles = p.AllowedWindowTitles
+	}
+	if p.DeniedProcessNames != nil {
+		eff.DeniedProcessNames = p.DeniedProcessNames
+	}
+	if p.DeniedWindowTitles != nil {
+		eff.DeniedWindowTitles = p.DeniedWindowTitles
+	}
+
+	// Same fallback as applyDefaults: target policy with no rules at all uses
+	// the built-in allowlist unless the profile says otherwise.
+	if eff.TargetPolicyEnabled && !eff.UseBuiltInAllowlist && p.UseBuiltInAllowlist == nil &&
+		len(eff.AllowedProcessNames) == 0 && len(eff.AllowedWindowTitles) == 0 &&
+		len(eff.DeniedProcessNames) == 0 && len(eff.DeniedWindowTitles) == 0 {
+		eff.UseBuiltInAllowlist = true
+	}
+	return &eff
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func withTestDevices(t *testing.T, m map[string]deviceState) {
+	t.Helper()
+	devicesMu.Lock()
+	prev := devices
+	devices = m
+	devicesMu.Unlock()
+	t.Cleanup(func() {
+		devicesMu.Lock()
+		devices = prev
+		devicesMu.Unlock()
+	})
+}
+
+func TestPolicyForDevice_AppliesProfile(t *testing.T) {
+	withTestDevices(t, map[string]deviceState{
+		"team":     {id: "team", profile: "browsers"},
+		"personal": {id: "personal"},
+	})
+
+	max := 64
+	off := false
+	cfg := &ServerConfig{
+		MaxPayloadLen:       4096,
+		MaxInjectLen:        256,
+		AllowedProcessNames: []string{"wezterm"},
+		Profiles: map[string]PolicyProfile{
+			"browsers": {
+				MaxInjectLen:        &max,
+				TwoManEnabled:       &off,
+				AllowedProcessNames: []string{"firefox", "chrome"},
+			},
+		},
+	}
+	applyDefaults(cfg)
+
+	eff, name, err := policyForDevice(cfg, "team")
+	if err != nil || name != "browsers" {
+		t.Fatalf("policyForDevice(team) = %q, %v", name, err)
+	}
+	if eff.MaxInjectLen != 64 || boolDeref(eff.TwoManEnabled, true) {
+		t.Fatalf("profile not applied: max_inject_len=%d two_man=%v", eff.MaxInjectLen, boolDeref(eff.TwoManEnabled, true))
+	}
+	if err := validateInjectText(eff, strings.Repeat("x", 100)); err == nil {
+		t.Fatal("expected profile max_inject_len to reject 100 bytes")
+	}
+	if len(eff.AllowedProcessNames) != 2 {
+		t.Fatalf("allowed_process_names=%v", eff.AllowedProcessNames)
+	}
+
+	// The shared snapshot must be untouched.
+	if cfg.MaxInjectLen != 256 || !boolDeref(cfg.TwoManEnabled, false) {
+		t.Fatal("policyForDevice modified the global config")
+	}
+
+	eff, name, err = policyForDevice(cfg, "personal")
+	if err != nil || name != "" || eff != cfg {
+		t.Fatalf("policyForDevice(personal) = %q, %v", name, err)
+	}
+}
+
+func TestPolicyForDevice_UnknownProfileFailsClosed(t *testing.T) {
+	withTestDevices(t, map[string]deviceState{
+		"phone": {id: "phone", profile: "typo"},
+	})
+
+	cfg := &ServerConfig{}
+	applyDefaults(cfg)
+
+	if _, _, err := policyForDevice(cfg, "phone"); err == nil {
+		t.Fatal("expected error for unknown profile")
+	}
+}
@@ -0,0 +1,279 @@
+// cmd/novakey/quorum.go
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// quorumGate holds pending, request-scoped approvals. It backs both
+// approval_quorum (M distinct approvers out of a group) and
+// request_scoped_approve (one approval for one exact inject).
+//
+// Approvals are bound to a pending request, not to a time window: the first
+// gated inject from a device opens a pending request for that exact payload,
+// approvers then approve it, and a retry of the same inject is released once
+// the quorum is met. A different payload from the same injector replaces the
+// pending request and discards its approvals.
+type quorumGate struct {
+	mu      sync.Mutex
+	pending map[string]*pendingQuorum // injector deviceID -> request
+}
+
+type pendingQuorum struct {
+	id          string
+	injector    string
+	fingerprint [32]byte
+	need        int
+	group       []string
+	approvedBy  map[string]time.Time
+	expires     time.Time
+	scoped      bool // request_scoped_approve: released only by its request_id
+}
+
+// quorumStatus describes a pending request to callers (and clients).
+type quorumStatus struct {
+	ID          string // pending request ID (sent as pending_id)
+	Fingerprint string // short keyed payload fingerprint (sent as fingerprint)
+	Injector    string
+	Got, Need   int
+}
+
+// approvePayload is the optional MsgTypeApprove payload.
+type approvePayload struct {
+	RequestID string `json:"request_id"`
+	Injector  string `json:"injector"` // device the approval window is for
+}
+
+// maxPendingQuorums bounds the table. There is at most one pending request per
+// injector, so this only matters with very many paired devices.
+const maxPendingQuorums = 256
+
+var (
+	errPendingNotFound = errors.New("unknown or expired request_id")
+	errNotInGroup      = errors.New("device may not approve this request")
+)
+
+func (p *pendingQuorum) status() quorumStatus {
+	return quorumStatus{
+		ID:          p.id,
+		Fingerprint: hex.EncodeToString(p.fingerprint[:8]),
+		Injector:    p.injector,
+		Got:         len(p.approvedBy),
+		Need:        p.need,
+	}
+}
+
+func (p *pendingQuorum) mayApprove(deviceID string) bool {
+	return stringInSlice(deviceID, p.group)
+}
+
+func newQuorumGate() *quorumGate {
+	return &quorumGate{pending: make(map[string]*pendingQuorum)}
+}
+
+// Global gate instance
+var quorum = newQuorumGate()
+
+// fingerprintKey keys payload fingerprints so they can't be used to confirm
+// a guessed secret. It only needs to live as long as the process.
+var fingerprintKey = func() []byte {
+	k := make([]byte, 32)
+	if _, err := rand.Read(k); err != nil {
+		panic(fmt.Sprintf("fingerprint key: %v", err))
+	}
+	return k
+}()
+
+func newPendingID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("pending id: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+func payloadFingerprint(payload []byte) [32]byte {
+	m := hmac.New(sha256.New, fingerprintKey)
+	m.Write(payload)
+	var fp [32]byte
+	copy(fp[:], m.Sum(nil))
+	return fp
+}
+
+// Check reports whether the inject (injector, fp) has reached its quorum.
+// If no matching pending request exists, one is opened with the given group,
+// quorum and window. A scoped request can only be approved by its ID.
+func (g *quorumGate) Check(injector string, fp [32]byte, group []string, need int, window time.Duration, consume, scoped bool) (quorumStatus, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	g.cleanupLocked(now)
+
+	p := g.pending[injector]
+	if p == nil || !hmac.Equal(p.fingerprint[:], fp[:]) {
+		if p == nil && len(g.pending) >= maxPendingQuorums {
+			g.evictSoonestLocked()
+		}
+		p = &pendingQuorum{
+			id:          newPendingID(),
+			injector:    injector,
+			fingerprint: fp,
+			need:        need,
+			group:       group,
+			approvedBy:  make(map[string]time.Time),
+			expires:     now.Add(window),
+			scoped:      scoped,
+		}
+		g.pending[injector] = p
+		return p.status(), false
+	}
+
+	st := p.status()
+	if st.Got < p.need {
+		return st, false
+	}
+	if consume {
+		delete(g.pending, injector)
+	}
+	return st, true
+}
+
+// Approve records approverID's approval on the one pending request it may
+// approve. matched is 0 when approverID has nothing pending (the caller falls
+// back to a plain two-man approval); more than one match is ambiguous and
+// nothing is recorded. Scoped requests are skipped: they need ApproveRequest.
+func (g *quorumGate) Approve(approverID string) (st quorumStatus, matched int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cleanupLocked(time.Now())
+
+	var hit *pendingQuorum
+	for _, p := range g.pending {
+		if p.scoped || !p.mayApprove(approverID) {
+			continue
+		}
+		matched++
+		hit = p
+	}
+	if matched != 1 {
+		return quorumStatus{}, matched
+	}
+
+	hit.approvedBy[approverID] = time.Now()
+	return hit.status(), 1
+}
+
+// ApproveRequest records approverID's approval on the pending request with
+// the given ID.
+func (g *quorumGate) ApproveRequest(approverID, id string) (quorumStatus, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cleanupLocked(time.Now())
+
+	for _, p := range g.pending {
+		if !hmac.Equal([]byte(p.id), []byte(id)) {
+			continue
+		}
+		if !p.mayApprove(approverID) {
+			return p.status(), errNotInGroup
+		}
+		p.approvedBy[approverID] = time.Now()
+		return p.status(), nil
+	}
+	return quorumStatus{}, errPendingNotFound
+}
+
+// ScopedPending returns how many scoped requests approverID may approve.
+func (g *quorumGate) ScopedPending(approverID string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cleanupLocked(time.Now())
+
+	n := 0
+	for _, p := range g.pending {
+		if p.scoped && p.mayApprove(approverID) {
+			n++
+		}
+	}
+	return n
+}
+
+// Progress returns injector's pending request, if any.
+func (g *quorumGate) Progress(injector string) (quorumStatus, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cleanupLocked(time.Now())
+
+	if p := g.pending[injector]; p != nil {
+		return p.status(), true
+	}
+	return quorumStatus{}, false
+}
+
+// Forget drops deviceID's pending request and any approvals it gave.
+func (g *quorumGate) Forget(deviceID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.pending, deviceID)
+	for _, p := range g.pending {
+		delete(p.approvedBy, deviceID)
+	}
+}
+
+func (g *quorumGate) evictSoonestLocked() {
+	var victim string
+	var soonest time.Time
+	for id, p := range g.pending {
+		if victim == "" || p.expires.Before(soonest) {
+			victim, soonest = id, p.expires
+		}
+	}
+	delete(g.pending, victim)
+}
+
+func (g *quorumGate) cleanupLocked(now time.Time) {
+	for id, p := range g.pending {
+		if now.After(p.expires) {
+			delete(g.pending, id)
+		}
+	}
+}
+
+// pendingApprovalRule returns who may approve injectorID's pending request
+// and how many approvals it needs: approval_quorum distinct devices from the
+// quorum group, or (request_scoped_approve alone) one approval from the
+// devices that two_man_mode allows.
+func pendingApprovalRule(cfg *ServerConfig, injectorID string) (group []string, need int) {
+	if cfg.ApprovalQuorum > 0 {
+		return quorumGroup(cfg, injectorID), cfg.ApprovalQuorum
+	}
+	return approversFor(injectorID, cfg.TwoManMode), 1
+}
+
+// quorumGroup returns the devices that may approve injectorID's injects under
+// a quorum: approver_group if configured, otherwise every other paired device
+// whose role allows approving. The injector never counts toward its own quorum.
+func quorumGroup(cfg *ServerConfig, injectorID string) []string {
+	if len(cfg.ApproverGroup) == 0 {
+		return approversFor(injectorID, TwoManModeDual)
+	}
+
+	out := make([]string, 0, len(cfg.ApproverGroup))
+	for _, id := range cfg.ApproverGroup {
+		if id == injectorID {
+			continue
+		}
+		if st, ok := lookupDevice(id); ok && st.canApprove() {
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQuorumGate_TwoOfThree(t *testing.T) {
+	g := newQuorumGate()
+	group := []string{"a", "b", "c"}
+	fp := payloadFingerprint([]byte("break-glass"))
+
+	if q, ok := g.Check("injector", fp, group, 2, time.Minute, true, false); ok || q.Got != 0 {
+		t.Fatalf("first inject: got=%d ok=%v", q.Got, ok)
+	}
+
+	// The injector and devices outside the group don't count.
+	if _, matched := g.Approve("injector"); matched != 0 {
+		t.Fatal("injector approved its own request")
+	}
+	if _, matched := g.Approve("outsider"); matched != 0 {
+		t.Fatal("outsider approved the request")
+	}
+
+	if q, matched := g.Approve("a"); matched != 1 || q.Injector != "injector" || q.Got != 1 || q.Need != 2 {
+		t.Fatalf("approve a: %+v matched=%d", q, matched)
+	}
+	// Repeat approvals from the same device are counted once.
+	g.Approve("a")
+	if q, ok := g.Check("injector", fp, group, 2, time.Minute, true, false); ok || q.Got != 1 {
+		t.Fatalf("after a: got=%d ok=%v", q.Got, ok)
+	}
+
+	g.Approve("b")
+	if q, ok := g.Check("injector", fp, group, 2, time.Minute, true, false); !ok || q.Got != 2 {
+		t.Fatalf("after b: got=%d ok=%v", q.Got, ok)
+	}
+
+	// Consumed: the approvals can't be reused.
+	if _, ok := g.Check("injector", fp, group, 2, time.Minute, true, false); ok {
+		t.Fatal("quorum reused after consume")
+	}
+}
+
+func TestQuorumGate_BoundToPayload(t *testing.T) {
+	g := newQuorumGate()
+	group := []string{"a"}
+
+	g.Check("injector", payloadFingerprint([]byte("secret-1")), group, 1, time.Minute, true, false)
+	g.Approve("a")
+
+	// A different payload replaces the pending request and its approvals.
+	if _, ok := g.Check("injector", payloadFingerprint([]byte("secret-2")), group, 1, time.Minute, true, false); ok {
+		t.Fatal("approval for one payload released another")
+	}
+}
+
+func TestQuorumGate_AmbiguousApprove(t *testing.T) {
+	g := newQuorumGate()
+	g.Check("x", payloadFingerprint([]byte("1")), []string{"a"}, 1, time.Minute, true, false)
+	g.Check("y", payloadFingerprint([]byte("2")), []string{"a"}, 1, time.Minute, true, false)
+
+	if _, matched := g.Approve("a"); matched != 2 {
+		t.Fatalf("matched=%d, want 2", matched)
+	}
+	if q, _ := g.Progress("x"); q.Got != 0 {
+		t.Fatal("ambiguous approve was recorded")
+	}
+}
+
+func TestQuorumGate_ApproveRequestReleasesOnlyThatInject(t *testing.T) {
+	g := newQuorumGate()
+	fpX := payloadFingerprint([]byte("1"))
+	qx, _ := g.Check("x", fpX, []string{"a"}, 1, time.Minute, true, false)
+	g.Check("y", payloadFingerprint([]byte("2")), []string{"a"}, 1, time.Minute, true, false)
+
+	if qx.ID == "" || len(qx.Fingerprint) != 16 {
+		t.Fatalf("pending status missing id/fingerprint: %+v", qx)
+	}
+
+	if _, err := g.ApproveRequest("b", qx.ID); !errors.Is(err, errNotInGroup) {
+		t.Fatalf("approve from outside group: err=%v", err)
+	}
+	if _, err := g.ApproveRequest("a", "0000000000000000"); !errors.Is(err, errPendingNotFound) {
+		t.Fatalf("approve unknown id: err=%v", err)
+	}
+	if q, err := g.ApproveRequest("a", qx.ID); err != nil || q.Injector != "x" || q.Got != 1 {
+		t.Fatalf("ApproveRequest = %+v, %v", q, err)
+	}
+
+	if _, ok := g.Check("x", fpX, []string{"a"}, 1, time.Minute, true, false); !ok {
+		t.Fatal("approved request was not released")
+	}
+	if q, _ := g.Progress("y"); q.Got != 0 {
+		t.Fatal("approval leaked to another pending request")
+	}
+}
+
+func TestQuorumGate_Bounded(t *testing.T) {
+	g := newQuorumGate()
+	for i := 0; i < maxPendingQuorums+10; i++ {
+		g.Check(fmt.Sprintf("dev-%d", i), payloadFingerprint([]byte{byte(i)}), nil, 1, time.Minute, true, false)
+	}
+	if n := len(g.pending); n > maxPendingQuorums {
+		t.Fatalf("pending table grew to %d", n)
+	}
+}
+
+func TestQuorumGate_ForgetDropsRevokedApprover(t *testing.T) {
+	g := newQuorumGate()
+	fp := payloadFingerprint([]byte("1"))
+	g.Check("x", fp, []string{"a", "b"}, 2, time.Minute, true, false)
+	g.Approve("a")
+	g.Approve("b")
+
+	g.Forget("b")
+	if _, ok := g.Check("x", fp, []string{"a", "b"}, 2, time.Minute, true, false); ok {
+		t.Fatal("approval from a revoked device still counted")
+	}
+
+	g.Forget("x")
+	if _, ok := g.Progress("x"); ok {
+		t.Fatal("revoked injector's pending request survived")
+	}
+}
+
+func TestQuorumGate_ScopedNeedsRequestID(t *testing.T) {
+	g := newQuorumGate()
+	fp := payloadFingerprint([]byte("secret"))
+
+	q, _ := g.Check("injector", fp, []string{"a"}, 1, time.Minute, true, true)
+
+	// Without request_id the scoped request is neither approved nor ambiguous.
+	if _, matched := g.Approve("a"); matched != 0 {
+		t.Fatalf("approve without request_id matched %d scoped requests", matched)
+	}
+	if n := g.ScopedPending("a"); n != 1 {
+		t.Fatalf("ScopedPending = %d, want 1", n)
+	}
+	if _, ok := g.Check("injector", fp, []string{"a"}, 1, time.Minute, true, true); ok {
+		t.Fatal("scoped request released without its request_id")
+	}
+
+	if _, err := g.ApproveRequest("a", q.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.Check("injector", fp, []string{"a"}, 1, time.Minute, true, true); !ok {
+		t.Fatal("scoped request not released by its request_id")
+	}
+}
@@ -0,0 +1,151 @@
+// cmd/novakey/rekey.go
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// In-band device key rotation (MsgTypeRekey).
+//
+// The request is authenticated under the device's current key like any other
+// message. Its payload either proposes the new key ({"new_key_hex":"..."}) or
+// is empty, in which case the server generates one and returns it sealed in
+// the reply (new_key_sealed). The old key stays valid for rekeyGracePeriod so
+// a phone that lost the reply can still talk to the daemon and retry.
+
+const rekeyGracePeriod = 10 * time.Minute
+
+// rekeyPayload is the optional MsgTypeRekey payload.
+type rekeyPayload struct {
+	NewKeyHex string `json:"new_key_hex,omitempty"`
+}
+
+var errRekeyConflict = errors.New("device key changed concurrently")
+
+// rekeyNewKey returns the key to rotate to: the one proposed in payload, or
+// a fresh random key (generated=true) when the payload is empty.
+func rekeyNewKey(payload, currentKey []byte) (key []byte, generated bool, err error) {
+	var p rekeyPayload
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return nil, false, fmt.Errorf("invalid rekey payload")
+		}
+	}
+
+	if p.NewKeyHex == "" {
+		key = make([]byte, chacha20poly1305.KeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, false, fmt.Errorf("generate key: %w", err)
+		}
+		return key, true, nil
+	}
+
+	key, err = hex.DecodeString(p.NewKeyHex)
+	if err != nil || len(key) != chacha20poly1305.KeySize {
+		return nil, false, fmt.Errorf("new_key_hex must be %d bytes of hex", chacha20poly1305.KeySize)
+	}
+	if constTimeEq(key, currentKey) || constTimeEq(key, make([]byte, len(key))) {
+		return nil, false, fmt.Errorf("new_key_hex must be a new, non-zero key")
+	}
+	return key, false, nil
+}
+
+// rotateDeviceKey swaps deviceID's key in the store and reloads it. usedKey
+// is the key the rekey request authenticated under; viaPrev means that was
+// the previous key (the phone never learned the current one, so it is
+// replaced and the phone's key keeps its grace period).
+func rotateDeviceKey(path, deviceID string, usedKey, newKey []byte, viaPrev bool) (graceUntil time.Time, err error) {
+	unlock, err := lockDevicesStore(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	graceUntil, err = swapDeviceKey(path, deviceID, usedKey, newKey, viaPrev)
+	unlock()
+	if err != nil {
+		return time.Time{}, err
+	}
+	// After unlocking: the reload may need the lock to stamp key creation times.
+	return graceUntil, reloadDevicesFromDisk()
+}
+
+// swapDeviceKey is rotateDeviceKey's store update; the caller holds the lock.
+func swapDeviceKey(path, deviceID string, usedKey, newKey []byte, viaPrev bool) (graceUntil time.Time, err error) {
+	m, err := loadDevicesFromDisk(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	st, ok := m[deviceID]
+	if !ok {
+		return time.Time{}, fmt.Errorf("device %q not in store", deviceID)
+	}
+
+	if st, graceUntil, err = rekeyState(st, usedKey, newKey, viaPrev, time.Now()); err != nil {
+		return time.Time{}, err
+	}
+	m[deviceID] = st
+
+	if err := saveDevicesToDisk(path, devicesFileFromMap(m)); err != nil {
+		return time.Time{}, err
+	}
+	return graceUntil, nil
+}
+
+// rekeyState returns st with newKey as its current key and when its previous
+// key stops being accepted. A rekey under the current key retires it for
+// rekeyGracePeriod. A rekey under the previous key only replaces the current
+// key: the grace deadline stays where it was, or a retired key could keep
+// itself alive by rekeying again before it ran out.
+func rekeyState(st deviceState, usedKey, newKey []byte, viaPrev bool, now time.Time) (deviceState, time.Time, error) {
+	if viaPrev {
+		if !constTimeEq(st.prevKey, usedKey) {
+			return st, time.Time{}, errRekeyConflict
+		}
+		st.staticKey = newKey
+	} else {
+		if !constTimeEq(st.staticKey, usedKey) {
+			return st, time.Time{}, errRekeyConflict
+		}
+		st.prevKey, st.staticKey = st.staticKey, newKey
+		st.prevKeyUntil = now.Add(rekeyGracePeriod).Unix()
+	}
+	st.keyCreated, st.expiresAt = now.Unix(), 0
+	return st, time.Unix(st.prevKeyUntil, 0), nil
+}
+
+// sealRekeyKey encrypts a server-generated key for the requesting device.
+// The wrapping key is bound to this message's KEM secret and the key the
+// request authenticated under; the AAD binds the reply to the request nonce.
+//
+//	wrapKey = HKDF-SHA256(ikm=sharedKem, salt=deviceKey, info="NovaKey v3 rekey wrap")
+//	new_key_sealed = base64(nonce(24) || XChaCha20-Poly1305(wrapKey, nonce, newKey, AAD))
+//	AAD = "NovaKey v3 rekey" || request nonce
+func sealRekeyKey(mc msgContext, newKey []byte) (string, error) {
+	h := hkdf.New(sha256.New, mc.sharedKem, mc.deviceKey, []byte("NovaKey v3 rekey wrap"))
+	wrapKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(h, wrapKey); err != nil {
+		return "", fmt.Errorf("hkdf rekey wrap: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(wrapKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("rand nonce: %w", err)
+	}
+	aad := append([]byte("NovaKey v3 rekey"), mc.nonce...)
+	out := aead.Seal(nonce, nonce, newKey, aad)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+func TestRekeyNewKey(t *testing.T) {
+	cur := bytes.Repeat([]byte{7}, 32)
+
+	key, generated, err := rekeyNewKey(nil, cur)
+	if err != nil || !generated || len(key) != 32 || bytes.Equal(key, cur) {
+		t.Fatalf("generated: key=%x generated=%v err=%v", key, generated, err)
+	}
+
+	want := bytes.Repeat([]byte{9}, 32)
+	key, generated, err = rekeyNewKey([]byte(`{"new_key_hex":"`+hex.EncodeToString(want)+`"}`), cur)
+	if err != nil || generated || !bytes.Equal(key, want) {
+		t.Fatalf("proposed: key=%x generated=%v err=%v", key, generated, err)
+	}
+
+	for _, bad := range []string{
+		`not json`,
+		`{"new_key_hex":"abcd"}`,
+		`{"new_key_hex":"` + hex.EncodeToString(cur) + `"}`,
+		`{"new_key_hex":"` + strings.Repeat("00", 32) + `"}`,
+	} {
+		if _, _, err := rekeyNewKey([]byte(bad), cur); err == nil {
+			t.Errorf("payload %s accepted", bad)
+		}
+	}
+}
+
+func TestSealRekeyKey_OpensForSender(t *testing.T) {
+	mc := msgContext{
+		sharedKem: bytes.Repeat([]byte{1}, 32),
+		deviceKey: bytes.Repeat([]byte{2}, 32),
+		nonce:     bytes.Repeat([]byte{3}, 24),
+	}
+	newKey := bytes.Repeat([]byte{4}, 32)
+
+	sealedB64, err := sealRekeyKey(mc, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(sealedB64)
+
+	open := func(mc msgContext) ([]byte, error) {
+		h := hkdf.New(sha256.New, mc.sharedKem, mc.deviceKey, []byte("NovaKey v3 rekey wrap"))
+		wrapKey := make([]byte, 32)
+		_, _ = io.ReadFull(h, wrapKey)
+		aead, _ := chacha20poly1305.NewX(wrapKey)
+		aad := append([]byte("NovaKey v3 rekey"), mc.nonce...)
+		return aead.Open(nil, sealed[:24], sealed[24:], aad)
+	}
+
+	got, err := open(mc)
+	if err != nil || !bytes.Equal(got, newKey) {
+		t.Fatalf("open: %x, %v", got, err)
+	}
+
+	// Bound to the request nonce.
+	other := mc
+	other.nonce = bytes.Repeat([]byte{5}, 24)
+	if _, err := open(other); err == nil {
+		t.Fatal("sealed key opened under a different request nonce")
+	}
+}
+
+func TestDeviceRecord_DropsPrevKeyAfterGrace(t *testing.T) {
+	st := deviceState{
+		id:           "phone",
+		staticKey:    bytes.Repeat([]byte{1}, 32),
+		prevKey:      bytes.Repeat([]byte{2}, 32),
+		prevKeyUntil: time.Now().Add(time.Minute).Unix(),
+	}
+	if rec := st.record(); rec.PrevKeyHex == "" {
+		t.Fatal("prev key dropped during grace")
+	}
+	st.prevKeyUntil = time.Now().Add(-time.Minute).Unix()
+	if rec := st.record(); rec.PrevKeyHex != "" || rec.PrevKeyUntilUnix != 0 {
+		t.Fatalf("prev key kept after grace: %+v", rec)
+	}
+}
+
+func TestRekeyState_PrevKeyCannotExtendGrace(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	st := deviceState{id: "phone", staticKey: oldKey}
+	start := time.Now()
+
+	st, until, err := rekeyState(st, oldKey, bytes.Repeat([]byte{2}, 32), false, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.Add(rekeyGracePeriod).Unix(); until.Unix() != want || st.prevKeyUntil != want {
+		t.Fatalf("grace until %d (stored %d), want %d", until.Unix(), st.prevKeyUntil, want)
+	}
+
+	// The retired key rekeys again and again just before its grace runs out.
+	for i := byte(3); i < 8; i++ {
+		now := time.Unix(st.prevKeyUntil, 0).Add(-time.Second)
+		next, again, err := rekeyState(st, oldKey, bytes.Repeat([]byte{i}, 32), true, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.Unix() != until.Unix() || next.prevKeyUntil != st.prevKeyUntil {
+			t.Fatalf("rekey %d via previous key moved grace to %d, want %d", i, again.Unix(), until.Unix())
+		}
+		st = next
+	}
+
+	// A rekey under the current key starts a new grace period for it.
+	now := until.Add(time.Hour)
+	st, _, err = rekeyState(st, st.staticKey, bytes.Repeat([]byte{9}, 32), false, now)
+	if err != nil || st.prevKeyUntil != now.Add(rekeyGracePeriod).Unix() {
+		t.Fatalf("prevKeyUntil=%d err=%v", st.prevKeyUntil, err)
+	}
+}
@@ -0,0 +1,351 @@
+// cmd/novakey/replay_journal.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Replay journal (replay_journal_file).
+//
+// Nonces accepted by validateFreshnessAndRate are appended to the journal and
+// loaded back at startup, so a frame captured before a restart or crash is
+// still rejected after it. The first line is a header holding a random
+// journal key, sealed like the device store (keyring key or DPAPI, see
+// sealJournalKey); each following line is one record sealed under that key.
+// Records older than replay_cache_ttl_sec are dropped whenever the journal is
+// compacted: at startup and every replayJournalCompactEvery appends.
+//
+// Records are chained: each one's AAD holds the tag of the record before it
+// (the first one's, a hash of the header), so a record removed, reordered or
+// copied from another journal breaks every record after it and the journal
+// is refused at startup. What the chain can't show is records cut off the
+// end, which looks the same as a crash; deleting the whole file is also
+// not detected. An unsealed journal ("none") has no integrity at all.
+//
+// Appends are written at once but fsynced at most every
+// replayJournalSyncDelay, so a power loss can drop the last few records; a
+// daemon crash drops none.
+
+const (
+	replayJournalVersion      = 1
+	replayJournalRecordAAD    = "NovaKey replay journal v1"
+	replayJournalCompactEvery = 4096
+	replayJournalSyncDelay    = 100 * time.Millisecond
+)
+
+type replayJournalHeader struct {
+	V      int    `json:"v"`
+	Alg    string `json:"alg"`               // record cipher: "xchacha20poly1305", or "none" if the key can't be sealed
+	KeyB64 string `json:"key_b64,omitempty"` // journal key, sealed by sealJournalKey
+}
+
+type replayRecord struct {
+	DeviceID string `json:"d"`
+	Nonce    string `json:"n"` // hex, as in replayCache
+	SeenAt   int64  `json:"t"`
+}
+
+type replayJournal struct {
+	path    string
+	f       *os.File
+	aead    cipher.AEAD // nil when the journal is not sealed
+	chain   []byte      // tag of the last record written (see sealRecord)
+	appends int
+
+	syncPending bool // a flush is scheduled
+}
+
+// journal is the open replay journal, or nil. Guarded by replayMu.
+var journal *replayJournal
+
+// openReplayJournal loads path into replayCache, compacts it and keeps it
+// open for appends. An empty path disables the journal.
+func openReplayJournal(path string) error {
+	if path == "" {
+		return nil
+	}
+	now := time.Now().Unix()
+	_, _, replayTTL := freshnessWindows(currentConfig())
+
+	recs, dropped, err := readReplayJournal(path)
+	if err != nil {
+		return err
+	}
+
+	replayMu.Lock()
+	defer replayMu.Unlock()
+
+	kept := recs[:0]
+	for _, r := range recs {
+		if now-r.SeenAt > replayTTL {
+			continue
+		}
+		m := replayCache[r.DeviceID]
+		if m == nil {
+			m = make(map[string]int64)
+			replayCache[r.DeviceID] = m
+		}
+		m[r.Nonce] = r.SeenAt
+		kept = append(kept, r)
+	}
+
+	j := &replayJournal{path: path}
+	if err := j.rewrite(kept); err != nil {
+		return err
+	}
+	journal = j
+
+	abs, _ := filepath.Abs(path)
+	if dropped > 0 {
+		log.Printf("[replay] skipped %d unreadable journal record(s) in %s", dropped, abs)
+	}
+	log.Printf("[replay] loaded %d recent nonce(s) from %s", len(kept), abs)
+	return nil
+}
+
+// readReplayJournal returns the records in path (none if it doesn't exist)
+// and how many lines could not be read. A torn last line after a crash is
+// expected and only counted.
+func readReplayJournal(path string) ([]replayRecord, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("reading replay journal: %w", err)
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	if !sc.Scan() {
+		return nil, 0, nil
+	}
+	var hdr replayJournalHeader
+	if err := json.Unmarshal(sc.Bytes(), &hdr); err != nil || hdr.V != replayJournalVersion {
+		return nil, 0, fmt.Errorf("replay journal %s: bad header", path)
+	}
+	chain := replayChainStart(sc.Bytes())
+
+	var aead cipher.AEAD
+	if hdr.Alg != "none" {
+		key, err := unsealJournalKey(hdr.KeyB64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("replay journal %s: %w", path, err)
+		}
+		if aead, err = chacha20poly1305.NewX(key); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// Only an unterminated last line may be unreadable (torn by a crash
+	// mid-append). Any other unreadable line means the chain is broken.
+	torn := !bytes.HasSuffix(data, []byte("\n"))
+	var lines [][]byte
+	for sc.Scan() {
+		lines = append(lines, bytes.Clone(sc.Bytes()))
+	}
+	var recs []replayRecord
+	for i, line := range lines {
+		r, next, err := openReplayRecord(aead, chain, line)
+		if err != nil {
+			if torn && i == len(lines)-1 {
+				return recs, 1, nil
+			}
+			return nil, 0, fmt.Errorf("replay journal %s: record %d doesn't follow the one before it (records removed, reordered or corrupted; delete the file to start over)", path, i+1)
+		}
+		recs, chain = append(recs, r), next
+	}
+	return recs, 0, nil
+}
+
+// replayChainStart is the chain value the first record is bound to.
+func replayChainStart(header []byte) []byte {
+	sum := sha256.Sum256(header)
+	return sum[:]
+}
+
+// rewrite replaces the journal file with recs under a fresh key and reopens
+// it for appends. Caller holds replayMu (or owns j exclusively).
+func (j *replayJournal) rewrite(recs []replayRecord) error {
+	if j.f != nil {
+		_ = j.f.Close()
+		j.f = nil
+	}
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("replay journal key: %w", err)
+	}
+	hdr := replayJournalHeader{V: replayJournalVersion, Alg: "xchacha20poly1305"}
+	sealed, err := sealJournalKey(key)
+	switch {
+	case err == nil:
+		hdr.KeyB64 = sealed
+		if j.aead, err = chacha20poly1305.NewX(key); err != nil {
+			return err
+		}
+	case currentConfig().RequireSealedDeviceStore:
+		return fmt.Errorf("replay journal: require_sealed_device_store=true but the journal key can't be sealed: %w", err)
+	default:
+		log.Printf("[warn] replay journal key can't be sealed (%v); writing it unsealed", err)
+		hdr.Alg, j.aead = "none", nil
+	}
+
+	var buf bytes.Buffer
+	hb, _ := json.Marshal(hdr)
+	buf.Write(hb)
+	buf.WriteByte('\n')
+	j.chain = replayChainStart(hb)
+	for _, r := range recs {
+		line, err := j.sealRecord(r)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+	}
+
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("rename %s -> %s: %w", tmp, j.path, err)
+	}
+
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open replay journal: %w", err)
+	}
+	j.f, j.appends = f, 0
+	return nil
+}
+
+// journalReplay records an accepted nonce. Caller holds replayMu. A failed
+// write is logged; the in-memory cache still rejects the nonce until restart.
+func journalReplay(deviceID, nonceHex string, seenAt int64) {
+	j := journal
+	if j == nil {
+		return
+	}
+
+	if j.appends >= replayJournalCompactEvery {
+		if err := j.rewrite(replayCacheRecords(time.Now().Unix())); err != nil {
+			log.Printf("[replay] journal compaction failed: %v", err)
+			return
+		}
+		// The cache already holds this nonce, so the rewrite included it.
+		return
+	}
+
+	prev := j.chain
+	line, err := j.sealRecord(replayRecord{DeviceID: deviceID, Nonce: nonceHex, SeenAt: seenAt})
+	if err == nil {
+		_, err = j.f.Write(line)
+	}
+	if err != nil {
+		// The file may now end in part of a record; compact on the next
+		// append so later records don't follow it.
+		log.Printf("[replay] journal append failed: %v", err)
+		j.chain, j.appends = prev, replayJournalCompactEvery
+		return
+	}
+	j.appends++
+
+	if !j.syncPending {
+		j.syncPending = true
+		time.AfterFunc(replayJournalSyncDelay, j.flush)
+	}
+}
+
+// flush fsyncs the journal outside replayMu, so a slow disk doesn't hold up
+// every /msg request.
+func (j *replayJournal) flush() {
+	replayMu.Lock()
+	f := j.f
+	j.syncPending = false
+	replayMu.Unlock()
+
+	// A compaction may have closed f since; the rewrite replaced the file.
+	if f == nil {
+		return
+	}
+	if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+		log.Printf("[replay] journal sync failed: %v", err)
+	}
+}
+
+// replayCacheRecords lists replayCache entries younger than
+// replay_cache_ttl_sec. Caller holds replayMu.
+func replayCacheRecords(now int64) []replayRecord {
+	_, _, replayTTL := freshnessWindows(currentConfig())
+	var recs []replayRecord
+	for dev, m := range replayCache {
+		for n, seenAt := range m {
+			if now-seenAt <= replayTTL {
+				recs = append(recs, replayRecord{DeviceID: dev, Nonce: n, SeenAt: seenAt})
+			}
+		}
+	}
+	return recs
+}
+
+// sealRecord seals r bound to the previous record and advances j.chain.
+func (j *replayJournal) sealRecord(r replayRecord) ([]byte, error) {
+	pt, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	if j.aead == nil {
+		return append(pt, '\n'), nil
+	}
+
+	nonce := make([]byte, j.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("rand nonce: %w", err)
+	}
+	ct := j.aead.Seal(nonce, nonce, pt, replayRecordAAD(j.chain))
+	j.chain = bytes.Clone(ct[len(ct)-j.aead.Overhead():])
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(ct)), base64.StdEncoding.EncodedLen(len(ct))+1)
+	base64.StdEncoding.Encode(out, ct)
+	return append(out, '\n'), nil
+}
+
+// openReplayRecord opens a record that must follow chain and returns the
+// chain value for the next one.
+func openReplayRecord(aead cipher.AEAD, chain, line []byte) (replayRecord, []byte, error) {
+	var r replayRecord
+	pt := line
+	if aead != nil {
+		ct, err := base64.StdEncoding.DecodeString(string(line))
+		if err != nil || len(ct) < aead.NonceSize()+aead.Overhead() {
+			return r, nil, fmt.Errorf("bad record")
+		}
+		pt, err = aead.Open(nil, ct[:aead.NonceSize()], ct[aead.NonceSize():], replayRecordAAD(chain))
+		if err != nil {
+			return r, nil, err
+		}
+		chain = ct[len(ct)-aead.Overhead():]
+	}
+	if err := json.Unmarshal(pt, &r); err != nil || r.DeviceID == "" || r.Nonce == "" {
+		return r, nil, fmt.Errorf("bad record")
+	}
+	return r, chain, nil
+}
+
+func replayRecordAAD(chain []byte) []byte {
+	return append([]byte(replayJournalRecordAAD), chain...)
+}
@@ -0,0 +1,51 @@
+// cmd/novakey/replay_journal_unix.go
+//go:build !windows
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const replayJournalKeyAAD = "NovaKey replay journal key v1"
+
+// sealJournalKey wraps the replay journal key with the device-store keyring key.
+func sealJournalKey(key []byte) (string, error) {
+	dk, err := getOrCreateDevicesKey()
+	if err != nil {
+		return "", fmt.Errorf("keyring unavailable: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(dk)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("rand nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, key, []byte(replayJournalKeyAAD))), nil
+}
+
+func unsealJournalKey(sealedB64 string) ([]byte, error) {
+	dk, err := getOrCreateDevicesKey()
+	if err != nil {
+		return nil, fmt.Errorf("keyring unavailable: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(dk)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(sealedB64)
+	if err != nil || len(b) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("bad journal key")
+	}
+	key, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(replayJournalKeyAAD))
+	if err != nil {
+		return nil, fmt.Errorf("journal key does not unseal (keyring key changed?): %w", err)
+	}
+	return key, nil
+}
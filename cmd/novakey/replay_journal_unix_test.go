@@ -0,0 +1,169 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zalando/go-keyring"
+)
+
+func resetReplayState(t *testing.T) {
+	t.Helper()
+	replayMu.Lock()
+	replayCache = make(map[string]map[string]int64)
+	rateState = make(map[string]rateWindow)
+	if journal != nil && journal.f != nil {
+		_ = journal.f.Close()
+	}
+	journal = nil
+	replayMu.Unlock()
+}
+
+func TestReplayJournal_RejectsNonceAfterRestart(t *testing.T) {
+	keyring.MockInit()
+	prevCfg := currentConfig()
+	t.Cleanup(func() {
+		resetReplayState(t)
+		cfgCurrent.Store(prevCfg)
+	})
+
+	cfg := &ServerConfig{}
+	applyDefaults(cfg)
+	cfgCurrent.Store(cfg)
+
+	path := filepath.Join(t.TempDir(), "replay.journal")
+	resetReplayState(t)
+	if err := openReplayJournal(path); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	now := time.Now().Unix()
+	nonce := []byte("0123456789abcdef01234567")
+	if err := validateFreshnessAndRate(cfg, "phone", nonce, now); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	// An expired record written by an earlier run is pruned on load.
+	replayMu.Lock()
+	journalReplay("phone", "0ld", now-int64(cfg.ReplayCacheTTLSec)-1)
+	replayMu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "phone") {
+		t.Fatal("journal records are not sealed")
+	}
+
+	// "Restart": drop in-memory state and reload from disk.
+	resetReplayState(t)
+	if err := openReplayJournal(path); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	err = validateFreshnessAndRate(cfg, "phone", nonce, now)
+	if err == nil || !strings.Contains(err.Error(), "replay") {
+		t.Fatalf("replayed nonce after restart: err=%v", err)
+	}
+
+	replayMu.Lock()
+	_, stale := replayCache["phone"]["0ld"]
+	replayMu.Unlock()
+	if stale {
+		t.Fatal("expired journal record was loaded")
+	}
+}
+
+func TestReplayJournal_SkipsTornRecord(t *testing.T) {
+	keyring.MockInit()
+	prevCfg := currentConfig()
+	t.Cleanup(func() {
+		resetReplayState(t)
+		cfgCurrent.Store(prevCfg)
+	})
+
+	cfg := &ServerConfig{}
+	applyDefaults(cfg)
+	cfgCurrent.Store(cfg)
+
+	path := filepath.Join(t.TempDir(), "replay.journal")
+	resetReplayState(t)
+	if err := openReplayJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	replayMu.Lock()
+	journalReplay("phone", "aa", time.Now().Unix())
+	replayMu.Unlock()
+
+	// Simulate a crash mid-append.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("AAAA")
+	_ = f.Close()
+
+	recs, dropped, err := readReplayJournal(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(recs) != 1 || recs[0].Nonce != "aa" || dropped != 1 {
+		t.Fatalf("recs=%+v dropped=%d", recs, dropped)
+	}
+}
+
+func TestReplayJournal_RefusesRemovedRecord(t *testing.T) {
+	keyring.MockInit()
+	prevCfg := currentConfig()
+	t.Cleanup(func() {
+		resetReplayState(t)
+		cfgCurrent.Store(prevCfg)
+	})
+
+	cfg := &ServerConfig{}
+	applyDefaults(cfg)
+	cfgCurrent.Store(cfg)
+
+	path := filepath.Join(t.TempDir(), "replay.journal")
+	resetReplayState(t)
+	if err := openReplayJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	replayMu.Lock()
+	for _, n := range []string{"aa", "bb", "cc"} {
+		journalReplay("phone", n, now)
+	}
+	replayMu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if len(lines) != 5 { // header, 3 records, trailing ""
+		t.Fatalf("journal has %d lines", len(lines))
+	}
+
+	// Dropping the middle record ("bb") breaks the chain.
+	edited := lines[0] + lines[1] + lines[3]
+	if err := os.WriteFile(path, []byte(edited), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readReplayJournal(path); err == nil {
+		t.Fatal("journal with a removed record was accepted")
+	}
+
+	// Cutting records off the end looks like a crash and is accepted.
+	if err := os.WriteFile(path, []byte(lines[0]+lines[1]), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if recs, _, err := readReplayJournal(path); err != nil || len(recs) != 1 {
+		t.Fatalf("truncated journal: recs=%+v err=%v", recs, err)
+	}
+}
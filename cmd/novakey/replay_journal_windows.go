@@ -0,0 +1,27 @@
+// cmd/novakey/replay_journal_windows.go
+//go:build windows
+
+package main
+
+import "fmt"
+
+// sealJournalKey wraps the replay journal key with DPAPI, like the device store.
+func sealJournalKey(key []byte) (string, error) {
+	ct, err := dpapiProtect(key)
+	if err != nil {
+		return "", fmt.Errorf("dpapi protect: %w", err)
+	}
+	return dpapiEncode(ct), nil
+}
+
+func unsealJournalKey(sealedB64 string) ([]byte, error) {
+	ct, err := dpapiDecode(sealedB64)
+	if err != nil {
+		return nil, fmt.Errorf("bad journal key: %w", err)
+	}
+	key, err := dpapiUnprotect(ct)
+	if err != nil {
+		return nil, fmt.Errorf("dpapi unprotect failed: %w", err)
+	}
+	return key, nil
+}
@@ -17,6 +17,7 @@ const (
 	StageApprove ReplyStage = "approve"
 	StageArm     ReplyStage = "arm"
 	StageDisarm  ReplyStage = "disarm"
+	StageRekey   ReplyStage = "rekey"
 )
 
 type ReplyReason string
@@ -31,6 +32,18 @@ const (
 	ReasonNeedsApprove ReplyReason = "needs_approve"
 	ReasonNotPaired    ReplyReason = "not_paired"
 
+	// An approval is live, but not from a device allowed to approve this
+	// injector (two_man_mode=dual, or another device's approval in self mode).
+	ReasonWrongApprover ReplyReason = "wrong_approver"
+
+	// The device authenticated, but its key has expired (expires_at_unix or
+	// device_key_max_age_days). Sent with StatusNotPaired; re-pair to recover.
+	ReasonRekeyRequired ReplyReason = "rekey_required"
+
+	// Typing stopped because another window took focus (StatusBadRequest).
+	// Some of the secret may have reached the original window.
+	ReasonFocusChanged ReplyReason = "focus_changed"
+
 	// NOTE: These are valid server reasons, but older iOS clients may not
 	// include them in their decoding enums and may crash if they appear.
 	ReasonBadRequest   ReplyReason = "bad_request"
@@ -49,6 +62,28 @@ type ServerReply struct {
 	Msg    string      `json:"msg"`
 	TsUnix int64       `json:"ts_unix"`
 	ReqID  uint64      `json:"req_id"`
+
+	// Set on needs_approve when the inject is held as a pending request
+	// (request_scoped_approve / approval_quorum). An Approve payload of
+	// {"request_id": PendingID} releases exactly that inject.
+	PendingID   string `json:"pending_id,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// Set on a successful Rekey when the server generated the new key; it is
+	// sealed for the requesting device (see sealRekeyKey).
+	NewKeySealed string `json:"new_key_sealed,omitempty"`
+
+	// Set when the request was encrypted to a retired server key: the
+	// current key, for the phone to pin instead (see staleServerKeyUpdate).
+	ServerKey *serverKeyUpdate `json:"server_key,omitempty"`
+
+	// Set on bad_timestamp: the server's clock when the message was checked.
+	// A phone can add (server_time_unix - its own time) to later timestamps.
+	ServerTimeUnix int64 `json:"server_time_unix,omitempty"`
+
+	// Set on a successful inject: the backend that typed or pasted the
+	// secret (see inject_backends).
+	InjectBackend string `json:"inject_backend,omitempty"`
 }
 
 // safeReasonForClient returns a reason that is less likely to crash strict iOS decoders.
@@ -68,10 +103,14 @@ func safeReasonForClient(st RespStatus, reason ReplyReason, msg string) (ReplyRe
 }
 
 func writeReplyLine(conn net.Conn, r ServerReply) {
+	writeJSONLine(conn, r)
+}
+
+func writeJSONLine(conn net.Conn, v any) {
 	_ = conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
 	defer func() { _ = conn.SetWriteDeadline(time.Time{}) }()
 
-	b, err := json.Marshal(r)
+	b, err := json.Marshal(v)
 	if err != nil {
 		b = []byte(`{"v":1,"status":127,"stage":"msg","reason":"ok","msg":"reason=internal_error; marshal failed","ts_unix":0,"req_id":0}` + "\n")
 	} else {
@@ -0,0 +1,73 @@
+// cmd/novakey/reply_seal.go
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Sealed replies.
+//
+// A v4 request with outerFlagSealedReply gets its reply, once the request has
+// authenticated, as
+//
+//	{"v":2,"sealed":"<base64(nonce(24) || XChaCha20-Poly1305(replyKey, nonce, reply JSON, AAD))>"}
+//
+//	replyKey = HKDF-SHA256(ikm=sharedKem, salt=deviceKey, info="NovaKey v4 reply key")
+//	AAD      = "NovaKey v4 reply" || request nonce
+//
+// so only the sender can read it, nobody else can forge it, and it can't be
+// passed off as the reply to another request. Replies sent before the request
+// authenticates (framing and crypto failures) stay plaintext; a client that
+// asked for a sealed reply must treat those as unauthenticated failures.
+
+const sealedReplyVersion = 2
+
+type sealedReply struct {
+	V      int    `json:"v"`
+	Sealed string `json:"sealed"`
+}
+
+func sealReply(mc msgContext, r ServerReply) (sealedReply, error) {
+	pt, err := json.Marshal(r)
+	if err != nil {
+		return sealedReply{}, fmt.Errorf("marshal reply: %w", err)
+	}
+
+	h := hkdf.New(sha256.New, mc.sharedKem, mc.deviceKey, []byte("NovaKey v4 reply key"))
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(h, key); err != nil {
+		return sealedReply{}, fmt.Errorf("hkdf reply key: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return sealedReply{}, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return sealedReply{}, fmt.Errorf("rand nonce: %w", err)
+	}
+	aad := append([]byte("NovaKey v4 reply"), mc.nonce...)
+	out := aead.Seal(nonce, nonce, pt, aad)
+	return sealedReply{V: sealedReplyVersion, Sealed: base64.StdEncoding.EncodeToString(out)}, nil
+}
+
+// writeSealedReplyLine is writeReplyLine for requests that asked for a sealed
+// reply. If sealing fails, it sends a plaintext internal error, never r.
+func writeSealedReplyLine(conn net.Conn, mc msgContext, r ServerReply) {
+	s, err := sealReply(mc, r)
+	if err != nil {
+		writeReplyLine(conn, makeReply(r.ReqID, StatusInternal, r.Stage, ReasonInternal, "reply seal failed"))
+		return
+	}
+	writeJSONLine(conn, s)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+func TestSealReply_BoundToRequest(t *testing.T) {
+	mc := msgContext{
+		sharedKem: bytes.Repeat([]byte{1}, 32),
+		deviceKey: bytes.Repeat([]byte{2}, 32),
+		nonce:     bytes.Repeat([]byte{3}, 24),
+	}
+	r := makeReply(7, StatusOK, StageInject, ReasonOK, "")
+
+	s, err := sealReply(mc, r)
+	if err != nil || s.V != sealedReplyVersion {
+		t.Fatalf("seal: %+v, %v", s, err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(s.Sealed)
+
+	open := func(mc msgContext) ([]byte, error) {
+		h := hkdf.New(sha256.New, mc.sharedKem, mc.deviceKey, []byte("NovaKey v4 reply key"))
+		key := make([]byte, 32)
+		_, _ = io.ReadFull(h, key)
+		aead, _ := chacha20poly1305.NewX(key)
+		return aead.Open(nil, sealed[:24], sealed[24:], append([]byte("NovaKey v4 reply"), mc.nonce...))
+	}
+
+	pt, err := open(mc)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	var got ServerReply
+	if err := json.Unmarshal(pt, &got); err != nil || got.ReqID != 7 || got.Status != uint8(StatusOK) {
+		t.Fatalf("reply: %+v, %v", got, err)
+	}
+
+	other := mc
+	other.nonce = bytes.Repeat([]byte{4}, 24)
+	if _, err := open(other); err == nil {
+		t.Fatal("sealed reply opened for a different request nonce")
+	}
+	other = mc
+	other.deviceKey = bytes.Repeat([]byte{5}, 32)
+	if _, err := open(other); err == nil {
+		t.Fatal("sealed reply opened under a different device key")
+	}
+}
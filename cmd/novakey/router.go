@@ -17,6 +17,7 @@ const (
 )
 
 func startUnifiedListener() error {
+	cfg := currentConfig()
 	ln, err := net.Listen("tcp", cfg.ListenAddr)
 	if err != nil {
 		return fmt.Errorf("listen %s: %w", cfg.ListenAddr, err)
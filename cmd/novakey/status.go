@@ -0,0 +1,155 @@
+// cmd/novakey/status.go
+package main
+
+import (
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// daemonStatus is the read-only snapshot returned by the control "status" op.
+// It contains no key material beyond the public key fingerprint.
+type daemonStatus struct {
+	Armed              bool  `json:"armed"`
+	ArmedUntilUnixMs   int64 `json:"armed_until_unix_ms,omitempty"`
+	ArmConsumeOnInject bool  `json:"arm_consume_on_inject"`
+
+	TwoManEnabled bool `json:"two_man_enabled"`
+
+	DeviceCount int            `json:"device_count"`
+	Devices     []deviceStatus `json:"devices"`
+
+	PairingActive        bool               `json:"pairing_active"`
+	PairingExpiresUnixMs int64              `json:"pairing_expires_unix_ms,omitempty"`
+	ServerKeyFingerprint string             `json:"server_key_fp16,omitempty"`
+	ServerKID            string             `json:"server_kid,omitempty"`
+	RetiredServerKeys    []retiredKeyStatus `json:"retired_server_keys,omitempty"`
+	Session              string             `json:"session"`
+	RateLimitPerMin      int                `json:"rate_limit_per_min"`
+	ListenAddr           string             `json:"listen_addr"`
+
+	// Rejected /msg frames since start, by class (see msg_reject.go).
+	MsgRejections map[string]uint64 `json:"msg_rejections,omitempty"`
+}
+
+// retiredKeyStatus is a rotated-out server key still accepted for /msg.
+type retiredKeyStatus struct {
+	KID            string `json:"kid"`
+	NotAfterUnixMs int64  `json:"not_after_unix_ms"`
+}
+
+type deviceStatus struct {
+	ID      string `json:"id"`
+	Label   string `json:"label,omitempty"`
+	Role    string `json:"role"`
+	Profile string `json:"profile,omitempty"`
+
+	// Live two-man approval held by this device, if any.
+	ApprovedUntilUnixMs int64 `json:"approved_until_unix_ms,omitempty"`
+
+	// When the device's key stops being accepted (see device_key_expiry.go).
+	KeyExpiresUnixMs int64 `json:"key_expires_unix_ms,omitempty"`
+
+	// Requests counted in the current one-minute rate window.
+	RateCount             int   `json:"rate_count"`
+	RateWindowResetUnixMs int64 `json:"rate_window_reset_unix_ms,omitempty"`
+}
+
+func collectStatus() *daemonStatus {
+	cfg := currentConfig()
+	now := time.Now()
+
+	s := &daemonStatus{
+		ArmConsumeOnInject:   boolDeref(cfg.ArmConsumeOnInject, true),
+		TwoManEnabled:        boolDeref(cfg.TwoManEnabled, true),
+		ServerKeyFingerprint: serverKeyFingerprint(),
+		ServerKID:            serverKID,
+		Session:              sessionType(),
+		RateLimitPerMin:      maxRequestsPerDevicePerMin,
+		ListenAddr:           cfg.ListenAddr,
+		MsgRejections:        msgRejectSnapshot(),
+	}
+	if cfg.MaxRequestsPerMin > 0 {
+		s.RateLimitPerMin = cfg.MaxRequestsPerMin
+	}
+
+	for _, k := range activeServerKeys(now)[1:] {
+		s.RetiredServerKeys = append(s.RetiredServerKeys, retiredKeyStatus{KID: k.kid, NotAfterUnixMs: k.notAfter.UnixMilli()})
+	}
+
+	if until := armGate.ArmedUntil(); !until.IsZero() && now.Before(until) {
+		s.Armed = true
+		s.ArmedUntilUnixMs = until.UnixMilli()
+	}
+
+	if isPairingActive() {
+		s.PairingActive = true
+		s.PairingExpiresUnixMs = currentPairExpiry().UnixMilli()
+	}
+
+	devicesMu.RLock()
+	s.Devices = make([]deviceStatus, 0, len(devices))
+	for id, st := range devices {
+		role := st.role
+		if role == "" {
+			role = RoleBoth
+		}
+		d := deviceStatus{ID: id, Label: st.meta.Label, Role: role, Profile: st.profile}
+		if exp := keyExpiry(cfg, st); !exp.IsZero() {
+			d.KeyExpiresUnixMs = exp.UnixMilli()
+		}
+		s.Devices = append(s.Devices, d)
+	}
+	devicesMu.RUnlock()
+	sort.Slice(s.Devices, func(i, j int) bool { return s.Devices[i].ID < s.Devices[j].ID })
+	s.DeviceCount = len(s.Devices)
+
+	replayMu.Lock()
+	for i := range s.Devices {
+		rw, ok := rateState[s.Devices[i].ID]
+		if !ok || now.Unix()-rw.windowStart >= 60 {
+			continue
+		}
+		s.Devices[i].RateCount = rw.count
+		s.Devices[i].RateWindowResetUnixMs = time.Unix(rw.windowStart+60, 0).UnixMilli()
+	}
+	replayMu.Unlock()
+
+	for i := range s.Devices {
+		if u := approvalGate.ApprovedUntil(s.Devices[i].ID); now.Before(u) {
+			s.Devices[i].ApprovedUntilUnixMs = u.UnixMilli()
+		}
+	}
+
+	return s
+}
+
+func serverKeyFingerprint() string {
+	if len(serverEncapKey) == 0 {
+		return ""
+	}
+	return fp16Hex(serverEncapKey)
+}
+
+// sessionType reports the desktop session the daemon runs in, as seen from
+// the daemon's own environment (which is what injection depends on).
+func sessionType() string {
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		return runtime.GOOS
+	}
+
+	xdg := strings.ToLower(strings.TrimSpace(os.Getenv("XDG_SESSION_TYPE")))
+	switch {
+	case waylandSession():
+		return "wayland"
+	case xdg == "x11" || os.Getenv("DISPLAY") != "":
+		return "x11"
+	case xdg != "":
+		return xdg
+	default:
+		return "unknown"
+	}
+}
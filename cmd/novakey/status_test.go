@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCollectStatus_DevicesApprovalsAndRate(t *testing.T) {
+	withTestDevices(t, map[string]deviceState{
+		"phone-b": {id: "phone-b", role: RoleApprover},
+		"phone-a": {id: "phone-a", profile: "browsers"},
+	})
+	t.Cleanup(approvalGate.ClearForTests)
+
+	approvalGate.Approve("phone-b", "phone-a", time.Minute)
+
+	replayMu.Lock()
+	prev := rateState["phone-a"]
+	rateState["phone-a"] = rateWindow{windowStart: time.Now().Unix(), count: 3}
+	replayMu.Unlock()
+	t.Cleanup(func() {
+		replayMu.Lock()
+		rateState["phone-a"] = prev
+		replayMu.Unlock()
+	})
+
+	s := collectStatus()
+	if s.DeviceCount != 2 || s.Devices[0].ID != "phone-a" || s.Devices[1].ID != "phone-b" {
+		t.Fatalf("devices = %+v", s.Devices)
+	}
+	a, b := s.Devices[0], s.Devices[1]
+	if a.Role != RoleBoth || a.Profile != "browsers" || a.RateCount != 3 || a.ApprovedUntilUnixMs != 0 {
+		t.Fatalf("phone-a = %+v", a)
+	}
+	if b.Role != RoleApprover || b.ApprovedUntilUnixMs == 0 || b.RateCount != 0 {
+		t.Fatalf("phone-b = %+v", b)
+	}
+}
@@ -6,17 +6,35 @@ import (
 	"strings"
 )
 
-func enforceTargetPolicy() error {
+// focusedTarget is the focused window as target policy sees it. ID comes
+// from the same lookup, so the focus guard can hold typing to exactly the
+// window the policy evaluated.
+type focusedTarget struct {
+	Proc  string
+	Title string
+	ID    focusIdentity
+}
+
+// enforceTargetPolicy checks the focused window against cfg. On success it
+// returns the identity of the window it allowed, or nil when target policy
+// is off and nothing was looked up.
+func enforceTargetPolicy(cfg *ServerConfig) (*focusIdentity, error) {
 	// Only enforce when explicitly enabled.
 	if !cfg.TargetPolicyEnabled {
-		return nil
+		return nil, nil
 	}
 
-	proc, title, err := getFocusedTarget()
+	t, err := getFocusedTarget()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if err := checkTarget(cfg, t.Proc, t.Title); err != nil {
+		return nil, err
+	}
+	return &t.ID, nil
+}
 
+func checkTarget(cfg *ServerConfig, proc, title string) error {
 	procNorm := normalizeProcName(proc)
 	titleNorm := strings.ToLower(strings.TrimSpace(title))
 
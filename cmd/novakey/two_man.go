@@ -6,24 +6,32 @@ import (
 	"time"
 )
 
-// twoManGate tracks per-device approval windows.
+// twoManGate tracks approval windows. Each approval is for one injector, so
+// an approval meant for one device can't release another device's inject.
 type twoManGate struct {
 	mu    sync.Mutex
-	until map[string]time.Time
+	until map[approvalKey]time.Time
+}
+
+// approvalKey is an approval given by Approver for injects from Injector. In
+// self mode both are the same device.
+type approvalKey struct {
+	Approver string
+	Injector string
 }
 
 func newTwoManGate() *twoManGate {
 	return &twoManGate{
-		until: make(map[string]time.Time),
+		until: make(map[approvalKey]time.Time),
 	}
 }
 
-func (g *twoManGate) Approve(deviceID string, d time.Duration) time.Time {
+func (g *twoManGate) Approve(approverID, injectorID string, d time.Duration) time.Time {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	g.cleanupLocked(time.Now())
 	u := time.Now().Add(d)
-	g.until[deviceID] = u
+	g.until[approvalKey{approverID, injectorID}] = u
 	return u
 }
 
@@ -31,32 +39,73 @@ func (g *twoManGate) ClearForTests() {
 	*g = *newTwoManGate()
 }
 
-func (g *twoManGate) Consume(deviceID string, consume bool) bool {
+// ConsumeFrom uses the first live approval for injectorID given by one of
+// approvers. It returns the approver whose approval was used.
+func (g *twoManGate) ConsumeFrom(injectorID string, approvers []string, consume bool) (string, bool) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	now := time.Now()
 	g.cleanupLocked(now)
 
-	u, ok := g.until[deviceID]
-	if !ok || now.After(u) {
-		return false
+	for _, id := range approvers {
+		k := approvalKey{id, injectorID}
+		u, ok := g.until[k]
+		if !ok || now.After(u) {
+			continue
+		}
+		if consume {
+			delete(g.until, k)
+		}
+		return id, true
 	}
-	if consume {
-		delete(g.until, deviceID)
+	return "", false
+}
+
+// LiveApproversFor returns the devices that hold a live approval for
+// injectorID.
+func (g *twoManGate) LiveApproversFor(injectorID string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cleanupLocked(time.Now())
+
+	var out []string
+	for k := range g.until {
+		if k.Injector == injectorID {
+			out = append(out, k.Approver)
+		}
 	}
-	return true
+	return out
 }
 
+// Forget drops every approval given by or for deviceID (revoked or re-keyed
+// device).
+func (g *twoManGate) Forget(deviceID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for k := range g.until {
+		if k.Approver == deviceID || k.Injector == deviceID {
+			delete(g.until, k)
+		}
+	}
+}
+
+// ApprovedUntil returns when the latest approval given by deviceID expires.
 func (g *twoManGate) ApprovedUntil(deviceID string) time.Time {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	return g.until[deviceID]
+	var latest time.Time
+	for k, u := range g.until {
+		if k.Approver == deviceID && u.After(latest) {
+			latest = u
+		}
+	}
+	return latest
 }
 
 func (g *twoManGate) cleanupLocked(now time.Time) {
-	for id, u := range g.until {
+	for k, u := range g.until {
 		if now.After(u) {
-			delete(g.until, id)
+			delete(g.until, k)
 		}
 	}
 }
@@ -64,7 +113,7 @@ func (g *twoManGate) cleanupLocked(now time.Time) {
 // Global gate instance
 var approvalGate = newTwoManGate()
 
-func approveWindow() time.Duration {
+func approveWindow(cfg *ServerConfig) time.Duration {
 	ms := cfg.ApproveWindowMs
 	if ms <= 0 {
 		ms = 15000
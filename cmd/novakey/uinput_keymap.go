@@ -0,0 +1,143 @@
+// cmd/novakey/uinput_keymap.go
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Linux evdev key codes (linux/input-event-codes.h) used by the uinput
+// backend. They name physical key positions; the compositor turns them into
+// characters with the session's keyboard layout, which is why uinput_keymap
+// has to match that layout.
+const (
+	key1          = 2 // 1..9, 0 are 2..11
+	key0          = 11
+	keyMinus      = 12
+	keyEqual      = 13
+	keyTab        = 15
+	keyQ          = 16
+	keyE          = 18
+	keyLeftBrace  = 26
+	keyRightBrace = 27
+	keyEnter      = 28
+	keyA          = 30
+	keySemicolon  = 39
+	keyApostrophe = 40
+	keyGrave      = 41
+	keyLeftShift  = 42
+	keyBackslash  = 43
+	keyZ          = 44
+	keyM          = 50
+	keyComma      = 51
+	keyDot        = 52
+	keySlash      = 53
+	keySpace      = 57
+	key102nd      = 86 // extra ISO key left of Z
+	keyRightAlt   = 100
+)
+
+// keyStroke is one character as a key plus the modifiers held for it.
+type keyStroke struct {
+	code  uint16
+	shift bool
+	altGr bool
+}
+
+const defaultUinputKeymap = "us"
+
+// uinputKeymaps maps layout name -> character -> keystroke. Characters that
+// need a dead key or compose sequence on a layout are left out, so they are
+// rejected up front instead of typed wrong.
+var uinputKeymaps = map[string]map[rune]keyStroke{
+	"us": buildKeymap(
+		"qwertyuiop", "asdfghjkl", "zxcvbnm",
+		"1234567890", "!@#$%^&*()",
+		[]keymapKey{
+			{keyMinus, '-', '_', 0}, {keyEqual, '=', '+', 0},
+			{keyLeftBrace, '[', '{', 0}, {keyRightBrace, ']', '}', 0},
+			{keySemicolon, ';', ':', 0}, {keyApostrophe, '\'', '"', 0},
+			{keyGrave, '`', '~', 0}, {keyBackslash, '\\', '|', 0},
+			{keyComma, ',', '<', 0}, {keyDot, '.', '>', 0}, {keySlash, '/', '?', 0},
+		}),
+	"de": buildKeymap(
+		"qwertzuiop", "asdfghjkl", "yxcvbnm",
+		"1234567890", "!\"§$%&/()=",
+		[]keymapKey{
+			{keyMinus, 'ß', '?', '\\'},
+			{key1 + 1, 0, 0, '²'}, {key1 + 2, 0, 0, '³'},
+			{key1 + 6, 0, 0, '{'}, {key1 + 7, 0, 0, '['}, {key1 + 8, 0, 0, ']'}, {key0, 0, 0, '}'},
+			{keyQ, 0, 0, '@'}, {keyE, 0, 0, '€'}, {keyM, 0, 0, 'µ'},
+			{keyLeftBrace, 'ü', 'Ü', 0}, {keyRightBrace, '+', '*', 0},
+			{keySemicolon, 'ö', 'Ö', 0}, {keyApostrophe, 'ä', 'Ä', 0},
+			{keyGrave, 0, '°', 0}, {keyBackslash, '#', '\'', 0},
+			{key102nd, '<', '>', '|'},
+			{keyComma, ',', ';', 0}, {keyDot, '.', ':', 0}, {keySlash, '-', '_', 0},
+		}),
+}
+
+// keymapKey is one key of a layout: its plain, shifted and AltGr characters
+// (0 where the key has none we can type).
+type keymapKey struct {
+	code                uint16
+	plain, shift, altGr rune
+}
+
+// buildKeymap fills in the letter rows (in physical key order), the digit row
+// and its shifted characters, then extra keys, plus space, tab and enter.
+func buildKeymap(top, home, bottom, digits, shiftedDigits string, extra []keymapKey) map[rune]keyStroke {
+	m := make(map[rune]keyStroke)
+	add := func(r rune, ks keyStroke) {
+		if r != 0 {
+			m[r] = ks
+		}
+	}
+	for row, first := range map[string]uint16{top: keyQ, home: keyA, bottom: keyZ} {
+		for i, r := range row {
+			add(r, keyStroke{code: first + uint16(i)})
+			add(r-'a'+'A', keyStroke{code: first + uint16(i), shift: true})
+		}
+	}
+	shifted := []rune(shiftedDigits)
+	for i, r := range []rune(digits) {
+		add(r, keyStroke{code: key1 + uint16(i)})
+		add(shifted[i], keyStroke{code: key1 + uint16(i), shift: true})
+	}
+	for _, k := range extra {
+		add(k.plain, keyStroke{code: k.code})
+		add(k.shift, keyStroke{code: k.code, shift: true})
+		add(k.altGr, keyStroke{code: k.code, altGr: true})
+	}
+	add(' ', keyStroke{code: keySpace})
+	add('\t', keyStroke{code: keyTab})
+	add('\n', keyStroke{code: keyEnter})
+	return m
+}
+
+func uinputKeymapNames() []string {
+	names := make([]string, 0, len(uinputKeymaps))
+	for n := range uinputKeymaps {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// translateText maps text to keystrokes with the named layout. It fails
+// before anything is typed if any character can't be typed; the error gives
+// the position, never the character.
+func translateText(keymap, text string) ([]keyStroke, error) {
+	m, ok := uinputKeymaps[keymap]
+	if !ok {
+		return nil, fmt.Errorf("unknown uinput keymap %q", keymap)
+	}
+	strokes := make([]keyStroke, 0, len(text))
+	for i, r := range []rune(text) {
+		ks, ok := m[r]
+		if !ok {
+			return nil, fmt.Errorf("character %d is not typeable with uinput keymap %q", i+1, keymap)
+		}
+		strokes = append(strokes, ks)
+	}
+	return strokes, nil
+}
@@ -0,0 +1,222 @@
+// cmd/novakey/uinput_linux.go
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// uinput backend: a short-lived /dev/uinput virtual keyboard. It works under
+// Wayland because the compositor sees it as real hardware. Opt-in
+// (linux_uinput_enabled): the daemon user needs write access to /dev/uinput,
+// usually via a udev rule and the input group.
+
+const uinputPath = "/dev/uinput"
+
+// uinput ioctls (linux/uinput.h, generic _IO/_IOW encoding).
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiDevSetup   = 0x405c5503 // _IOW('U', 3, struct uinput_setup)
+	uiSetEvBit   = 0x40045564 // _IOW('U', 100, int)
+	uiSetKeyBit  = 0x40045565 // _IOW('U', 101, int)
+
+	evSyn      = 0x00
+	evKey      = 0x01
+	synReport  = 0
+	busVirtual = 0x06
+)
+
+// uinputSettleDelay gives the compositor time to pick up the new device
+// before the first key; events sent earlier are dropped.
+const uinputSettleDelay = 300 * time.Millisecond
+
+// inputEvent is struct input_event.
+type inputEvent struct {
+	Time  unix.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// uinputSetup is struct uinput_setup.
+type uinputSetup struct {
+	BusType      uint16
+	Vendor       uint16
+	Product      uint16
+	Version      uint16
+	Name         [80]byte
+	FFEffectsMax uint32
+}
+
+// uinputKeyboard writes key events to w: the uinput device, or any writer
+// in tests (see decodeInputEvents).
+type uinputKeyboard struct {
+	w     io.Writer
+	delay time.Duration
+	sleep func(time.Duration)
+	focus *focusGuard
+}
+
+// uinputChunk is how often typeStrokes re-checks focus unconditionally.
+const uinputChunk = 16
+
+func (k *uinputKeyboard) emit(typ, code uint16, value int32) error {
+	return binary.Write(k.w, binary.NativeEndian, inputEvent{Type: typ, Code: code, Value: value})
+}
+
+func (k *uinputKeyboard) key(code uint16, down bool) error {
+	v := int32(0)
+	if down {
+		v = 1
+	}
+	if err := k.emit(evKey, code, v); err != nil {
+		return err
+	}
+	return k.emit(evSyn, synReport, 0)
+}
+
+// typeStrokes presses each stroke with its modifiers, k.delay apart. It
+// stops if focus moves (k.focus). If a write fails, held modifiers are
+// released before returning; once a key was pressed the error wraps
+// ErrPartiallyTyped.
+func (k *uinputKeyboard) typeStrokes(strokes []keyStroke) (err error) {
+	var held []uint16
+	typed := false
+	defer func() {
+		for _, m := range held {
+			_ = k.key(m, false)
+		}
+		if err != nil && typed {
+			err = partiallyTyped(err)
+		}
+	}()
+
+	for i, s := range strokes {
+		if err := k.focus.verify(i%uinputChunk == 0); err != nil {
+			return err
+		}
+		held = held[:0]
+		if s.shift {
+			held = append(held, keyLeftShift)
+		}
+		if s.altGr {
+			held = append(held, keyRightAlt)
+		}
+		for _, m := range held {
+			if err := k.key(m, true); err != nil {
+				return err
+			}
+		}
+		if err := k.key(s.code, true); err != nil {
+			return err
+		}
+		typed = true
+		if err := k.key(s.code, false); err != nil {
+			return err
+		}
+		for i := len(held) - 1; i >= 0; i-- {
+			if err := k.key(held[i], false); err != nil {
+				return err
+			}
+			held = held[:i]
+		}
+		if k.delay > 0 {
+			k.sleep(k.delay)
+		}
+	}
+	return nil
+}
+
+// injectViaUinput types text with a virtual keyboard created for this call
+// and destroyed afterwards.
+func injectViaUinput(cfg *ServerConfig, text string, focus *focusGuard) error {
+	keymap := cfg.UinputKeymap
+	if keymap == "" {
+		keymap = defaultUinputKeymap
+	}
+	strokes, err := translateText(keymap, text)
+	if err != nil {
+		return err
+	}
+
+	f, err := openUinputKeyboard(keymap)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = unix.IoctlSetInt(int(f.Fd()), uiDevDestroy, 0)
+		_ = f.Close()
+	}()
+	time.Sleep(uinputSettleDelay)
+
+	kb := &uinputKeyboard{
+		w:     f,
+		delay: time.Duration(cfg.UinputKeyDelayMs) * time.Millisecond,
+		sleep: time.Sleep,
+		focus: focus,
+	}
+	if err := kb.typeStrokes(strokes); errors.Is(err, ErrFocusChanged) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("uinput write: %w", err)
+	}
+	log.Printf("[linux] typed %d keystrokes via uinput (keymap=%s)", len(strokes), keymap)
+	return nil
+}
+
+// openUinputKeyboard creates a virtual keyboard that can press every key of
+// keymap plus Shift and AltGr.
+func openUinputKeyboard(keymap string) (*os.File, error) {
+	f, err := os.OpenFile(uinputPath, os.O_WRONLY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", uinputPath, err)
+	}
+	fd := int(f.Fd())
+
+	fail := func(step string, err error) (*os.File, error) {
+		_ = f.Close()
+		return nil, fmt.Errorf("uinput %s: %w", step, err)
+	}
+
+	if err := unix.IoctlSetInt(fd, uiSetEvBit, evKey); err != nil {
+		return fail("UI_SET_EVBIT", err)
+	}
+	codes := map[uint16]bool{keyLeftShift: true, keyRightAlt: true}
+	for _, ks := range uinputKeymaps[keymap] {
+		codes[ks.code] = true
+	}
+	for c := range codes {
+		if err := unix.IoctlSetInt(fd, uiSetKeyBit, int(c)); err != nil {
+			return fail("UI_SET_KEYBIT", err)
+		}
+	}
+
+	setup := uinputSetup{BusType: busVirtual, Vendor: 0x4e4b, Product: 0x0001, Version: 1}
+	copy(setup.Name[:], "NovaKey virtual keyboard")
+	if err := ioctlPtr(fd, uiDevSetup, &setup); err != nil {
+		return fail("UI_DEV_SETUP", err)
+	}
+	if err := unix.IoctlSetInt(fd, uiDevCreate, 0); err != nil {
+		return fail("UI_DEV_CREATE", err)
+	}
+	return f, nil
+}
+
+func ioctlPtr(fd int, req uint, setup *uinputSetup) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(unsafe.Pointer(setup)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
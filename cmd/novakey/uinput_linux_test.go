@@ -0,0 +1,139 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeInputEvents reads back what a uinputKeyboard wrote, like an evdev
+// reader on the virtual device would, as "+code"/"-code" key transitions.
+func decodeInputEvents(t *testing.T, b []byte) []string {
+	t.Helper()
+	var out []string
+	r := bytes.NewReader(b)
+	for r.Len() > 0 {
+		var ev inputEvent
+		if err := binary.Read(r, binary.NativeEndian, &ev); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		switch ev.Type {
+		case evKey:
+			sign := "-"
+			if ev.Value == 1 {
+				sign = "+"
+			}
+			out = append(out, fmt.Sprintf("%s%d", sign, ev.Code))
+		case evSyn:
+		default:
+			t.Fatalf("unexpected event type %d", ev.Type)
+		}
+	}
+	return out
+}
+
+func TestUinputKeyboard_TypesWithModifiers(t *testing.T) {
+	strokes, err := translateText("de", "zZ@")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	var slept []time.Duration
+	kb := &uinputKeyboard{w: &buf, delay: 8 * time.Millisecond, sleep: func(d time.Duration) { slept = append(slept, d) }}
+	if err := kb.typeStrokes(strokes); err != nil {
+		t.Fatal(err)
+	}
+
+	// de: z is on the KEY_Y position (21), @ is AltGr+Q (100, 16).
+	got := strings.Join(decodeInputEvents(t, buf.Bytes()), " ")
+	want := "+21 -21 +42 +21 -21 -42 +100 +16 -16 -100"
+	if got != want {
+		t.Fatalf("events:\n got %s\nwant %s", got, want)
+	}
+	if len(slept) != 3 {
+		t.Fatalf("delays: %v", slept)
+	}
+}
+
+// failOnce fails the write after n successful ones, then works again.
+type failOnce struct {
+	n int
+	w bytes.Buffer
+}
+
+func (f *failOnce) Write(p []byte) (int, error) {
+	f.n--
+	if f.n == -1 {
+		return 0, errors.New("device gone")
+	}
+	return f.w.Write(p)
+}
+
+func TestUinputKeyboard_ReleasesModifierOnWriteError(t *testing.T) {
+	strokes, _ := translateText("us", "A")
+	// Shift down + syn succeed, then the key press fails.
+	fw := &failOnce{n: 2}
+	kb := &uinputKeyboard{w: fw, sleep: func(time.Duration) {}}
+	if err := kb.typeStrokes(strokes); err == nil {
+		t.Fatal("expected write error")
+	}
+	if got := strings.Join(decodeInputEvents(t, fw.w.Bytes()), " "); got != "+42 -42" {
+		t.Fatalf("events: %s", got)
+	}
+}
+
+func TestUinputKeyboard_WriteErrorAfterTypingIsPartial(t *testing.T) {
+	strokes, _ := translateText("us", "ab")
+	// "a" down/syn/up/syn succeed, then "b" fails.
+	fw := &failOnce{n: 4}
+	kb := &uinputKeyboard{w: fw, sleep: func(time.Duration) {}}
+	if err := kb.typeStrokes(strokes); !errors.Is(err, ErrPartiallyTyped) {
+		t.Fatalf("err = %v, want ErrPartiallyTyped", err)
+	}
+
+	// Nothing pressed yet: a plain error, so the next backend may try.
+	strokes, _ = translateText("us", "A")
+	fw = &failOnce{n: 2}
+	kb = &uinputKeyboard{w: fw, sleep: func(time.Duration) {}}
+	if err := kb.typeStrokes(strokes); err == nil || errors.Is(err, ErrPartiallyTyped) {
+		t.Fatalf("err = %v, want a write error before typing", err)
+	}
+}
+
+func TestTranslateText_RejectsUntypeableWithoutLeaking(t *testing.T) {
+	if _, err := translateText("us", "pässword"); err == nil || strings.Contains(err.Error(), "ä") {
+		t.Fatalf("err=%v", err)
+	}
+	if _, err := translateText("de", "a^b"); err == nil {
+		t.Fatal("dead key character accepted")
+	}
+	if _, err := translateText("dvorak", "a"); err == nil {
+		t.Fatal("unknown keymap accepted")
+	}
+}
+
+func TestUinputKeyboard_StopsWhenFocusMoves(t *testing.T) {
+	strokes, err := translateText("us", strings.Repeat("a", 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Baseline, the check before stroke 0, then another window by the
+	// forced check before stroke uinputChunk.
+	probe, _ := fakeFocus(focusIdentity{Window: 1, PID: 10}, focusIdentity{Window: 1, PID: 10}, focusIdentity{Window: 2, PID: 20})
+
+	var buf bytes.Buffer
+	kb := &uinputKeyboard{w: &buf, sleep: func(time.Duration) {}, focus: newFocusGuardWith(1, nil, probe)}
+	if err := kb.typeStrokes(strokes); !errors.Is(err, ErrFocusChanged) {
+		t.Fatalf("err = %v, want ErrFocusChanged", err)
+	}
+	if n := len(decodeInputEvents(t, buf.Bytes())); n != 2*uinputChunk {
+		t.Fatalf("%d key transitions written, want %d (typing must stop at the check)", n, 2*uinputChunk)
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"strings"
 )
 
-func validateInjectText(s string) error {
+func validateInjectText(cfg *ServerConfig, s string) error {
 	if cfg.MaxInjectLen > 0 && len(s) > cfg.MaxInjectLen {
 		return fmt.Errorf("inject text too long: %d > max_inject_len=%d", len(s), cfg.MaxInjectLen)
 	}
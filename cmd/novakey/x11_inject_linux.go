@@ -0,0 +1,266 @@
+// cmd/novakey/x11_inject_linux.go
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// x11 backend: focus detection and XTEST typing over one X connection (see
+// x11_linux.go). xdotool stays as the fallback backend and for focus
+// detection when the display can't be reached directly.
+
+// x11Window identifies the focused window.
+type x11Window struct {
+	ID    uint32
+	PID   uint32 // 0 if the window doesn't set _NET_WM_PID
+	Title string
+	Class string // WM_CLASS class name
+}
+
+// focusedWindow returns the window the window manager says is active
+// (_NET_ACTIVE_WINDOW), or the input focus without an EWMH window manager.
+func (x *x11Conn) focusedWindow() (x11Window, error) {
+	id, err := x.property32(x.root, "_NET_ACTIVE_WINDOW")
+	if err != nil {
+		return x11Window{}, err
+	}
+	if id == 0 {
+		if id, err = x.inputFocus(); err != nil {
+			return x11Window{}, err
+		}
+	}
+	// None (0) or PointerRoot (1): nothing useful to describe.
+	if id <= 1 {
+		return x11Window{}, fmt.Errorf("no focused window")
+	}
+
+	w := x11Window{ID: id}
+	if w.PID, err = x.property32(id, "_NET_WM_PID"); err != nil {
+		return x11Window{}, err
+	}
+
+	netName, err := x.atom("_NET_WM_NAME")
+	if err != nil {
+		return x11Window{}, err
+	}
+	_, title, err := x.property(id, netName)
+	if err == nil && len(title) == 0 {
+		_, title, err = x.property(id, x11AtomWMName)
+	}
+	if err != nil {
+		return x11Window{}, err
+	}
+	w.Title = string(title)
+
+	// WM_CLASS is "instance\0class\0".
+	_, class, err := x.property(id, x11AtomWMClass)
+	if err != nil {
+		return x11Window{}, err
+	}
+	if parts := strings.Split(string(class), "\x00"); len(parts) >= 2 {
+		w.Class = parts[1]
+	}
+	return w, nil
+}
+
+// x11FocusedTarget is getFocusedTarget over a direct X connection. Windows
+// without _NET_WM_PID (remote clients, some sandboxes) are named by their
+// WM_CLASS.
+func x11FocusedTarget() (focusedTarget, error) {
+	x, err := dialX11(os.Getenv("DISPLAY"))
+	if err != nil {
+		return focusedTarget{}, err
+	}
+	defer x.Close()
+
+	w, err := x.focusedWindow()
+	if err != nil {
+		return focusedTarget{}, err
+	}
+	t := focusedTarget{Proc: w.Class, Title: w.Title, ID: focusIdentity{Window: uint64(w.ID), PID: w.PID}}
+	if w.PID != 0 {
+		t.Proc, err = procName(fmt.Sprint(w.PID))
+	}
+	return t, err
+}
+
+// Keysyms that aren't their character's code point.
+const (
+	xkTab     = 0xff09
+	xkReturn  = 0xff0d
+	xkShiftL  = 0xffe1
+	xkUnicode = 0x01000000
+)
+
+// runeKeysym returns the keysym that types r: Latin-1 keysyms equal the
+// code point, everything else uses the Unicode range.
+func runeKeysym(r rune) uint32 {
+	switch {
+	case r == '\t':
+		return xkTab
+	case r == '\n':
+		return xkReturn
+	case r >= 0x20 && r <= 0x7e, r >= 0xa0 && r <= 0xff:
+		return uint32(r)
+	}
+	return xkUnicode | uint32(r)
+}
+
+// x11Keymap maps keysyms to the keycode (and Shift) that types them with
+// the server's current keyboard mapping.
+type x11Keymap struct {
+	strokes map[uint32]x11Stroke
+	shift   byte // keycode of Shift_L
+}
+
+type x11Stroke struct {
+	code  byte
+	shift bool
+}
+
+// newX11Keymap indexes a GetKeyboardMapping result. Only the first two
+// levels are used (plain and Shift). Unshifted keysyms win when a keysym is
+// on several keys.
+func newX11Keymap(minKeycode byte, mapping [][]uint32) x11Keymap {
+	km := x11Keymap{strokes: map[uint32]x11Stroke{}}
+	for level := range 2 {
+		for i, syms := range mapping {
+			code := minKeycode + byte(i)
+			if len(syms) == 0 {
+				continue
+			}
+			ks := uint32(0)
+			if level < len(syms) {
+				ks = syms[level]
+			}
+			// A lone lowercase Latin-1 letter implies its uppercase on Shift.
+			if level == 1 && ks == 0 && len(syms) > 0 {
+				if l := syms[0]; (l >= 'a' && l <= 'z') || (l >= 0xe0 && l <= 0xfe && l != 0xf7) {
+					ks = l - 0x20
+				}
+			}
+			if ks == 0 {
+				continue
+			}
+			if _, seen := km.strokes[ks]; !seen {
+				km.strokes[ks] = x11Stroke{code: code, shift: level == 1}
+			}
+			if level == 0 && ks == xkShiftL && km.shift == 0 {
+				km.shift = code
+			}
+		}
+	}
+	return km
+}
+
+// translate maps text to strokes before anything is typed. Like the uinput
+// keymap, the error gives the character's position, never the character.
+func (km x11Keymap) translate(text string) ([]x11Stroke, error) {
+	strokes := make([]x11Stroke, 0, len(text))
+	for i, r := range []rune(text) {
+		s, ok := km.strokes[runeKeysym(r)]
+		if !ok || (s.shift && km.shift == 0) {
+			return nil, fmt.Errorf("character %d is not on the X keyboard mapping", i+1)
+		}
+		strokes = append(strokes, s)
+	}
+	return strokes, nil
+}
+
+// x11TypeChunk is how many characters are sent between round trips and
+// forced focus checks, so X errors and focus changes surface early instead
+// of after the whole secret.
+const x11TypeChunk = 16
+
+// typeStrokes sends the strokes with XTEST, checking focus before each chunk
+// and every focusCheckInterval. If it stops early, a held Shift is released
+// before returning; once a key was pressed the error wraps ErrPartiallyTyped.
+func (x *x11Conn) typeStrokes(xtest byte, km x11Keymap, strokes []x11Stroke, focus *focusGuard) (err error) {
+	shiftDown := false
+	typed := false
+	defer func() {
+		if shiftDown {
+			_ = x.fakeKey(xtest, km.shift, false)
+		}
+		if err != nil && typed {
+			err = partiallyTyped(err)
+		}
+	}()
+	for i, s := range strokes {
+		if err := focus.verify(i%x11TypeChunk == 0); err != nil {
+			return err
+		}
+		if s.shift && !shiftDown {
+			if err := x.fakeKey(xtest, km.shift, true); err != nil {
+				return err
+			}
+			shiftDown = true
+		}
+		if err := x.fakeKey(xtest, s.code, true); err != nil {
+			return err
+		}
+		typed = true
+		if err := x.fakeKey(xtest, s.code, false); err != nil {
+			return err
+		}
+		if shiftDown && (i+1 == len(strokes) || !strokes[i+1].shift) {
+			if err := x.fakeKey(xtest, km.shift, false); err != nil {
+				return err
+			}
+			shiftDown = false
+		}
+		if (i+1)%x11TypeChunk == 0 {
+			if err := x.sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return x.sync()
+}
+
+// ---- backend ----
+
+func x11Available(cfg *ServerConfig) error {
+	if waylandSession() {
+		return fmt.Errorf("%w: XTEST can't type into Wayland windows", ErrInjectUnavailableWayland)
+	}
+	if os.Getenv("DISPLAY") == "" {
+		return fmt.Errorf("DISPLAY not set")
+	}
+	return nil
+}
+
+func injectViaX11(cfg *ServerConfig, password string, focus *focusGuard) (InjectMethod, error) {
+	x, err := dialX11(os.Getenv("DISPLAY"))
+	if err != nil {
+		return "", err
+	}
+	defer x.Close()
+
+	xtest, err := x.queryExtension("XTEST")
+	if err != nil {
+		return "", err
+	}
+	mapping, err := x.keyboardMapping()
+	if err != nil {
+		return "", fmt.Errorf("GetKeyboardMapping: %w", err)
+	}
+	km := newX11Keymap(x.minKeycode, mapping)
+	strokes, err := km.translate(password)
+	if err != nil {
+		return "", err
+	}
+
+	if w, err := x.focusedWindow(); err == nil {
+		log.Printf("[linux] x11: typing into window 0x%x (pid=%d class=%q)", w.ID, w.PID, w.Class)
+	}
+	if err := x.typeStrokes(xtest, km, strokes, focus); err != nil {
+		return "", fmt.Errorf("XTEST typing failed: %w", err)
+	}
+	return InjectMethodTyping, nil
+}
@@ -0,0 +1,418 @@
+// cmd/novakey/x11_linux.go
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Minimal X11 client: just enough of the core protocol and XTEST to read the
+// focused window's properties and type into it over one connection, without
+// running xdotool. Requests are synchronous: each reply is read before the
+// next request is sent. The byte order is always little-endian ('l'); the
+// server converts if it has to.
+
+const (
+	x11OpInternAtom         = 16
+	x11OpGetProperty        = 20
+	x11OpGetInputFocus      = 43
+	x11OpQueryExtension     = 98
+	x11OpGetKeyboardMapping = 101
+
+	xtestOpFakeInput = 2
+
+	x11KeyPress   = 2
+	x11KeyRelease = 3
+
+	// Predefined atoms (X11 protocol, appendix B).
+	x11AtomWMName  = 39
+	x11AtomWMClass = 67
+
+	x11Timeout = 2 * time.Second
+)
+
+var x11le = binary.LittleEndian
+
+// x11Error is an X protocol error reply.
+type x11Error struct {
+	Code   byte
+	Opcode byte
+	Value  uint32
+}
+
+func (e *x11Error) Error() string {
+	return fmt.Sprintf("X error %d on request %d (value 0x%x)", e.Code, e.Opcode, e.Value)
+}
+
+type x11Conn struct {
+	conn       net.Conn
+	rd         *bufio.Reader
+	seq        uint16
+	idBase     uint32 // first resource ID this client may allocate
+	root       uint32
+	minKeycode byte
+	maxKeycode byte
+	atoms      map[string]uint32
+	// First error for a request that has no reply (XTEST FakeInput); they
+	// arrive asynchronously and are reported by sync.
+	asyncErr error
+}
+
+// dialX11 connects to display ("host:N.S"; DISPLAY format) with the
+// MIT-MAGIC-COOKIE-1 from the Xauthority file, if there is one.
+func dialX11(display string) (*x11Conn, error) {
+	host, num, err := parseX11Display(display)
+	if err != nil {
+		return nil, err
+	}
+
+	var c net.Conn
+	if host == "" || host == "unix" {
+		path := "/tmp/.X11-unix/X" + num
+		if c, err = net.DialTimeout("unix", path, x11Timeout); err != nil {
+			// Xorg also listens on the abstract socket of the same name.
+			if ac, aerr := net.DialTimeout("unix", "@"+path, x11Timeout); aerr == nil {
+				c, err = ac, nil
+			}
+		}
+	} else {
+		n, _ := strconv.Atoi(num)
+		c, err = net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(6000+n)), x11Timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect to X display %q: %w", display, err)
+	}
+
+	// Over TCP the cookie is picked by the address actually connected to, as
+	// Xlib does, so one host's cookie is never sent to another.
+	var remote net.IP
+	if a, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		remote = a.IP
+	}
+	name, data := x11AuthCookie(xauthorityPath(), remote, num)
+	x := &x11Conn{conn: c, rd: bufio.NewReader(c), atoms: map[string]uint32{}}
+	if err := x.setup(name, data); err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("X display %q: %w", display, err)
+	}
+	return x, nil
+}
+
+func (x *x11Conn) Close() error { return x.conn.Close() }
+
+// parseX11Display splits DISPLAY into host and display number.
+func parseX11Display(d string) (host, num string, err error) {
+	i := strings.LastIndexByte(d, ':')
+	if i < 0 {
+		return "", "", fmt.Errorf("bad DISPLAY %q", d)
+	}
+	host, num = d[:i], d[i+1:]
+	if j := strings.IndexByte(num, '.'); j >= 0 {
+		num = num[:j]
+	}
+	if _, err := strconv.ParseUint(num, 10, 16); err != nil {
+		return "", "", fmt.Errorf("bad DISPLAY %q", d)
+	}
+	return host, num, nil
+}
+
+func xauthorityPath() string {
+	if p := os.Getenv("XAUTHORITY"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".Xauthority")
+}
+
+// Xauthority address families.
+const (
+	xauthFamilyInternet  = 0
+	xauthFamilyInternet6 = 6
+	xauthFamilyLocal     = 256
+	xauthFamilyWild      = 65535
+)
+
+// x11AuthCookie returns the MIT-MAGIC-COOKIE-1 for the display from the
+// Xauthority file at path. remote is the server's address for a TCP
+// connection, nil for a local socket; an Internet entry only matches its own
+// address, and a loopback address counts as local. It returns empty values if
+// there is none; the server may still let us in (xhost, si:localuser).
+func x11AuthCookie(path string, remote net.IP, num string) (name string, data []byte) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil
+	}
+	local := remote == nil || remote.IsLoopback()
+	hostname, _ := os.Hostname()
+
+	r := bytes.NewReader(b)
+	field := func() ([]byte, error) {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		f := make([]byte, n)
+		_, err := io.ReadFull(r, f)
+		return f, err
+	}
+	for {
+		var family uint16
+		if err := binary.Read(r, binary.BigEndian, &family); err != nil {
+			return "", nil
+		}
+		addr, err1 := field()
+		number, err2 := field()
+		authName, err3 := field()
+		authData, err4 := field()
+		if err := errors.Join(err1, err2, err3, err4); err != nil {
+			return "", nil
+		}
+		if string(authName) != "MIT-MAGIC-COOKIE-1" || (len(number) > 0 && string(number) != num) {
+			continue
+		}
+		switch {
+		case family == xauthFamilyWild:
+		case local && family == xauthFamilyLocal && string(addr) == hostname:
+		case remote != nil && family == xauthFamilyInternet && len(addr) == net.IPv4len && remote.Equal(net.IP(addr)):
+		case remote != nil && family == xauthFamilyInternet6 && len(addr) == net.IPv6len && remote.Equal(net.IP(addr)):
+		default:
+			continue
+		}
+		return string(authName), authData
+	}
+}
+
+func pad4(n int) int { return (4 - n%4) % 4 }
+
+func (x *x11Conn) setup(authName string, authData []byte) error {
+	req := make([]byte, 12)
+	req[0] = 'l'
+	x11le.PutUint16(req[2:], 11)
+	x11le.PutUint16(req[6:], uint16(len(authName)))
+	x11le.PutUint16(req[8:], uint16(len(authData)))
+	req = append(req, authName...)
+	req = append(req, make([]byte, pad4(len(authName)))...)
+	req = append(req, authData...)
+	req = append(req, make([]byte, pad4(len(authData)))...)
+
+	_ = x.conn.SetDeadline(time.Now().Add(x11Timeout))
+	defer func() { _ = x.conn.SetDeadline(time.Time{}) }()
+	if _, err := x.conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(x.rd, head); err != nil {
+		return fmt.Errorf("setup reply: %w", err)
+	}
+	body := make([]byte, 4*int(x11le.Uint16(head[6:])))
+	if _, err := io.ReadFull(x.rd, body); err != nil {
+		return fmt.Errorf("setup reply: %w", err)
+	}
+	switch head[0] {
+	case 1:
+	case 0:
+		n := min(int(head[1]), len(body))
+		return fmt.Errorf("connection refused: %s", body[:n])
+	default:
+		return fmt.Errorf("connection refused: %s", bytes.TrimRight(body, "\x00"))
+	}
+
+	if len(body) < 32 {
+		return fmt.Errorf("short setup reply")
+	}
+	x.idBase = x11le.Uint32(body[4:])
+	vendorLen := int(x11le.Uint16(body[16:]))
+	nFormats := int(body[21])
+	x.minKeycode, x.maxKeycode = body[26], body[27]
+	off := 32 + vendorLen + pad4(vendorLen) + 8*nFormats
+	if len(body) < off+4 {
+		return fmt.Errorf("short setup reply")
+	}
+	x.root = x11le.Uint32(body[off:])
+	return nil
+}
+
+// send writes one request. body must already be padded to 4 bytes.
+func (x *x11Conn) send(op, data byte, body []byte) (uint16, error) {
+	req := make([]byte, 4, 4+len(body))
+	req[0], req[1] = op, data
+	x11le.PutUint16(req[2:], uint16((4+len(body))/4))
+	req = append(req, body...)
+	_ = x.conn.SetWriteDeadline(time.Now().Add(x11Timeout))
+	if _, err := x.conn.Write(req); err != nil {
+		return 0, err
+	}
+	x.seq++
+	return x.seq, nil
+}
+
+// call sends a request and returns its reply (32 bytes plus extra data).
+func (x *x11Conn) call(op, data byte, body []byte) ([]byte, error) {
+	seq, err := x.send(op, data, body)
+	if err != nil {
+		return nil, err
+	}
+	_ = x.conn.SetReadDeadline(time.Now().Add(x11Timeout))
+	defer func() { _ = x.conn.SetReadDeadline(time.Time{}) }()
+	for {
+		b := make([]byte, 32)
+		if _, err := io.ReadFull(x.rd, b); err != nil {
+			return nil, err
+		}
+		switch {
+		case b[0] == 0: // error
+			e := &x11Error{Code: b[1], Value: x11le.Uint32(b[4:]), Opcode: b[10]}
+			if x11le.Uint16(b[2:]) == seq {
+				return nil, e
+			}
+			if x.asyncErr == nil {
+				x.asyncErr = e
+			}
+		case b[0] == 1 || b[0]&0x7f == 35: // reply, or GenericEvent
+			extra := make([]byte, 4*int(x11le.Uint32(b[4:])))
+			if _, err := io.ReadFull(x.rd, extra); err != nil {
+				return nil, err
+			}
+			if b[0] == 1 && x11le.Uint16(b[2:]) == seq {
+				return append(b, extra...), nil
+			}
+		}
+		// Anything else is an event; we select none, so drop it.
+	}
+}
+
+// sync waits until the server has processed every request sent so far and
+// returns the first error any of them caused.
+func (x *x11Conn) sync() error {
+	if _, err := x.call(x11OpGetInputFocus, 0, nil); err != nil {
+		return err
+	}
+	err := x.asyncErr
+	x.asyncErr = nil
+	return err
+}
+
+func (x *x11Conn) atom(name string) (uint32, error) {
+	if a, ok := x.atoms[name]; ok {
+		return a, nil
+	}
+	body := make([]byte, 4, 4+len(name)+3)
+	x11le.PutUint16(body, uint16(len(name)))
+	body = append(body, name...)
+	body = append(body, make([]byte, pad4(len(name)))...)
+	r, err := x.call(x11OpInternAtom, 0, body)
+	if err != nil {
+		return 0, fmt.Errorf("InternAtom %s: %w", name, err)
+	}
+	a := x11le.Uint32(r[8:])
+	x.atoms[name] = a
+	return a, nil
+}
+
+// property returns the value of a window property (empty if unset).
+func (x *x11Conn) property(win, prop uint32) (format byte, value []byte, err error) {
+	body := make([]byte, 20)
+	x11le.PutUint32(body[0:], win)
+	x11le.PutUint32(body[4:], prop)
+	// body[8:12] type = AnyPropertyType, body[12:16] offset = 0
+	x11le.PutUint32(body[16:], 4096) // length in 4-byte units
+	r, err := x.call(x11OpGetProperty, 0, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	format = r[1]
+	n := int(x11le.Uint32(r[16:])) * int(format) / 8
+	if n > len(r)-32 {
+		return 0, nil, fmt.Errorf("short GetProperty reply")
+	}
+	return format, r[32 : 32+n], nil
+}
+
+// property32 returns the first item of a format-32 property, 0 if unset.
+func (x *x11Conn) property32(win uint32, name string) (uint32, error) {
+	a, err := x.atom(name)
+	if err != nil {
+		return 0, err
+	}
+	format, v, err := x.property(win, a)
+	if err != nil || format != 32 || len(v) < 4 {
+		return 0, err
+	}
+	return x11le.Uint32(v), nil
+}
+
+func (x *x11Conn) inputFocus() (uint32, error) {
+	r, err := x.call(x11OpGetInputFocus, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	return x11le.Uint32(r[8:]), nil
+}
+
+// queryExtension returns the major opcode of a server extension.
+func (x *x11Conn) queryExtension(name string) (byte, error) {
+	body := make([]byte, 4, 4+len(name)+3)
+	x11le.PutUint16(body, uint16(len(name)))
+	body = append(body, name...)
+	body = append(body, make([]byte, pad4(len(name)))...)
+	r, err := x.call(x11OpQueryExtension, 0, body)
+	if err != nil {
+		return 0, err
+	}
+	if r[8] == 0 {
+		return 0, fmt.Errorf("X server has no %s extension", name)
+	}
+	return r[9], nil
+}
+
+// keyboardMapping returns the keysyms of every keycode, indexed from
+// x.minKeycode.
+func (x *x11Conn) keyboardMapping() ([][]uint32, error) {
+	count := int(x.maxKeycode) - int(x.minKeycode) + 1
+	body := []byte{x.minKeycode, byte(count), 0, 0}
+	r, err := x.call(x11OpGetKeyboardMapping, 0, body)
+	if err != nil {
+		return nil, err
+	}
+	per := int(r[1])
+	if len(r) < 32+4*per*count {
+		return nil, fmt.Errorf("short GetKeyboardMapping reply")
+	}
+	m := make([][]uint32, count)
+	for i := range m {
+		m[i] = make([]uint32, per)
+		for j := range per {
+			m[i][j] = x11le.Uint32(r[32+4*(i*per+j):])
+		}
+	}
+	return m, nil
+}
+
+// fakeKey sends an XTEST key event to whatever has focus.
+func (x *x11Conn) fakeKey(xtest byte, keycode byte, down bool) error {
+	body := make([]byte, 32)
+	body[0] = x11KeyRelease
+	if down {
+		body[0] = x11KeyPress
+	}
+	body[1] = keycode
+	// time = CurrentTime, root/coordinates unused for key events.
+	_, err := x.send(xtest, xtestOpFakeInput, body)
+	return err
+}
@@ -0,0 +1,282 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseX11Display(t *testing.T) {
+	cases := []struct{ in, host, num string }{
+		{":0", "", "0"},
+		{":1.0", "", "1"},
+		{"unix:2", "unix", "2"},
+		{"localhost:10.0", "localhost", "10"},
+	}
+	for _, c := range cases {
+		host, num, err := parseX11Display(c.in)
+		if err != nil || host != c.host || num != c.num {
+			t.Errorf("%q: got %q %q %v, want %q %q", c.in, host, num, err, c.host, c.num)
+		}
+	}
+	for _, bad := range []string{"", "0", ":x", "host:"} {
+		if _, _, err := parseX11Display(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestX11AuthCookie(t *testing.T) {
+	var b bytes.Buffer
+	entry := func(family uint16, addr, num, name string, data []byte) {
+		_ = binary.Write(&b, binary.BigEndian, family)
+		for _, f := range [][]byte{[]byte(addr), []byte(num), []byte(name), data} {
+			_ = binary.Write(&b, binary.BigEndian, uint16(len(f)))
+			b.Write(f)
+		}
+	}
+	entry(xauthFamilyLocal, "some-other-host", "0", "MIT-MAGIC-COOKIE-1", []byte("wrong-host"))
+	entry(xauthFamilyWild, "", "1", "MIT-MAGIC-COOKIE-1", []byte("display-one"))
+	entry(xauthFamilyWild, "", "0", "XDM-AUTHORIZATION-1", []byte("other-scheme"))
+	entry(xauthFamilyWild, "", "0", "MIT-MAGIC-COOKIE-1", []byte("display-zero"))
+
+	path := filepath.Join(t.TempDir(), "Xauthority")
+	if err := os.WriteFile(path, b.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if name, data := x11AuthCookie(path, nil, "0"); name != "MIT-MAGIC-COOKIE-1" || string(data) != "display-zero" {
+		t.Fatalf("got %q %q", name, data)
+	}
+	if _, data := x11AuthCookie(path, nil, "1"); string(data) != "display-one" {
+		t.Fatalf("display 1: got %q", data)
+	}
+	if name, _ := x11AuthCookie(path, nil, "7"); name != "" {
+		t.Fatalf("display 7: got cookie %q", name)
+	}
+}
+
+func TestX11AuthCookie_RemoteHostGetsOnlyItsOwnCookie(t *testing.T) {
+	var b bytes.Buffer
+	entry := func(family uint16, addr []byte, num, data string) {
+		_ = binary.Write(&b, binary.BigEndian, family)
+		for _, f := range [][]byte{addr, []byte(num), []byte("MIT-MAGIC-COOKIE-1"), []byte(data)} {
+			_ = binary.Write(&b, binary.BigEndian, uint16(len(f)))
+			b.Write(f)
+		}
+	}
+	entry(xauthFamilyInternet, net.ParseIP("192.0.2.1").To4(), "0", "host-a")
+	entry(xauthFamilyInternet6, net.ParseIP("2001:db8::2"), "0", "host-b6")
+
+	path := filepath.Join(t.TempDir(), "Xauthority")
+	if err := os.WriteFile(path, b.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, data := x11AuthCookie(path, net.ParseIP("192.0.2.1"), "0"); string(data) != "host-a" {
+		t.Fatalf("host A: got %q", data)
+	}
+	if _, data := x11AuthCookie(path, net.ParseIP("2001:db8::2"), "0"); string(data) != "host-b6" {
+		t.Fatalf("host B (v6): got %q", data)
+	}
+	if name, data := x11AuthCookie(path, net.ParseIP("192.0.2.99"), "0"); name != "" {
+		t.Fatalf("host A's cookie sent to another host: %q", data)
+	}
+}
+
+func TestX11Keymap_Translate(t *testing.T) {
+	const minKeycode = 8
+	mapping := [][]uint32{
+		{'a', 'A'},
+		{'b'}, // uppercase implied
+		{'1', '!'},
+		{},
+		{xkShiftL},
+		{'!'}, // unshifted '!' on another key wins
+		{xkReturn},
+	}
+	km := newX11Keymap(minKeycode, mapping)
+	if km.shift != minKeycode+4 {
+		t.Fatalf("shift keycode = %d", km.shift)
+	}
+
+	got, err := km.translate("aB1!\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []x11Stroke{{8, false}, {9, true}, {10, false}, {13, false}, {14, false}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	_, err = km.translate("ab€")
+	if err == nil || !strings.Contains(err.Error(), "character 3") || strings.Contains(err.Error(), "€") {
+		t.Fatalf("err = %v, want position without the character", err)
+	}
+}
+
+// startXvfb runs a private Xvfb and points DISPLAY at it.
+func startXvfb(t *testing.T) {
+	t.Helper()
+	bin, err := exec.LookPath("Xvfb")
+	if err != nil {
+		t.Skip("Xvfb not installed")
+	}
+	num := fmt.Sprint(90 + os.Getpid()%400)
+	cmd := exec.Command(bin, ":"+num, "-nolisten", "tcp", "-screen", "0", "640x480x24")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start Xvfb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	t.Setenv("DISPLAY", ":"+num)
+	t.Setenv("XAUTHORITY", filepath.Join(t.TempDir(), "none"))
+	t.Setenv("XDG_SESSION_TYPE", "x11")
+	t.Setenv("WAYLAND_DISPLAY", "")
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if x, err := dialX11(":" + num); err == nil {
+			_ = x.Close()
+			return
+		}
+	}
+	t.Fatalf("Xvfb :%s did not come up", num)
+}
+
+func (x *x11Conn) testChangeProperty(t *testing.T, win uint32, prop, typ string, predefType uint32, format byte, value []byte) {
+	t.Helper()
+	p, err := x.atom(prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != "" {
+		if predefType, err = x.atom(typ); err != nil {
+			t.Fatal(err)
+		}
+	}
+	body := make([]byte, 20, 20+len(value)+3)
+	x11le.PutUint32(body[0:], win)
+	x11le.PutUint32(body[4:], p)
+	x11le.PutUint32(body[8:], predefType)
+	body[12] = format
+	x11le.PutUint32(body[16:], uint32(len(value)*8/int(format)))
+	body = append(body, value...)
+	body = append(body, make([]byte, pad4(len(value)))...)
+	if _, err := x.send(18, 0, body); err != nil { // ChangeProperty, Replace
+		t.Fatal(err)
+	}
+}
+
+// TestX11_TypesIntoFocusedWindow creates a focused window on Xvfb, checks
+// that focus detection describes it, then types with the x11 backend and
+// reads the key events back.
+func TestX11_TypesIntoFocusedWindow(t *testing.T) {
+	startXvfb(t)
+
+	x, err := dialX11(os.Getenv("DISPLAY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+
+	// CreateWindow (1) selecting KeyPress events (event-mask 0x800 = 1).
+	wid := x.idBase + 1
+	body := make([]byte, 32)
+	x11le.PutUint32(body[0:], wid)
+	x11le.PutUint32(body[4:], x.root)
+	x11le.PutUint16(body[12:], 100) // width
+	x11le.PutUint16(body[14:], 100) // height
+	x11le.PutUint16(body[18:], 1)   // InputOutput
+	x11le.PutUint32(body[24:], 0x800)
+	x11le.PutUint32(body[28:], 1)
+	if _, err := x.send(1, 0, body); err != nil {
+		t.Fatal(err)
+	}
+
+	var win, pid [4]byte
+	x11le.PutUint32(win[:], wid)
+	x11le.PutUint32(pid[:], uint32(os.Getpid()))
+	x.testChangeProperty(t, x.root, "_NET_ACTIVE_WINDOW", "", 33, 32, win[:]) // WINDOW
+	x.testChangeProperty(t, wid, "_NET_WM_PID", "", 6, 32, pid[:])            // CARDINAL
+	x.testChangeProperty(t, wid, "_NET_WM_NAME", "UTF8_STRING", 0, 8, []byte("NovaKey test"))
+	x.testChangeProperty(t, wid, "WM_CLASS", "", 31, 8, []byte("novakey-test\x00NovaKeyTest\x00")) // STRING
+
+	if _, err := x.send(8, 0, win[:]); err != nil { // MapWindow
+		t.Fatal(err)
+	}
+	focus := append(win[:], 0, 0, 0, 0)             // window, CurrentTime
+	if _, err := x.send(42, 2, focus); err != nil { // SetInputFocus, RevertToParent
+		t.Fatal(err)
+	}
+	if err := x.sync(); err != nil {
+		t.Fatalf("setting up the window: %v", err)
+	}
+
+	target, err := x11FocusedTarget()
+	if err != nil {
+		t.Fatalf("x11FocusedTarget: %v", err)
+	}
+	if wantProc, _ := procName(fmt.Sprint(os.Getpid())); target.Proc != wantProc || target.Title != "NovaKey test" {
+		t.Fatalf("got proc=%q title=%q, want %q %q", target.Proc, target.Title, wantProc, "NovaKey test")
+	}
+	if want := (focusIdentity{Window: uint64(wid), PID: uint32(os.Getpid())}); target.ID != want {
+		t.Fatalf("got identity %+v, want %+v", target.ID, want)
+	}
+
+	// Before typing: call() drops the events it reads past.
+	mapping, err := x.keyboardMapping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const text = "Hello, World 1!"
+	if _, err := injectViaX11(&ServerConfig{}, text, nil); err != nil {
+		t.Fatalf("injectViaX11: %v", err)
+	}
+
+	var typed []rune
+	_ = x.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(typed) < len([]rune(text)) {
+		ev := make([]byte, 32)
+		if _, err := io.ReadFull(x.rd, ev); err != nil {
+			t.Fatalf("after %q: %v", string(typed), err)
+		}
+		if ev[0]&0x7f != x11KeyPress {
+			continue
+		}
+		syms := mapping[ev[1]-x.minKeycode]
+		ks := syms[0]
+		if x11le.Uint16(ev[28:])&1 != 0 && len(syms) > 1 { // ShiftMask
+			ks = syms[1]
+		}
+		if ks != xkShiftL {
+			typed = append(typed, rune(ks))
+		}
+	}
+	if string(typed) != text {
+		t.Fatalf("window received %q, want %q", string(typed), text)
+	}
+}
+
+// Target policy and the focus guard must agree on Wayland; otherwise policy
+// approves a window the guard can never see and every inject aborts.
+func TestFocusLookup_WaylandDisplayMeansWayland(t *testing.T) {
+	t.Setenv("XDG_SESSION_TYPE", "x11")
+	t.Setenv("WAYLAND_DISPLAY", "wayland-0")
+
+	if _, err := getFocusedTarget(); err == nil {
+		t.Fatal("getFocusedTarget succeeded with WAYLAND_DISPLAY set")
+	}
+	if _, err := focusedIdentity(); err == nil {
+		t.Fatal("focusedIdentity succeeded with WAYLAND_DISPLAY set")
+	}
+}
@@ -47,7 +47,7 @@ func cmdArm(args []string) int {
 		return 1
 	}
 
-	replyLine, err := sendV3OuterFrame(c.addr, inner)
+	replyLine, err := sendOuterFrame(c.addr, inner)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "send failed: %v\n", err)
 		return 1
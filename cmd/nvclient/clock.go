@@ -0,0 +1,23 @@
+// cmd/nvclient/clock.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// reportClockSkew tells the user how far off the local clock is when the
+// daemon rejected the message timestamp.
+func reportClockSkew(replyLine string) {
+	var r struct {
+		ServerTimeUnix int64 `json:"server_time_unix"`
+	}
+	if err := json.Unmarshal([]byte(replyLine), &r); err != nil || r.ServerTimeUnix == 0 {
+		return
+	}
+	offset := r.ServerTimeUnix - time.Now().Unix()
+	fmt.Fprintf(os.Stderr, "daemon rejected the message timestamp; its clock is %+ds from ours. Retry with:\n", offset)
+	fmt.Fprintf(os.Stderr, "  -clock-offset-sec %d\n", offset)
+}
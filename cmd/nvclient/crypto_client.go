@@ -14,13 +14,25 @@ import (
 )
 
 const (
-	protocolVersion = 3
+	protocolVersionV3 = 3
+	protocolVersionV4 = 4 // adds suite, flags and server key ID to the outer header
+
+	suiteMLKEM768XChaCha = 1
+	outerFlagSealedReply = 0x01 // v4: ask the daemon to seal its reply
 )
 
 var (
 	clientDeviceID  string
 	deviceStaticKey []byte // from devices.json key_hex (32 bytes)
 	serverEncapKey  []byte // ML-KEM-768 encapsulation key (public)
+	serverKID       []byte // sha256(serverEncapKey)[:4], sent in v4 headers
+	outerVersion    byte   = protocolVersionV4
+	sealReplies     bool   // set outerFlagSealedReply (v4 only)
+	clockOffset     int64  // -clock-offset-sec, added to message timestamps
+
+	// From the last encryptOuterFrame; rekey needs them to open new_key_sealed.
+	lastSharedKem []byte
+	lastNonce     []byte
 )
 
 // deviceKeyHex: 32-byte device key (hex), must match devices.json.
@@ -50,6 +62,8 @@ func initCryptoClient(id, deviceKeyHex, serverKyberPubB64 string) error {
 			len(pub), mlkem768.EncapsulationKeySize)
 	}
 	serverEncapKey = pub
+	sum := sha256.Sum256(pub)
+	serverKID = sum[:4]
 
 	return nil
 }
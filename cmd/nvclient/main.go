@@ -18,7 +18,7 @@ import (
 )
 
 const (
-	// IMPORTANT: Outer v3 msgType must remain 1 so the server accepts the frame.
+	// IMPORTANT: Outer msgType must remain 1 so the server accepts the frame.
 	// The "approve vs inject vs arm vs disarm" distinction is carried in the INNER typed message frame.
 	outerMsgTypePassword = 1
 
@@ -27,6 +27,7 @@ const (
 	innerMsgTypeApprove = 2
 	innerMsgTypeArm     = 3
 	innerMsgTypeDisarm  = 4
+	innerMsgTypeRekey   = 5
 )
 
 const routeLineMsg = "NOVAK/1 /msg\n"
@@ -36,15 +37,23 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "  nvclient arm [flags]                 (send typed ARM control message)\n")
 	fmt.Fprintf(os.Stderr, "  nvclient disarm [flags]              (send typed DISARM control message)\n")
 	fmt.Fprintf(os.Stderr, "  nvclient approve [flags]             (send typed APPROVE control message)\n")
+	fmt.Fprintf(os.Stderr, "  nvclient rekey [flags]               (rotate the device key; prints the new key)\n")
 	fmt.Fprintf(os.Stderr, "  nvclient [flags]                     (send typed INJECT/password message)\n\n")
 	fmt.Fprintf(os.Stderr, "Common flags:\n")
 	fmt.Fprintf(os.Stderr, "  -addr                 NovaKey server address (host:port)\n")
 	fmt.Fprintf(os.Stderr, "  -device-id            device ID to use\n")
 	fmt.Fprintf(os.Stderr, "  -key-hex              hex-encoded 32-byte per-device key (matches devices.json)\n")
 	fmt.Fprintf(os.Stderr, "  -server-kyber-pub-b64 base64 ML-KEM-768 public key (kyber768_public)\n")
-	fmt.Fprintf(os.Stderr, "  -password             secret to send (inject only)\n\n")
+	fmt.Fprintf(os.Stderr, "  -password             secret to send (inject only)\n")
+	fmt.Fprintf(os.Stderr, "  -proto                outer frame version, 3 or 4 (default 4)\n")
+	fmt.Fprintf(os.Stderr, "  -seal-reply           ask for a sealed (encrypted, authenticated) reply; v4 only (default true)\n")
+	fmt.Fprintf(os.Stderr, "  -clock-offset-sec     seconds to add to message timestamps (see bad_timestamp replies)\n\n")
 	fmt.Fprintf(os.Stderr, "arm flags:\n")
-	fmt.Fprintf(os.Stderr, "  -ms                   arm duration in ms (default 15000)\n")
+	fmt.Fprintf(os.Stderr, "  -ms                   arm duration in ms (default 15000)\n\n")
+	fmt.Fprintf(os.Stderr, "approve flags:\n")
+	fmt.Fprintf(os.Stderr, "  -request-id           pending_id from a needs_approve reply (request-scoped approve)\n\n")
+	fmt.Fprintf(os.Stderr, "rekey flags:\n")
+	fmt.Fprintf(os.Stderr, "  -new-key-hex          propose this 32-byte key (default: server generates one)\n")
 }
 
 type commonArgs struct {
@@ -53,6 +62,9 @@ type commonArgs struct {
 	keyHex            string
 	serverKyberPubB64 string
 	password          string
+	proto             int
+	sealReply         bool
+	clockOffsetSec    int64
 }
 
 func parseCommon(fs *flag.FlagSet) *commonArgs {
@@ -62,6 +74,9 @@ func parseCommon(fs *flag.FlagSet) *commonArgs {
 	fs.StringVar(&c.deviceID, "device-id", "roberts-phone", "device ID to use")
 	fs.StringVar(&c.keyHex, "key-hex", "", "hex-encoded 32-byte per-device key (matches devices.json)")
 	fs.StringVar(&c.serverKyberPubB64, "server-kyber-pub-b64", "", "base64 ML-KEM-768 public key (kyber768_public from server_keys.json or pairing)")
+	fs.IntVar(&c.proto, "proto", protocolVersionV4, "outer frame version (3 or 4)")
+	fs.BoolVar(&c.sealReply, "seal-reply", true, "ask for a sealed reply (v4 only)")
+	fs.Int64Var(&c.clockOffsetSec, "clock-offset-sec", 0, "seconds to add to message timestamps")
 	return c
 }
 
@@ -75,6 +90,12 @@ func requireCryptoInputs(c *commonArgs) {
 	if c.deviceID == "" {
 		log.Fatal("must provide -device-id (non-empty)")
 	}
+	if c.proto != protocolVersionV3 && c.proto != protocolVersionV4 {
+		log.Fatalf("-proto must be %d or %d", protocolVersionV3, protocolVersionV4)
+	}
+	outerVersion = byte(c.proto)
+	sealReplies = c.sealReply && outerVersion == protocolVersionV4
+	clockOffset = c.clockOffsetSec
 }
 
 func main() {
@@ -90,6 +111,9 @@ func main() {
 		case "disarm":
 			os.Exit(cmdDisarm(os.Args[2:]))
 			return
+		case "rekey":
+			os.Exit(cmdRekey(os.Args[2:]))
+			return
 		}
 	}
 
@@ -119,7 +143,7 @@ func main() {
 		log.Fatalf("encodeInnerMessageFrame: %v", err)
 	}
 
-	replyLine, err := sendV3OuterFrame(c.addr, inner)
+	replyLine, err := sendOuterFrame(c.addr, inner)
 	if err != nil {
 		log.Fatalf("send failed: %v", err)
 	}
@@ -139,6 +163,7 @@ func cmdApprove(args []string) int {
 	help2 := fs.Bool("help", false, "show help")
 
 	c := parseCommon(fs)
+	requestID := fs.String("request-id", "", "pending_id from a needs_approve reply (request-scoped approve)")
 	if err := fs.Parse(args); err != nil {
 		if *help || *help2 {
 			usage()
@@ -158,13 +183,26 @@ func cmdApprove(args []string) int {
 		return 1
 	}
 
-	inner, err := encodeInnerMessageFrame(c.deviceID, innerMsgTypeApprove, nil)
+	// payload is optional JSON: {"request_id":"..."}; empty opens a plain approval window
+	var payload []byte
+	if *requestID != "" {
+		b, err := json.Marshal(struct {
+			RequestID string `json:"request_id"`
+		}{*requestID})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "encode approve payload failed: %v\n", err)
+			return 1
+		}
+		payload = b
+	}
+
+	inner, err := encodeInnerMessageFrame(c.deviceID, innerMsgTypeApprove, payload)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "encodeInnerMessageFrame failed: %v\n", err)
 		return 1
 	}
 
-	replyLine, err := sendV3OuterFrame(c.addr, inner)
+	replyLine, err := sendOuterFrame(c.addr, inner)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "send failed: %v\n", err)
 		return 1
@@ -211,7 +249,7 @@ func cmdDisarm(args []string) int {
 		return 1
 	}
 
-	replyLine, err := sendV3OuterFrame(c.addr, inner)
+	replyLine, err := sendOuterFrame(c.addr, inner)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "send failed: %v\n", err)
 		return 1
@@ -224,12 +262,12 @@ func cmdDisarm(args []string) int {
 	return 0
 }
 
-// sendV3OuterFrame sends a single NOVAK/1 routed request to the daemon:
+// sendOuterFrame sends a single NOVAK/1 routed request to the daemon:
 //   route line: "NOVAK/1 /msg\n"
 //   then: [u16 length][payload]
 // and returns the newline-terminated JSON reply line.
-func sendV3OuterFrame(addr string, innerBody []byte) (string, error) {
-	frame, err := encryptV3OuterFrame(innerBody)
+func sendOuterFrame(addr string, innerBody []byte) (string, error) {
+	frame, err := encryptOuterFrame(innerBody)
 	if err != nil {
 		return "", err
 	}
@@ -267,15 +305,23 @@ func sendV3OuterFrame(addr string, innerBody []byte) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("read reply line: %w", err)
 	}
+	if sealReplies {
+		if line, err = openSealedReply(line); err != nil {
+			return "", err
+		}
+	}
+	reportServerKeyUpdate(line)
+	reportClockSkew(line)
 	return line, nil
 }
 
-// encryptV3OuterFrame builds the v3 payload:
+// encryptOuterFrame builds the v4 (or, with -proto 3, v3) payload:
 //
-//   header = version || outerMsgType(=1) || idLen || deviceID || kemCtLen || kemCt   (AAD)
+//   v4 header = version(=4) || outerMsgType(=1) || suite(=1) || flags || kid(4) || idLen || deviceID || kemCtLen || kemCt   (AAD)
+//   v3 header = version(=3) || outerMsgType(=1) || idLen || deviceID || kemCtLen || kemCt   (AAD)
 //   plaintext = timestamp(u64be) || innerBody
 //   out = header || nonce || aead(ciphertext)
-func encryptV3OuterFrame(innerBody []byte) ([]byte, error) {
+func encryptOuterFrame(innerBody []byte) ([]byte, error) {
 	if deviceStaticKey == nil || len(deviceStaticKey) == 0 {
 		return nil, fmt.Errorf("device static key not initialized")
 	}
@@ -307,9 +353,17 @@ func encryptV3OuterFrame(innerBody []byte) ([]byte, error) {
 		return nil, fmt.Errorf("NewX with derived key failed: %w", err)
 	}
 
-	header := make([]byte, 0, 3+len(idBytes)+2+len(kemCt))
-	header = append(header, protocolVersion)
+	header := make([]byte, 0, 9+len(idBytes)+2+len(kemCt))
+	header = append(header, outerVersion)
 	header = append(header, byte(outerMsgTypePassword))
+	if outerVersion == protocolVersionV4 {
+		var flags byte
+		if sealReplies {
+			flags |= outerFlagSealedReply
+		}
+		header = append(header, suiteMLKEM768XChaCha, flags)
+		header = append(header, serverKID...)
+	}
 	header = append(header, idLen)
 	header = append(header, idBytes...)
 
@@ -318,7 +372,7 @@ func encryptV3OuterFrame(innerBody []byte) ([]byte, error) {
 	header = append(header, kemLenBuf[:]...)
 	header = append(header, kemCt...)
 
-	now := time.Now().Unix()
+	now := time.Now().Unix() + clockOffset
 	plaintext := make([]byte, 8+len(innerBody))
 	binary.BigEndian.PutUint64(plaintext[:8], uint64(now))
 	copy(plaintext[8:], innerBody)
@@ -329,6 +383,7 @@ func encryptV3OuterFrame(innerBody []byte) ([]byte, error) {
 	}
 
 	ct := aead.Seal(nil, nonce, plaintext, header)
+	lastSharedKem, lastNonce = sharedKem, nonce
 
 	out := make([]byte, 0, len(header)+len(nonce)+len(ct))
 	out = append(out, header...)
@@ -342,7 +397,7 @@ func encodeInnerMessageFrame(deviceID string, msgType uint8, payload []byte) ([]
 		return nil, fmt.Errorf("deviceID required")
 	}
 	switch msgType {
-	case innerMsgTypeInject, innerMsgTypeApprove, innerMsgTypeArm, innerMsgTypeDisarm:
+	case innerMsgTypeInject, innerMsgTypeApprove, innerMsgTypeArm, innerMsgTypeDisarm, innerMsgTypeRekey:
 	default:
 		return nil, fmt.Errorf("invalid inner msgType=%d", msgType)
 	}
@@ -0,0 +1,121 @@
+// cmd/nvclient/rekey.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+func cmdRekey(args []string) int {
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	fs.Usage = usage
+	fs.SetOutput(os.Stdout)
+
+	help := fs.Bool("h", false, "show help")
+	help2 := fs.Bool("help", false, "show help")
+
+	c := parseCommon(fs)
+	newKeyHex := fs.String("new-key-hex", "", "propose this 32-byte key (default: server generates one)")
+
+	if err := fs.Parse(args); err != nil {
+		if *help || *help2 {
+			usage()
+			return 0
+		}
+		return 2
+	}
+	if *help || *help2 {
+		usage()
+		return 0
+	}
+
+	requireCryptoInputs(c)
+
+	if err := initCryptoClient(c.deviceID, c.keyHex, c.serverKyberPubB64); err != nil {
+		fmt.Fprintf(os.Stderr, "initCryptoClient failed: %v\n", err)
+		return 1
+	}
+
+	// payload is optional JSON: {"new_key_hex":"..."}; empty asks the server to generate one
+	var payload []byte
+	if *newKeyHex != "" {
+		b, err := json.Marshal(struct {
+			NewKeyHex string `json:"new_key_hex"`
+		}{*newKeyHex})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "encode rekey payload failed: %v\n", err)
+			return 1
+		}
+		payload = b
+	}
+
+	inner, err := encodeInnerMessageFrame(c.deviceID, innerMsgTypeRekey, payload)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "encodeInnerMessageFrame failed: %v\n", err)
+		return 1
+	}
+
+	replyLine, err := sendOuterFrame(c.addr, inner)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "send failed: %v\n", err)
+		return 1
+	}
+	fmt.Print(replyLine)
+
+	if st, ok := parseReplyStatus(replyLine); ok && !st.isSuccess() {
+		return 1
+	}
+
+	var r struct {
+		NewKeySealed string `json:"new_key_sealed"`
+	}
+	_ = json.Unmarshal([]byte(replyLine), &r)
+	if r.NewKeySealed == "" {
+		if *newKeyHex != "" {
+			fmt.Printf("new key_hex: %s\n", *newKeyHex)
+		}
+		return 0
+	}
+
+	key, err := openRekeyKey(r.NewKeySealed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open new_key_sealed failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("new key_hex: %s\n", hex.EncodeToString(key))
+	return 0
+}
+
+// openRekeyKey mirrors the server's sealRekeyKey for the request just sent.
+func openRekeyKey(sealedB64 string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(sealedB64)
+	if err != nil {
+		return nil, fmt.Errorf("base64: %w", err)
+	}
+
+	h := hkdf.New(sha256.New, lastSharedKem, deviceStaticKey, []byte("NovaKey v3 rekey wrap"))
+	wrapKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(h, wrapKey); err != nil {
+		return nil, fmt.Errorf("hkdf rekey wrap: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("sealed key too short")
+	}
+
+	nonce, ct := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	aad := append([]byte("NovaKey v3 rekey"), lastNonce...)
+	return aead.Open(nil, nonce, ct, aad)
+}
@@ -0,0 +1,52 @@
+// cmd/nvclient/reply_seal.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// openSealedReply mirrors the daemon's sealReply for the request just sent
+// and returns the reply JSON line inside. A plaintext reply is returned as
+// an error: it is unauthenticated, so its status can't be trusted.
+func openSealedReply(line string) (string, error) {
+	var env struct {
+		V      int    `json:"v"`
+		Sealed string `json:"sealed"`
+	}
+	if err := json.Unmarshal([]byte(line), &env); err != nil || env.Sealed == "" {
+		return "", fmt.Errorf("unauthenticated plaintext reply: %s", strings.TrimSpace(line))
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(env.Sealed)
+	if err != nil {
+		return "", fmt.Errorf("sealed reply: base64: %w", err)
+	}
+
+	h := hkdf.New(sha256.New, lastSharedKem, deviceStaticKey, []byte("NovaKey v4 reply key"))
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(h, key); err != nil {
+		return "", fmt.Errorf("hkdf reply key: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return "", fmt.Errorf("sealed reply too short")
+	}
+
+	aad := append([]byte("NovaKey v4 reply"), lastNonce...)
+	pt, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+	if err != nil {
+		return "", fmt.Errorf("sealed reply does not authenticate: %w", err)
+	}
+	return string(pt) + "\n", nil
+}
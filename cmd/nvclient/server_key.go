@@ -0,0 +1,57 @@
+// cmd/nvclient/server_key.go
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// reportServerKeyUpdate tells the user when the daemon says the pinned server
+// key is retired. The new key is only shown once its MAC checks out.
+func reportServerKeyUpdate(replyLine string) {
+	var r struct {
+		ServerKey *struct {
+			KID           string `json:"kid"`
+			KyberPubB64   string `json:"kyber_pub_b64"`
+			MACB64        string `json:"mac_b64"`
+			StaleKID      string `json:"stale_kid"`
+			StaleNotAfter int64  `json:"stale_not_after_unix"`
+		} `json:"server_key"`
+	}
+	if err := json.Unmarshal([]byte(replyLine), &r); err != nil || r.ServerKey == nil {
+		return
+	}
+	u := r.ServerKey
+
+	pub, err := base64.StdEncoding.DecodeString(u.KyberPubB64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "server_key: bad kyber_pub_b64: %v\n", err)
+		return
+	}
+	mac, _ := base64.StdEncoding.DecodeString(u.MACB64)
+
+	h := hkdf.New(sha256.New, lastSharedKem, deviceStaticKey, []byte("NovaKey v3 server key update"))
+	macKey := make([]byte, 32)
+	if _, err := io.ReadFull(h, macKey); err != nil {
+		fmt.Fprintf(os.Stderr, "server_key: hkdf: %v\n", err)
+		return
+	}
+	m := hmac.New(sha256.New, macKey)
+	m.Write([]byte(u.KID))
+	m.Write(pub)
+	if !hmac.Equal(m.Sum(nil), mac) {
+		fmt.Fprintf(os.Stderr, "server_key: MAC mismatch; ignoring the offered key\n")
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "server key kid=%s is retired (accepted until unix %d); switch to kid=%s:\n",
+		u.StaleKID, u.StaleNotAfter, u.KID)
+	fmt.Fprintf(os.Stderr, "  -server-kyber-pub-b64 %s\n", u.KyberPubB64)
+}
@@ -9,17 +9,43 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/chacha20poly1305"
     "github.com/skip2/go-qrcode"
 )
 
 type deviceConfig struct {
-	ID     string `json:"id"`
-	KeyHex string `json:"key_hex"`
+	ID      string `json:"id"`
+	KeyHex  string `json:"key_hex"`
+	Profile string `json:"profile,omitempty"`
+
+	Role      string   `json:"role,omitempty"`
+	Approvers []string `json:"approvers,omitempty"`
+
+	// Key lifetime and rekey grace key. Kept as-is when nvpair rewrites the
+	// file; -force replaces the key, so it resets them (see rekeyDevice).
+	KeyCreatedUnix   int64  `json:"key_created_unix,omitempty"`
+	ExpiresAtUnix    int64  `json:"expires_at_unix,omitempty"`
+	PrevKeyHex       string `json:"prev_key_hex,omitempty"`
+	PrevKeyUntilUnix int64  `json:"prev_key_until_unix,omitempty"`
+
+	// Store schema v2 metadata (kept as-is when nvpair rewrites the file)
+	Label        string `json:"label,omitempty"`
+	PairedAtUnix int64  `json:"paired_at_unix,omitempty"`
+	PairedFrom   string `json:"paired_from,omitempty"`
+	Platform     string `json:"platform,omitempty"`
+	AppVersion   string `json:"app_version,omitempty"`
+	LastSeenUnix int64  `json:"last_seen_unix,omitempty"`
+	MsgCount     uint64 `json:"msg_count,omitempty"`
 }
 
+// devicesStoreVersion matches the daemon's device store schema.
+const devicesStoreVersion = 2
+
 type devicesConfigFile struct {
+	Version int            `json:"version,omitempty"`
 	Devices []deviceConfig `json:"devices"`
 }
 
@@ -47,6 +73,10 @@ var (
     configFileFlag    = flag.String("config-file", "server_config.json", "path to server_config.json")
     deviceIDFlag      = flag.String("id", "", "device ID to add or update (required)")
     forceFlag         = flag.Bool("force", false, "overwrite existing device with same ID")
+    profileFlag       = flag.String("profile", "", "policy profile name for this device (see profiles in server config)")
+    roleFlag          = flag.String("role", "", "device role: injector, approver or both (default both)")
+    approversFlag     = flag.String("approvers", "", "comma-separated device IDs allowed to approve this device's injects (two_man_mode=dual)")
+    labelFlag         = flag.String("label", "", "human label for this device (e.g. \"Alice's iPhone\")")
     qrFlag            = flag.Bool("qr", true, "render pairing info as an ASCII QR code")
 )
 
@@ -58,6 +88,12 @@ func main() {
         flag.Usage()
         os.Exit(1)
     }
+    role, err := normalizeRole(*roleFlag)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "ERROR: -role: %v\n", err)
+        os.Exit(1)
+    }
+    approvers := splitList(*approversFlag)
 
     // 1. Load or create devices.json
     devicesPath := *devicesFileFlag
@@ -73,6 +109,9 @@ func main() {
             os.Exit(1)
         }
     }
+    for _, id := range unknownDevices(cfg, approvers) {
+        fmt.Fprintf(os.Stderr, "WARNING: approver %q is not in %s (pair it before it can approve)\n", id, absDevices)
+    }
 
     // 2. Generate new random per-device key
     keyBytes := make([]byte, chacha20poly1305.KeySize)
@@ -97,17 +136,39 @@ func main() {
         os.Exit(1)
     }
 
+    now := time.Now().Unix()
     if existingIdx >= 0 && *forceFlag {
-        cfg.Devices[existingIdx].KeyHex = keyHex
+        rekeyDevice(&cfg.Devices[existingIdx], keyHex, now)
+        if *profileFlag != "" {
+            cfg.Devices[existingIdx].Profile = *profileFlag
+        }
+        if role != "" {
+            cfg.Devices[existingIdx].Role = role
+        }
+        if len(approvers) > 0 {
+            cfg.Devices[existingIdx].Approvers = approvers
+        }
+        if *labelFlag != "" {
+            cfg.Devices[existingIdx].Label = *labelFlag
+        }
+        cfg.Devices[existingIdx].PairedAtUnix = now
         fmt.Printf("Updated existing device %q in %s\n", *deviceIDFlag, absDevices)
     } else if existingIdx == -1 {
         cfg.Devices = append(cfg.Devices, deviceConfig{
-            ID:     *deviceIDFlag,
-            KeyHex: keyHex,
+            ID:             *deviceIDFlag,
+            KeyHex:         keyHex,
+            Profile:        *profileFlag,
+            Role:           role,
+            Approvers:      approvers,
+            Label:          *labelFlag,
+            KeyCreatedUnix: now,
+            PairedAtUnix:   now,
+            PairedFrom:     "nvpair",
         })
         fmt.Printf("Added new device %q to %s\n", *deviceIDFlag, absDevices)
     }
 
+    cfg.Version = devicesStoreVersion
     if err := saveDevices(devicesPath, cfg); err != nil {
         fmt.Fprintf(os.Stderr, "ERROR: saving devices file %s: %v\n", absDevices, err)
         os.Exit(1)
@@ -172,6 +233,43 @@ func main() {
     fmt.Println("Use this pairing info in your phone app to configure NovaKey v3.")
 }
 
+// rekeyDevice gives d a new key. The old key's age, explicit expiry and any
+// rekey grace key belong to keys that no longer exist.
+func rekeyDevice(d *deviceConfig, keyHex string, now int64) {
+    d.KeyHex = keyHex
+    d.KeyCreatedUnix, d.ExpiresAtUnix = now, 0
+    d.PrevKeyHex, d.PrevKeyUntilUnix = "", 0
+}
+
+// normalizeRole checks -role the way the daemon reads a device's role:
+// case-insensitive injector, approver or both. Empty means the default (both).
+func normalizeRole(role string) (string, error) {
+    switch r := strings.ToLower(strings.TrimSpace(role)); r {
+    case "", "injector", "approver", "both":
+        return r, nil
+    default:
+        return "", fmt.Errorf("unknown role %q (want injector, approver or both)", role)
+    }
+}
+
+// unknownDevices returns the IDs in ids that aren't in cfg.
+func unknownDevices(cfg *devicesConfigFile, ids []string) []string {
+    var out []string
+    for _, id := range ids {
+        found := false
+        for _, d := range cfg.Devices {
+            if d.ID == id {
+                found = true
+                break
+            }
+        }
+        if !found {
+            out = append(out, id)
+        }
+    }
+    return out
+}
+
 func loadDevices(path string) (*devicesConfigFile, error) {
     data, err := os.ReadFile(path)
     if err != nil {
@@ -232,3 +330,12 @@ func loadServerKeys(path string) (*serverKeys, error) {
     return &sk, nil
 }
 
+func splitList(s string) []string {
+    var out []string
+    for _, p := range strings.Split(s, ",") {
+        if p = strings.TrimSpace(p); p != "" {
+            out = append(out, p)
+        }
+    }
+    return out
+}
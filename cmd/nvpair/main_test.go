@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// A device record with every field the daemon writes (cmd/novakey deviceConfig).
+const fullDeviceStore = `{
+  "version": 2,
+  "devices": [
+    {
+      "id": "phone",
+      "key_hex": "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+      "profile": "browsers",
+      "role": "injector",
+      "approvers": ["admin-phone"],
+      "key_created_unix": 1700000000,
+      "expires_at_unix": 1800000000,
+      "prev_key_hex": "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100",
+      "prev_key_until_unix": 1700000600,
+      "label": "Alice's phone",
+      "paired_at_unix": 1690000000,
+      "paired_from": "192.0.2.10",
+      "platform": "ios",
+      "app_version": "3.1",
+      "last_seen_unix": 1700000100,
+      "msg_count": 42
+    }
+  ]
+}`
+
+func TestSaveDevices_RoundTripKeepsEveryField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	if err := os.WriteFile(path, []byte(fullDeviceStore), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadDevices(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveDevices(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var before, after map[string]any
+	if err := json.Unmarshal([]byte(fullDeviceStore), &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &after); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Fatalf("store changed by an nvpair rewrite:\nbefore %v\nafter  %v", before, after)
+	}
+}
+
+func TestRekeyDevice_ResetsKeyLifetime(t *testing.T) {
+	d := deviceConfig{
+		ID:               "phone",
+		KeyHex:           "old",
+		KeyCreatedUnix:   1700000000,
+		ExpiresAtUnix:    1800000000,
+		PrevKeyHex:       "older",
+		PrevKeyUntilUnix: 1700000600,
+		Label:            "Alice's phone",
+	}
+	rekeyDevice(&d, "new", 1750000000)
+
+	want := deviceConfig{ID: "phone", KeyHex: "new", KeyCreatedUnix: 1750000000, Label: "Alice's phone"}
+	if !reflect.DeepEqual(d, want) {
+		t.Fatalf("got %+v, want %+v", d, want)
+	}
+}
+
+func TestNormalizeRole_MatchesDaemon(t *testing.T) {
+	for in, want := range map[string]string{"": "", "Injector": "injector", " approver ": "approver", "BOTH": "both"} {
+		if got, err := normalizeRole(in); err != nil || got != want {
+			t.Errorf("normalizeRole(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := normalizeRole("approve"); err == nil {
+		t.Fatal("typo in -role accepted; the daemon would refuse the whole store")
+	}
+}
+
+func TestUnknownDevices(t *testing.T) {
+	cfg := &devicesConfigFile{Devices: []deviceConfig{{ID: "phone"}, {ID: "admin-phone"}}}
+	got := unknownDevices(cfg, []string{"admin-phone", "admn-phone"})
+	if !reflect.DeepEqual(got, []string{"admn-phone"}) {
+		t.Fatalf("unknownDevices = %v", got)
+	}
+}